@@ -0,0 +1,452 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/util/progress/progressui"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/craftslab/copatcher/buildkit"
+	"github.com/craftslab/copatcher/config"
+	"github.com/craftslab/copatcher/history"
+	"github.com/craftslab/copatcher/metrics"
+	"github.com/craftslab/copatcher/patcher"
+	"github.com/craftslab/copatcher/report"
+	"github.com/craftslab/copatcher/types"
+)
+
+const (
+	DefaultWorkers = 4
+)
+
+const (
+	outputSep = "://"
+)
+
+// unsafeNameChars are replaced in the job name to get the folder of its outputs, e.g. nginx-1.25 for nginx:1.25.
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Statuses of the jobs.
+const (
+	StatusPatched   = "patched"
+	StatusPartial   = "partial"
+	StatusNoUpdates = "no-updates"
+	StatusFailed    = "failed"
+)
+
+type Batch interface {
+	Init(context.Context) error
+	Deinit(context.Context) error
+	Run(context.Context, string) ([]JobResult, error)
+}
+
+type Config struct {
+	Config config.Config
+	// History records the patch of each job if set.
+	History history.History
+	// Metrics observe the jobs if set.
+	Metrics *metrics.Metrics
+	// Workers is the number of jobs patched concurrently, which overrides the workers of the job file if not 0.
+	Workers int
+}
+
+// File is the job file of the batch.
+type File struct {
+	Workers int   `yaml:"workers"`
+	Jobs    []Job `yaml:"jobs"`
+}
+
+// Job is an image to patch with the updates of its report.
+type Job struct {
+	// Name identifies the job in the summary, which is the image if empty.
+	Name            string            `yaml:"name"`
+	Image           string            `yaml:"image"`
+	Report          string            `yaml:"report"`
+	Tag             string            `yaml:"tag"`
+	MultiPlatform   bool              `yaml:"multiPlatform"`
+	PlatformReports map[string]string `yaml:"platformReports"`
+	// Options override the settings of the config for the job, e.g. output: {push: true}.
+	Options yaml.Node `yaml:"options"`
+}
+
+// JobResult is the status of a job.
+type JobResult struct {
+	Name     string
+	Image    string
+	Status   string
+	Duration time.Duration
+	Err      error
+}
+
+type batch struct {
+	cfg *Config
+}
+
+func New(_ context.Context, cfg *Config) Batch {
+	return &batch{
+		cfg: cfg,
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{}
+}
+
+func (b *batch) Init(_ context.Context) error {
+	return nil
+}
+
+func (b *batch) Deinit(_ context.Context) error {
+	return nil
+}
+
+// Run patches the images of the jobs of the job file by a bounded pool of workers sharing one buildkit client,
+// and returns the result of each job in the order of the file. It returns an error if any job failed.
+func (b *batch) Run(ctx context.Context, name string) ([]JobResult, error) {
+	file, err := Load(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load job file")
+	}
+
+	workers := getWorkers(b.cfg.Workers, file.Workers)
+
+	clt, endpoint, err := buildkit.Connect(ctx, buildkit.Opts{
+		Addr:       b.cfg.Config.Buildkit.Address,
+		CACertPath: b.cfg.Config.Buildkit.CACert,
+		CertPath:   b.cfg.Config.Buildkit.Cert,
+		KeyPath:    b.cfg.Config.Buildkit.Key,
+	})
+	if err != nil {
+		b.cfg.Metrics.ObserveConnect(err)
+		return nil, errors.Wrap(err, "failed to create new client")
+	}
+
+	defer func(c *client.Client) {
+		_ = c.Close()
+	}(clt)
+
+	// The tty display of concurrent solves would overwrite each other
+	ctx = buildkit.WithProgressMode(ctx, progressui.PlainMode)
+
+	log.Printf("patch %d jobs by %d workers", len(file.Jobs), workers)
+
+	results := make([]JobResult, len(file.Jobs))
+	jobs := make(chan int)
+
+	waiting := int64(len(file.Jobs))
+	b.cfg.Metrics.SetQueueDepth(int(waiting))
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				b.cfg.Metrics.SetQueueDepth(int(atomic.AddInt64(&waiting, -1)))
+				results[j] = b.runJob(ctx, &file.Jobs[j], clt, endpoint)
+			}
+		}()
+	}
+
+	for i := range file.Jobs {
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return results, getError(results)
+}
+
+// runJob patches the image of the job with the shared client, in its own working folder of the work dir.
+func (b *batch) runJob(ctx context.Context, job *Job, clt *client.Client, endpoint string) JobResult {
+	res := JobResult{
+		Name:  job.getName(),
+		Image: job.Image,
+	}
+
+	started := time.Now()
+	log.Printf("start job %s", res.Name)
+
+	ctx = b.cfg.Metrics.WithContext(ctx)
+
+	ctx, rec := history.WithRecorder(ctx, &history.Record{
+		ID:              uuid.NewString(),
+		Mode:            history.ModeBatch,
+		Image:           job.Image,
+		Tag:             job.Tag,
+		MultiPlatform:   job.MultiPlatform,
+		Report:          job.Report,
+		PlatformReports: job.PlatformReports,
+	})
+
+	res.Err = b.patch(ctx, job, clt, endpoint)
+	res.Duration = time.Since(started)
+	res.Status = GetStatus(res.Err)
+
+	b.cfg.Metrics.ObserveJob(history.ModeBatch, res.Status, res.Err)
+
+	if b.cfg.History != nil {
+		if err := b.cfg.History.Add(ctx, rec.Finish(res.Status, res.Err)); err != nil {
+			log.Printf("failed to add history of job %s: %v", res.Name, err)
+		}
+	}
+
+	log.Printf("finish job %s: %s", res.Name, res.Status)
+
+	return res
+}
+
+func (b *batch) patch(ctx context.Context, job *Job, clt *client.Client, endpoint string) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "job canceled")
+	}
+
+	cfg, err := getJobConfig(&b.cfg.Config, job)
+	if err != nil {
+		return errors.Wrap(err, "failed to get job config")
+	}
+
+	if err := createOutputFolders(cfg); err != nil {
+		return errors.Wrap(err, "failed to create output folders")
+	}
+
+	c, err := patcher.NewConfig(cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to new config")
+	}
+
+	rc := report.DefaultConfig()
+	rc.Config = *cfg
+
+	c.Address = endpoint
+	c.Client = clt
+	c.Image = job.Image
+	c.MultiPlatform = job.MultiPlatform
+	c.PlatformReports = job.PlatformReports
+	c.Report = report.New(ctx, rc)
+
+	pt := patcher.New(ctx, c)
+
+	if err := pt.Init(ctx); err != nil {
+		return errors.Wrap(err, "failed to init")
+	}
+
+	defer func(pt patcher.Patcher, ctx context.Context) {
+		_ = pt.Deinit(ctx)
+	}(pt, ctx)
+
+	return pt.Run(ctx, job.Report)
+}
+
+// Load reads and checks the job file.
+func Load(name string) (*File, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read file")
+	}
+
+	var f File
+
+	if err := yaml.Unmarshal(buf, &f); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal file")
+	}
+
+	if len(f.Jobs) == 0 {
+		return nil, errors.New("no jobs")
+	}
+
+	folders := map[string]bool{}
+
+	for i := range f.Jobs {
+		if f.Jobs[i].Image == "" {
+			return nil, errors.Errorf("image of job %d is required", i+1)
+		}
+		if f.Jobs[i].Report == "" {
+			return nil, errors.Errorf("report of job %d is required", i+1)
+		}
+		// The jobs write the outputs inherited from the profile into the folders of their names
+		folder := f.Jobs[i].getFolder()
+		if folders[folder] {
+			return nil, errors.Errorf("name %s of job %d is not unique", f.Jobs[i].getName(), i+1)
+		}
+		folders[folder] = true
+	}
+
+	return &f, nil
+}
+
+// getJobConfig returns the config overlaid by the options and tag of the job. The outputs inherited from the config
+// are moved into the folder of the job, not to be overwritten by the other jobs.
+func getJobConfig(cfg *config.Config, job *Job) (*config.Config, error) {
+	out := cfg.Clone()
+
+	if !job.Options.IsZero() {
+		if err := job.Options.Decode(out); err != nil {
+			return nil, errors.Wrap(err, "failed to decode options")
+		}
+	}
+
+	if job.Tag != "" {
+		out.Output.Tag = job.Tag
+	}
+
+	folder := job.getFolder()
+
+	for _, o := range []struct {
+		inherited string
+		value     *string
+	}{
+		{cfg.Output.Output, &out.Output.Output},
+		{cfg.Output.ProvenanceOutput, &out.Output.ProvenanceOutput},
+		{cfg.Output.ResultOutput, &out.Output.ResultOutput},
+		{cfg.Output.SBOMOutput, &out.Output.SBOMOutput},
+		{cfg.Output.VEXOutput, &out.Output.VEXOutput},
+	} {
+		if *o.value != "" && *o.value == o.inherited {
+			*o.value = getJobOutput(*o.value, folder)
+		}
+	}
+
+	return out, nil
+}
+
+// getJobOutput returns the output in the folder of the job, e.g. out/nginx/result.json for out/result.json,
+// or oci-layout://out/nginx/image for oci-layout://out/image.
+func getJobOutput(output, folder string) string {
+	if typ, name, ok := strings.Cut(output, outputSep); ok {
+		return typ + outputSep + getJobOutput(name, folder)
+	}
+
+	return filepath.Join(filepath.Dir(output), folder, filepath.Base(output))
+}
+
+// createOutputFolders creates the parent folders of the outputs of the config.
+func createOutputFolders(cfg *config.Config) error {
+	for _, output := range []string{
+		cfg.Output.Output,
+		cfg.Output.ProvenanceOutput,
+		cfg.Output.ResultOutput,
+		cfg.Output.SBOMOutput,
+		cfg.Output.VEXOutput,
+	} {
+		if output == "" {
+			continue
+		}
+		if _, name, ok := strings.Cut(output, outputSep); ok {
+			output = name
+		}
+		if err := os.MkdirAll(filepath.Dir(output), patcher.DefaultPerm); err != nil {
+			return errors.Wrap(err, "failed to create folder")
+		}
+	}
+
+	return nil
+}
+
+// getName returns the name of the job, which is the image if empty.
+func (j *Job) getName() string {
+	if j.Name != "" {
+		return j.Name
+	}
+
+	return j.Image
+}
+
+// getFolder returns the folder of the outputs of the job.
+func (j *Job) getFolder() string {
+	return unsafeNameChars.ReplaceAllString(j.getName(), "-")
+}
+
+func getWorkers(flag, file int) int {
+	switch {
+	case flag > 0:
+		return flag
+	case file > 0:
+		return file
+	default:
+		return DefaultWorkers
+	}
+}
+
+// GetStatus returns the status of the job finished with the error.
+func GetStatus(err error) string {
+	if err == nil {
+		return StatusPatched
+	}
+
+	switch types.GetErrorKind(err) {
+	case types.ErrorKindPartialPatch:
+		return StatusPartial
+	case types.ErrorKindNoUpdates:
+		return StatusNoUpdates
+	default:
+		return StatusFailed
+	}
+}
+
+// getError returns the error of the failed jobs, or of the partially patched jobs if none failed.
+func getError(results []JobResult) error {
+	var failed, partial int
+
+	for i := range results {
+		switch results[i].Status {
+		case StatusFailed:
+			failed++
+		case StatusPartial:
+			partial++
+		}
+	}
+
+	switch {
+	case failed != 0:
+		return errors.Errorf("%d of %d jobs failed", failed, len(results))
+	case partial != 0:
+		return types.NewError(types.ErrorKindPartialPatch, errors.Errorf("%d of %d jobs partially patched", partial, len(results)))
+	default:
+		return nil
+	}
+}
+
+// WriteSummary writes the status of each job and the count of each status.
+func WriteSummary(w io.Writer, results []JobResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(tw, "JOB\tIMAGE\tSTATUS\tDURATION\tERROR")
+
+	counts := map[string]int{}
+
+	for i := range results {
+		r := &results[i]
+		msg := ""
+		if r.Err != nil && r.Status != StatusNoUpdates {
+			msg = r.Err.Error()
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Name, r.Image, r.Status, r.Duration.Round(time.Second), msg)
+		counts[r.Status]++
+	}
+
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "failed to flush summary")
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d jobs: %d patched, %d partial, %d no-updates, %d failed\n", len(results),
+		counts[StatusPatched], counts[StatusPartial], counts[StatusNoUpdates], counts[StatusFailed])
+
+	return err
+}
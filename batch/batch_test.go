@@ -0,0 +1,167 @@
+package batch
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+
+	"github.com/craftslab/copatcher/config"
+	"github.com/craftslab/copatcher/types"
+)
+
+const testFile = `workers: 2
+jobs:
+  - image: ubuntu:22.04
+    report: ubuntu.json
+  - name: nginx
+    image: nginx:1.25
+    report: nginx.json
+    tag: 1.25-patched
+    options:
+      output:
+        push: true
+      packages:
+        exclude: [libc6]
+`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		file    string
+		wantErr bool
+	}{
+		{"valid", testFile, false},
+		{"no jobs", "workers: 2\n", true},
+		{"no image", "jobs:\n  - report: ubuntu.json\n", true},
+		{"no report", "jobs:\n  - image: ubuntu:22.04\n", true},
+		{"duplicate name", "jobs:\n  - image: ubuntu:22.04\n    report: a.json\n  - image: ubuntu:22.04\n    report: b.json\n", true},
+		{"invalid", "jobs: invalid\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, filepath.Base(t.Name())+".yaml")
+			assert.Equal(t, nil, os.WriteFile(name, []byte(tt.file), 0o600))
+			f, err := Load(name)
+			if tt.wantErr {
+				assert.NotEqual(t, nil, err)
+				return
+			}
+			assert.Equal(t, nil, err)
+			assert.Equal(t, 2, f.Workers)
+			assert.Equal(t, 2, len(f.Jobs))
+			assert.Equal(t, "nginx", f.Jobs[1].Name)
+		})
+	}
+
+	_, err := Load(filepath.Join(dir, "missing.yaml"))
+	assert.NotEqual(t, nil, err)
+}
+
+func TestGetJobConfig(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "jobs.yaml")
+	assert.Equal(t, nil, os.WriteFile(name, []byte(testFile), 0o600))
+
+	f, err := Load(name)
+	assert.Equal(t, nil, err)
+
+	cfg := config.New()
+	cfg.Output.Tag = "patched"
+	cfg.Packages.Exclude = []string{"linux-*"}
+
+	out, err := getJobConfig(cfg, &f.Jobs[0])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, cfg, out)
+
+	out, err = getJobConfig(cfg, &f.Jobs[1])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, out.Output.Push)
+	assert.Equal(t, "1.25-patched", out.Output.Tag)
+	assert.Equal(t, []string{"libc6"}, out.Packages.Exclude)
+
+	assert.Equal(t, false, cfg.Output.Push)
+	assert.Equal(t, "patched", cfg.Output.Tag)
+	assert.Equal(t, []string{"linux-*"}, cfg.Packages.Exclude)
+}
+
+func TestGetJobConfigOutputs(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := config.New()
+	cfg.Output.Output = "oci-layout://" + filepath.Join(dir, "image")
+	cfg.Output.ResultOutput = filepath.Join(dir, "result.json")
+	cfg.Output.VEXOutput = filepath.Join(dir, "vex.json")
+
+	var options yaml.Node
+	assert.Equal(t, nil, yaml.Unmarshal([]byte("output:\n  vexOutput: nginx.vex.json\n"), &options))
+
+	jobs := []Job{
+		{Image: "ubuntu:22.04", Report: "ubuntu.json"},
+		{Name: "nginx", Image: "registry.example.com/nginx:1.25", Report: "nginx.json", Options: options},
+	}
+
+	ubuntu, err := getJobConfig(cfg, &jobs[0])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "oci-layout://"+filepath.Join(dir, "ubuntu-22.04", "image"), ubuntu.Output.Output)
+	assert.Equal(t, filepath.Join(dir, "ubuntu-22.04", "result.json"), ubuntu.Output.ResultOutput)
+	assert.Equal(t, filepath.Join(dir, "ubuntu-22.04", "vex.json"), ubuntu.Output.VEXOutput)
+	assert.Empty(t, ubuntu.Output.SBOMOutput)
+
+	nginx, err := getJobConfig(cfg, &jobs[1])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, filepath.Join(dir, "nginx", "result.json"), nginx.Output.ResultOutput)
+	assert.Equal(t, "nginx.vex.json", nginx.Output.VEXOutput)
+	assert.NotEqual(t, ubuntu.Output.ResultOutput, nginx.Output.ResultOutput)
+
+	assert.Equal(t, filepath.Join(dir, "result.json"), cfg.Output.ResultOutput)
+
+	assert.Equal(t, nil, createOutputFolders(ubuntu))
+	assert.DirExists(t, filepath.Join(dir, "ubuntu-22.04"))
+}
+
+func TestGetWorkers(t *testing.T) {
+	assert.Equal(t, 8, getWorkers(8, 2))
+	assert.Equal(t, 2, getWorkers(0, 2))
+	assert.Equal(t, DefaultWorkers, getWorkers(0, 0))
+}
+
+func TestGetStatus(t *testing.T) {
+	assert.Equal(t, StatusPatched, GetStatus(nil))
+	assert.Equal(t, StatusPartial, GetStatus(errors.Wrap(types.NewError(types.ErrorKindPartialPatch, errors.New("failed")), "failed to patch")))
+	assert.Equal(t, StatusNoUpdates, GetStatus(types.NewError(types.ErrorKindNoUpdates, errors.New("failed"))))
+	assert.Equal(t, StatusFailed, GetStatus(errors.New("failed")))
+}
+
+func TestGetError(t *testing.T) {
+	assert.Equal(t, nil, getError([]JobResult{{Status: StatusPatched}, {Status: StatusNoUpdates}}))
+
+	err := getError([]JobResult{{Status: StatusPatched}, {Status: StatusPartial}})
+	assert.Equal(t, types.ErrorKindPartialPatch, types.GetErrorKind(err))
+
+	err = getError([]JobResult{{Status: StatusFailed}, {Status: StatusPartial}})
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, types.ErrorKindUnknown, types.GetErrorKind(err))
+}
+
+func TestWriteSummary(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := WriteSummary(&buf, []JobResult{
+		{Name: "ubuntu", Image: "ubuntu:22.04", Status: StatusPatched, Duration: 90 * time.Second},
+		{Name: "nginx", Image: "nginx:1.25", Status: StatusFailed, Duration: time.Second, Err: errors.New("failed to patch")},
+	})
+
+	assert.Equal(t, nil, err)
+	assert.Contains(t, buf.String(), "ubuntu:22.04")
+	assert.Contains(t, buf.String(), "1m30s")
+	assert.Contains(t, buf.String(), "failed to patch")
+	assert.Contains(t, buf.String(), "2 jobs: 1 patched, 0 partial, 0 no-updates, 1 failed")
+}
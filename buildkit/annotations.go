@@ -0,0 +1,128 @@
+package buildkit
+
+import (
+	"encoding/json"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/moby/buildkit/util/apicaps"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	configKey  = "config"
+	historyKey = "history"
+	labelsKey  = "Labels"
+)
+
+// ExportCaps are the buildkit caps required to export the patched images.
+var ExportCaps = []apicaps.CapID{pb.CapAnnotations, pb.CapAttestations}
+
+// WithConfigLabels returns the image config with the labels added, keeping the other fields as they are.
+func WithConfigLabels(configData []byte, labels map[string]string) ([]byte, error) {
+	var img map[string]json.RawMessage
+	if err := json.Unmarshal(configData, &img); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal image config")
+	}
+
+	cfg := map[string]json.RawMessage{}
+	if buf, ok := img[configKey]; ok && string(buf) != "null" {
+		if err := json.Unmarshal(buf, &cfg); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal config")
+		}
+	}
+
+	l := map[string]string{}
+	if buf, ok := cfg[labelsKey]; ok && string(buf) != "null" {
+		if err := json.Unmarshal(buf, &l); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal labels")
+		}
+	}
+
+	for k, v := range labels {
+		l[k] = v
+	}
+
+	var err error
+
+	if cfg[labelsKey], err = json.Marshal(l); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal labels")
+	}
+
+	if img[configKey], err = json.Marshal(cfg); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal config")
+	}
+
+	return json.Marshal(img)
+}
+
+// WithConfigHistory returns the image config with the history entries appended, keeping the other fields as they are.
+// The rootfs is left untouched since buildkit regenerates its diff_ids from the exported layers, which have to match
+// the non-empty history entries.
+func WithConfigHistory(configData []byte, history []ispec.History) ([]byte, error) {
+	var img map[string]json.RawMessage
+	if err := json.Unmarshal(configData, &img); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal image config")
+	}
+
+	var h []json.RawMessage
+	if buf, ok := img[historyKey]; ok && string(buf) != "null" {
+		if err := json.Unmarshal(buf, &h); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal history")
+		}
+	}
+
+	for i := range history {
+		buf, err := json.Marshal(history[i])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal history entry")
+		}
+		h = append(h, buf)
+	}
+
+	var err error
+
+	if img[historyKey], err = json.Marshal(h); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal history")
+	}
+
+	return json.Marshal(img)
+}
+
+// getAnnotationAttrs returns the exporter attrs annotating the manifest of each image,
+// and the image index with the annotations shared by all the images if there are more than one.
+func getAnnotationAttrs(images []PlatformImage) map[string]string {
+	attrs := map[string]string{}
+
+	if len(images) == 1 {
+		for k, v := range images[0].Annotations {
+			attrs[exptypes.AnnotationManifestKey(nil, k)] = v
+		}
+		return attrs
+	}
+
+	for i := range images {
+		// Match the platform ids of the refs exported by buildPlatformImages
+		p := platforms.Normalize(images[i].Platform)
+		for k, v := range images[i].Annotations {
+			attrs[exptypes.AnnotationManifestKey(&p, k)] = v
+		}
+	}
+
+	for k, v := range images[0].Annotations {
+		shared := true
+		for i := range images[1:] {
+			if val, ok := images[i+1].Annotations[k]; !ok || val != v {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			attrs[exptypes.AnnotationIndexKey(k)] = v
+		}
+	}
+
+	return attrs
+}
@@ -0,0 +1,101 @@
+package buildkit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithConfigLabels(t *testing.T) {
+	t.Run("merge labels", func(t *testing.T) {
+		configData := []byte(`{"architecture":"amd64","os":"linux","container_config":{"Hostname":"x"},` +
+			`"config":{"Env":["PATH=/bin"],"Labels":{"maintainer":"me"}}}`)
+		buf, err := WithConfigLabels(configData, map[string]string{"patched": "true"})
+		assert.NoError(t, err)
+
+		var img map[string]json.RawMessage
+		assert.NoError(t, json.Unmarshal(buf, &img))
+		assert.JSONEq(t, `{"Hostname":"x"}`, string(img["container_config"]))
+		assert.JSONEq(t, `{"Env":["PATH=/bin"],"Labels":{"maintainer":"me","patched":"true"}}`, string(img["config"]))
+	})
+
+	t.Run("no labels", func(t *testing.T) {
+		buf, err := WithConfigLabels([]byte(`{"architecture":"amd64","os":"linux","config":{"Labels":null}}`),
+			map[string]string{"patched": "true"})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"architecture":"amd64","os":"linux","config":{"Labels":{"patched":"true"}}}`, string(buf))
+	})
+
+	t.Run("no config", func(t *testing.T) {
+		buf, err := WithConfigLabels([]byte(`{"architecture":"amd64","os":"linux"}`), map[string]string{"patched": "true"})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"architecture":"amd64","os":"linux","config":{"Labels":{"patched":"true"}}}`, string(buf))
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := WithConfigLabels([]byte("invalid"), nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestGetAnnotationAttrs(t *testing.T) {
+	t.Run("single platform", func(t *testing.T) {
+		attrs := getAnnotationAttrs([]PlatformImage{
+			{
+				Platform:    ispec.Platform{OS: "linux", Architecture: "amd64"},
+				Annotations: map[string]string{"key": "value"},
+			},
+		})
+		assert.Equal(t, map[string]string{"annotation-manifest.key": "value"}, attrs)
+	})
+
+	t.Run("multi platform", func(t *testing.T) {
+		attrs := getAnnotationAttrs([]PlatformImage{
+			{
+				Platform:    ispec.Platform{OS: "linux", Architecture: "amd64"},
+				Annotations: map[string]string{"shared": "value", "packages": "a"},
+			},
+			{
+				Platform:    ispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+				Annotations: map[string]string{"shared": "value", "packages": "b"},
+			},
+		})
+		assert.Equal(t, map[string]string{
+			"annotation-manifest[linux/amd64].shared":    "value",
+			"annotation-manifest[linux/amd64].packages":  "a",
+			"annotation-manifest[linux/arm/v7].shared":   "value",
+			"annotation-manifest[linux/arm/v7].packages": "b",
+			"annotation-index.shared":                    "value",
+		}, attrs)
+	})
+}
+
+func TestWithConfigHistory(t *testing.T) {
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	history := []ispec.History{{Created: &created, CreatedBy: "apt install (deb): openssl=3.0.2", Comment: "copatcher"}}
+
+	t.Run("append history", func(t *testing.T) {
+		configData := []byte(`{"os":"linux","rootfs":{"type":"layers","diff_ids":["sha256:aaa"]},` +
+			`"history":[{"created_by":"/bin/sh -c #(nop) ADD file:abc in /"}]}`)
+		buf, err := WithConfigHistory(configData, history)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"os":"linux","rootfs":{"type":"layers","diff_ids":["sha256:aaa"]},"history":[`+
+			`{"created_by":"/bin/sh -c #(nop) ADD file:abc in /"},`+
+			`{"created":"2024-03-01T08:00:00Z","created_by":"apt install (deb): openssl=3.0.2","comment":"copatcher"}]}`, string(buf))
+	})
+
+	t.Run("no history", func(t *testing.T) {
+		buf, err := WithConfigHistory([]byte(`{"os":"linux"}`), history)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"os":"linux","history":[`+
+			`{"created":"2024-03-01T08:00:00Z","created_by":"apt install (deb): openssl=3.0.2","comment":"copatcher"}]}`, string(buf))
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := WithConfigHistory([]byte("invalid"), history)
+		assert.Error(t, err)
+	})
+}
@@ -2,12 +2,14 @@ package buildkit
 
 import (
 	"context"
+	"crypto/tls"
 	"io"
+	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"strings"
 
-	"github.com/containerd/console"
+	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
 	"github.com/docker/buildx/build"
 	"github.com/docker/cli/cli/config"
@@ -18,7 +20,6 @@ import (
 	"github.com/moby/buildkit/session/auth/authprovider"
 	"github.com/moby/buildkit/util/contentutil"
 	"github.com/moby/buildkit/util/imageutil"
-	"github.com/moby/buildkit/util/progress/progressui"
 	"github.com/moby/buildkit/version"
 	"github.com/opencontainers/go-digest"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -26,14 +27,31 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/craftslab/copatcher/types"
+	"github.com/craftslab/copatcher/utils"
+)
+
+// Output types supported by SolveToOutput.
+const (
+	OutputDockerArchive = "docker-archive"
+	OutputOCIArchive    = "oci-archive"
+	OutputOCILayout     = "oci-layout"
+)
+
+const (
+	outputSep = "://"
 )
 
 type Config struct {
-	ImageName  string
-	Client     *client.Client
-	ConfigData []byte
-	Platform   ispec.Platform
-	ImageState llb.State
+	ImageName   string
+	ImageDigest digest.Digest
+	Client      *client.Client
+	ConfigData  []byte
+	Platform    ispec.Platform
+	ImageState  llb.State
+	// Phases are the timeouts and retries of the solves of the package manager.
+	Phases *utils.Phases
+	// ToolImages override the repository of the tooling image of the os types, e.g. ubuntu.
+	ToolImages map[string]string
 }
 
 type Opts struct {
@@ -41,26 +59,83 @@ type Opts struct {
 	CACertPath string
 	CertPath   string
 	KeyPath    string
+	// Logger logs the endpoint connected to, which is the standard logger if nil.
+	Logger *log.Logger
+}
+
+// Registry is the registry to resolve the image from and push the patched image to.
+type Registry struct {
+	// Insecure skips the TLS verification of the registries resolved by copatcher. The patched images are pushed by
+	// buildkitd, which verifies them with its own registry config.
+	Insecure bool
+	// PlainHTTP allows resolving from and pushing to registries over plain HTTP.
+	PlainHTTP bool
+	// Mirrors are the mirror hosts of the registry hosts, e.g. docker.io, which are tried in order
+	// before the registry host to resolve image configs. The images are pulled by buildkitd with its
+	// own registry config, and the patched images are not pushed to the mirrors.
+	Mirrors map[string][]string
+}
+
+// Output is the destination of the patched image, e.g. oci-layout://dir.
+type Output struct {
+	Type string
+	Path string
+}
+
+// ParseOutput parses the output in the form of type://path.
+func ParseOutput(output string) (*Output, error) {
+	typ, path, ok := strings.Cut(output, outputSep)
+	if !ok || path == "" {
+		return nil, errors.Errorf("invalid output %s", output)
+	}
+
+	switch typ {
+	case OutputDockerArchive, OutputOCIArchive, OutputOCILayout:
+		return &Output{Type: typ, Path: path}, nil
+	default:
+		return nil, errors.Errorf("unsupported output type %s", typ)
+	}
+}
+
+func (o *Output) String() string {
+	return o.Type + outputSep + o.Path
+}
+
+// nolint: lll
+func InitializeBuildkitConfig(ctx context.Context, clt *client.Client, image string, manifest *types.UpdateManifest, reg *Registry, phases *utils.Phases) (*Config, error) {
+	platform := ispec.Platform{
+		OS:           "linux",
+		Architecture: manifest.Metadata.Config.Arch,
+	}
+
+	return InitializeBuildkitPlatformConfig(ctx, clt, image, platform, reg, phases)
 }
 
+// InitializeBuildkitPlatformConfig initializes buildkit config for the image of the platform, e.g. linux/arm/v7.
 // nolint: lll
-func InitializeBuildkitConfig(ctx context.Context, clt *client.Client, image string, manifest *types.UpdateManifest) (*Config, error) {
+func InitializeBuildkitPlatformConfig(ctx context.Context, clt *client.Client, image string, platform ispec.Platform, reg *Registry, phases *utils.Phases) (*Config, error) {
 	// Initialize buildkit config for the target image
 	cfg := Config{
 		ImageName: image,
-		Platform: ispec.Platform{
-			OS:           "linux",
-			Architecture: manifest.Metadata.Config.Arch,
-		},
+		Platform:  platform,
+		Phases:    phases,
 	}
 
-	// Resolve and pull the config for the target image
-	_, configData, err := resolveImageConfig(ctx, image, &cfg.Platform)
+	var dgst digest.Digest
+	var configData []byte
+
+	// Resolve and pull the config for the target image, which is retried on registry errors
+	err := phases.Run(ctx, utils.PhaseResolve, true, func(ctx context.Context) error {
+		var e error
+		dgst, configData, e = resolveImageConfig(ctx, image, &cfg.Platform, reg)
+		return e
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to resolve image config")
 	}
 
 	cfg.ConfigData = configData
+	cfg.ImageDigest = dgst
 
 	// Load the target image state with the resolved image config in case environment variable settings
 	// are necessary for running apps in the target image for updates
@@ -78,20 +153,29 @@ func InitializeBuildkitConfig(ctx context.Context, clt *client.Client, image str
 }
 
 func SolveToLocal(ctx context.Context, c *client.Client, st *llb.State, outPath string) error {
+	return solveState(ctx, c, st, []client.ExportEntry{
+		{
+			Type:      client.ExporterLocal,
+			OutputDir: outPath,
+		},
+	})
+}
+
+// Solve solves the state without exporting it, which caches its result for the next solves of the client.
+func Solve(ctx context.Context, c *client.Client, st *llb.State) error {
+	return solveState(ctx, c, st, nil)
+}
+
+func solveState(ctx context.Context, c *client.Client, st *llb.State, exports []client.ExportEntry) error {
 	def, err := st.Marshal(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to run marshal")
 	}
 
 	dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
-	attachable := []session.Attachable{authprovider.NewDockerAuthProvider(dockerConfig)}
+	attachable := []session.Attachable{authprovider.NewDockerAuthProvider(dockerConfig, nil)}
 	solveOpt := client.SolveOpt{
-		Exports: []client.ExportEntry{
-			{
-				Type:      client.ExporterLocal,
-				OutputDir: outPath,
-			},
-		},
+		Exports:  exports,
 		Frontend: "",         // i.e. we are passing in the llb.Definition directly
 		Session:  attachable, // used for authprovider, sshagentprovider and secretprovider
 	}
@@ -110,14 +194,7 @@ func SolveToLocal(ctx context.Context, c *client.Client, st *llb.State, outPath
 	})
 
 	eg.Go(func() error {
-		var c console.Console
-		cn, e := console.ConsoleFromFile(os.Stderr)
-		if e == nil {
-			c = cn
-		}
-		// not using shared context to not disrupt display but let us finish reporting errors
-		_, e = progressui.DisplaySolveStatus(context.TODO(), c, os.Stdout, ch)
-		return e
+		return displayProgress(ctx, ch)
 	})
 
 	if err := eg.Wait(); err != nil {
@@ -127,83 +204,184 @@ func SolveToLocal(ctx context.Context, c *client.Client, st *llb.State, outPath
 	return nil
 }
 
-func SolveToDocker(ctx context.Context, c *client.Client, st *llb.State, configData []byte, tag string) error {
-	def, err := st.Marshal(ctx)
+// SolveToDocker exports the patched images with the buildkit docker exporter and pipes them into the loader,
+// returning the digest of the exported manifest or index.
+// nolint: lll
+func SolveToDocker(ctx context.Context, c *client.Client, images []PlatformImage, tag string, loader Loader) (digest.Digest, error) {
+	pipeR, pipeW := io.Pipe()
+
+	export := getLoadExportEntry(tag, pipeW)
+
+	eg, ctx := errgroup.WithContext(ctx)
+
+	var resp *client.SolveResponse
+
+	eg.Go(func() error {
+		var err error
+		if resp, err = solveToExport(ctx, c, images, export); err != nil {
+			_ = pipeW.CloseWithError(err)
+			return errors.Wrap(err, "failed to solve to export")
+		}
+		return nil
+	})
+
+	eg.Go(func() error {
+		if err := loader.Load(ctx, pipeR); err != nil {
+			_ = pipeR.CloseWithError(err)
+			return errors.Wrap(err, "failed to load image")
+		}
+		return pipeR.Close()
+	})
+
+	if err := eg.Wait(); err != nil {
+		return "", types.NewError(types.ErrorKindExport, err)
+	}
+
+	return getExportedDigest(resp)
+}
+
+// SolveToOutput exports the patched images with the buildkit oci or docker exporter
+// into an oci layout directory or a tarball, which requires no docker daemon,
+// and returns the digest of the exported manifest or index.
+// nolint: lll
+func SolveToOutput(ctx context.Context, c *client.Client, images []PlatformImage, tag string, output *Output) (digest.Digest, error) {
+	resp, err := solveToExport(ctx, c, images, getExportEntry(output, tag))
 	if err != nil {
-		return errors.Wrap(err, "failed to run marshal")
+		return "", types.NewError(types.ErrorKindExport, errors.Wrap(err, "failed to solve to export"))
+	}
+
+	return getExportedDigest(resp)
+}
+
+// SolveToRegistry pushes the patched images with the buildkit image exporter using the docker config credentials
+// and returns the digest of the pushed manifest or index.
+// nolint: lll
+func SolveToRegistry(ctx context.Context, c *client.Client, images []PlatformImage, tag string, reg *Registry) (digest.Digest, error) {
+	resp, err := solveToExport(ctx, c, images, getPushExportEntry(tag, reg))
+	if err != nil {
+		return "", types.NewError(types.ErrorKindExport, errors.Wrap(err, "failed to solve to export"))
+	}
+
+	return getExportedDigest(resp)
+}
+
+func getExportedDigest(resp *client.SolveResponse) (digest.Digest, error) {
+	dgst, ok := resp.ExporterResponse[exptypes.ExporterImageDigestKey]
+	if !ok {
+		return "", types.NewError(types.ErrorKindExport, errors.New("failed to get exported digest"))
+	}
+
+	return digest.Parse(dgst)
+}
+
+func solveToExport(ctx context.Context, c *client.Client, images []PlatformImage, export client.ExportEntry) (*client.SolveResponse, error) {
+	for k, v := range getAnnotationAttrs(images) {
+		export.Attrs[k] = v
 	}
 
-	pipeR, pipeW := io.Pipe()
 	dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
-	attachable := []session.Attachable{authprovider.NewDockerAuthProvider(dockerConfig)}
+	attachable := []session.Attachable{authprovider.NewDockerAuthProvider(dockerConfig, nil)}
 
 	solveOpt := client.SolveOpt{
-		Exports: []client.ExportEntry{
-			{
-				Type: client.ExporterDocker,
-				Attrs: map[string]string{
-					"name": tag,
-					// Pass through resolved configData from original image
-					exptypes.ExporterImageConfigKey: string(configData),
-				},
-				Output: func(_ map[string]string) (io.WriteCloser, error) {
-					return pipeW, nil
-				},
-			},
-		},
+		Exports:  []client.ExportEntry{export},
 		Frontend: "",         // i.e. we are passing in the llb.Definition directly
 		Session:  attachable, // used for authprovider, sshagentprovider and secretprovider
 	}
 
+	var err error
+
 	solveOpt.SourcePolicy, err = build.ReadSourcePolicy()
 	if err != nil {
-		return errors.Wrap(err, "failed to read source policy")
+		return nil, errors.Wrap(err, "failed to read source policy")
 	}
 
+	var resp *client.SolveResponse
+
 	ch := make(chan *client.SolveStatus)
 	eg, ctx := errgroup.WithContext(ctx)
 
 	eg.Go(func() error {
-		_, e := c.Solve(ctx, def, solveOpt, ch)
-		return errors.Wrap(e, "failed to run solve")
+		var e error
+		resp, e = c.Build(ctx, solveOpt, "", buildPlatformImages(images, supportsAttestations(export)), ch)
+		return errors.Wrap(e, "failed to run build")
 	})
 
 	eg.Go(func() error {
-		var c console.Console
-		cn, e := console.ConsoleFromFile(os.Stderr)
-		if e == nil {
-			c = cn
-		}
-		// not using shared context to not disrupt display but let us finish reporting errors
-		_, e = progressui.DisplaySolveStatus(context.TODO(), c, os.Stdout, ch)
-		return errors.Wrap(e, "failed to display solve status")
+		return displayProgress(ctx, ch)
 	})
 
-	eg.Go(func() error {
-		if err := dockerLoad(ctx, pipeR); err != nil {
-			return errors.Wrap(err, "failed to load docker")
-		}
-		return pipeR.Close()
-	})
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
 
-	return eg.Wait()
+	return resp, nil
 }
 
-func dockerLoad(ctx context.Context, pipeR io.Reader) error {
-	cmd := exec.CommandContext(ctx, "docker", "load")
-	cmd.Stdin = pipeR
+func getLoadExportEntry(tag string, w io.WriteCloser) client.ExportEntry {
+	return client.ExportEntry{
+		Type: client.ExporterDocker,
+		Attrs: map[string]string{
+			"name": tag,
+		},
+		Output: func(_ map[string]string) (io.WriteCloser, error) {
+			return w, nil
+		},
+	}
+}
 
-	_, err := cmd.StdoutPipe()
-	if err != nil {
-		return errors.Wrap(err, "failed to run stdout pipe")
+func getPushExportEntry(tag string, reg *Registry) client.ExportEntry {
+	attrs := map[string]string{
+		string(exptypes.OptKeyName): tag,
+		string(exptypes.OptKeyPush): "true",
 	}
 
-	_, err = cmd.StderrPipe()
-	if err != nil {
-		return errors.Wrap(err, "failed to run stderr pipe")
+	// buildkitd falls back to plain HTTP for insecure registries, and always for localhost ones
+	if reg != nil && reg.PlainHTTP {
+		attrs[string(exptypes.OptKeyInsecure)] = "true"
 	}
 
-	return cmd.Run()
+	return client.ExportEntry{
+		Type:  client.ExporterImage,
+		Attrs: attrs,
+	}
+}
+
+func getExportEntry(output *Output, tag string) client.ExportEntry {
+	attrs := map[string]string{
+		"name": tag,
+	}
+
+	switch output.Type {
+	case OutputOCILayout:
+		attrs["tar"] = "false"
+		return client.ExportEntry{
+			Type:      client.ExporterOCI,
+			Attrs:     attrs,
+			OutputDir: output.Path,
+		}
+	case OutputOCIArchive:
+		return client.ExportEntry{
+			Type:   client.ExporterOCI,
+			Attrs:  attrs,
+			Output: createOutputFile(output.Path),
+		}
+	default:
+		return client.ExportEntry{
+			Type:   client.ExporterDocker,
+			Attrs:  attrs,
+			Output: createOutputFile(output.Path),
+		}
+	}
+}
+
+func createOutputFile(path string) func(map[string]string) (io.WriteCloser, error) {
+	return func(_ map[string]string) (io.WriteCloser, error) {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create output file")
+		}
+		return f, nil
+	}
 }
 
 // Custom ResolveImageConfig implementation for using Docker default config.json credentials
@@ -212,7 +390,30 @@ func dockerLoad(ctx context.Context, pipeR io.Reader) error {
 // While it would be ideal to be able to use imagemetaresolver.Default().ResolveImageConfig(),
 // there doesn't seem to be a way to configure the necessary DockerAuthorizer or RegistryHosts
 // against an ImageMetaResolver, which causes the resolve to only use anonymous tokens and fail.
-func resolveImageConfig(ctx context.Context, ref string, platform *ispec.Platform) (digest.Digest, []byte, error) {
+func resolveImageConfig(ctx context.Context, ref string, platform *ispec.Platform, reg *Registry) (digest.Digest, []byte, error) {
+	dgst, cfg, err := imageutil.Config(ctx, ref, NewResolver(reg), contentutil.NewBuffer(), nil, platform)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to run config")
+	}
+
+	return dgst, cfg, nil
+}
+
+// NewResolver returns the registry resolver authorized with the docker config, which uses plain HTTP
+// for localhost or for every registry if plain HTTP is allowed, and skips TLS verification if it is insecure.
+func NewResolver(reg *Registry) remotes.Resolver {
+	plainHTTP := docker.MatchLocalhost
+	if reg.PlainHTTP {
+		plainHTTP = docker.MatchAllHosts
+	}
+
+	clt := http.DefaultClient
+	if reg.Insecure {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // #nosec G402
+		clt = &http.Client{Transport: transport}
+	}
+
 	auth := docker.NewDockerAuthorizer(
 		docker.WithAuthCreds(func(ref string) (string, string, error) {
 			defaultConfig := config.LoadDefaultConfigFile(os.Stderr)
@@ -226,25 +427,47 @@ func resolveImageConfig(ctx context.Context, ref string, platform *ispec.Platfor
 			return ac.Username, ac.Password, nil
 		}))
 
-	hosts := docker.ConfigureDefaultRegistries(
-		docker.WithClient(http.DefaultClient),
-		docker.WithPlainHTTP(docker.MatchLocalhost),
+	hosts := withMirrors(docker.ConfigureDefaultRegistries(
+		docker.WithClient(clt),
+		docker.WithPlainHTTP(plainHTTP),
 		docker.WithAuthorizer(auth),
-	)
+	), reg.Mirrors)
 
 	headers := http.Header{}
 	headers.Set("User-Agent", version.UserAgent())
 
-	resolver := docker.NewResolver(docker.ResolverOptions{
+	return docker.NewResolver(docker.ResolverOptions{
 		Client:  http.DefaultClient,
 		Headers: headers,
 		Hosts:   hosts,
 	})
+}
 
-	_, dgst, cfg, err := imageutil.Config(ctx, ref, resolver, contentutil.NewBuffer(), nil, platform, nil)
-	if err != nil {
-		return "", nil, errors.Wrap(err, "failed to run config")
+// withMirrors prepends the pull only mirror hosts of the registry host to its hosts.
+func withMirrors(hosts docker.RegistryHosts, mirrors map[string][]string) docker.RegistryHosts {
+	if len(mirrors) == 0 {
+		return hosts
 	}
 
-	return dgst, cfg, nil
+	return func(host string) ([]docker.RegistryHost, error) {
+		var out []docker.RegistryHost
+
+		for _, m := range mirrors[host] {
+			mirrorHosts, err := hosts(m)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to configure mirror %s", m)
+			}
+			for i := range mirrorHosts {
+				mirrorHosts[i].Capabilities = docker.HostCapabilityPull | docker.HostCapabilityResolve
+			}
+			out = append(out, mirrorHosts...)
+		}
+
+		origin, err := hosts(host)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(out, origin...), nil
+	}
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/containerd/containerd/remotes/docker"
 	controlapi "github.com/moby/buildkit/api/services/control"
 	types "github.com/moby/buildkit/api/types"
 	"github.com/moby/buildkit/client"
@@ -203,15 +204,10 @@ func TestBuildkitNewClient(t *testing.T) {
 			bkOpts := Opts{
 				Addr: prefix + addr,
 			}
+			// The explicit address is validated like the discovered endpoints
 			_client, err := NewClient(ctxT, bkOpts)
 			cancel()
-			assert.NoError(t, err)
-			defer func(c *client.Client) {
-				_ = c.Close()
-			}(_client)
-			ctxT, cancel = context.WithTimeout(ctx, time.Second)
-			err = ValidateClient(ctxT, _client)
-			cancel()
+			assert.Nil(t, _client)
 			checkMissingCapsError(t, err, requiredCaps...)
 		})
 
@@ -250,12 +246,112 @@ func TestSolveToDocker(t *testing.T) {
 	assert.Equal(t, nil, nil)
 }
 
-func TestDockerLoad(t *testing.T) {
-	// TODO: FIXME
-	assert.Equal(t, nil, nil)
+func TestParseOutput(t *testing.T) {
+	testCases := []struct {
+		name    string
+		output  string
+		want    *Output
+		wantErr bool
+	}{
+		{
+			name:   "oci layout",
+			output: "oci-layout://out",
+			want:   &Output{Type: OutputOCILayout, Path: "out"},
+		},
+		{
+			name:   "oci archive",
+			output: "oci-archive:///tmp/out.tar",
+			want:   &Output{Type: OutputOCIArchive, Path: "/tmp/out.tar"},
+		},
+		{
+			name:   "docker archive",
+			output: "docker-archive://out.tar",
+			want:   &Output{Type: OutputDockerArchive, Path: "out.tar"},
+		},
+		{
+			name:    "missing path",
+			output:  "oci-layout://",
+			wantErr: true,
+		},
+		{
+			name:    "missing type",
+			output:  "out.tar",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported type",
+			output:  "zip://out.zip",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseOutput(tc.output)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.output, got.String())
+		})
+	}
+}
+
+func TestGetExportEntry(t *testing.T) {
+	entry := getExportEntry(&Output{Type: OutputOCILayout, Path: "out"}, "image:patched")
+	assert.Equal(t, client.ExporterOCI, entry.Type)
+	assert.Equal(t, "out", entry.OutputDir)
+	assert.Equal(t, "false", entry.Attrs["tar"])
+	assert.Nil(t, entry.Output)
+
+	entry = getExportEntry(&Output{Type: OutputOCIArchive, Path: "out.tar"}, "image:patched")
+	assert.Equal(t, client.ExporterOCI, entry.Type)
+	assert.Equal(t, "image:patched", entry.Attrs["name"])
+	assert.NotNil(t, entry.Output)
+
+	entry = getExportEntry(&Output{Type: OutputDockerArchive, Path: "out.tar"}, "image:patched")
+	assert.Equal(t, client.ExporterDocker, entry.Type)
+	assert.NotNil(t, entry.Output)
+}
+
+func TestGetPushExportEntry(t *testing.T) {
+	entry := getPushExportEntry("localhost:5000/image:patched", nil)
+	assert.Equal(t, client.ExporterImage, entry.Type)
+	assert.Equal(t, "localhost:5000/image:patched", entry.Attrs["name"])
+	assert.Equal(t, "true", entry.Attrs["push"])
+	assert.NotContains(t, entry.Attrs, "registry.insecure")
+
+	entry = getPushExportEntry("registry.local/image:patched", &Registry{Insecure: true})
+	assert.NotContains(t, entry.Attrs, "registry.insecure")
+
+	entry = getPushExportEntry("registry.local/image:patched", &Registry{PlainHTTP: true})
+	assert.Equal(t, "true", entry.Attrs["registry.insecure"])
 }
 
 func TestResolveImageConfig(t *testing.T) {
 	// TODO: FIXME
 	assert.Equal(t, nil, nil)
 }
+
+func TestWithMirrors(t *testing.T) {
+	hosts := docker.ConfigureDefaultRegistries()
+
+	out, err := withMirrors(hosts, nil)("docker.io")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(out))
+
+	out, err = withMirrors(hosts, map[string][]string{"docker.io": {"mirror.gcr.io"}})("docker.io")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(out))
+	assert.Equal(t, "mirror.gcr.io", out[0].Host)
+	assert.Equal(t, docker.HostCapabilityPull|docker.HostCapabilityResolve, out[0].Capabilities)
+	assert.Equal(t, "registry-1.docker.io", out[1].Host)
+	assert.Equal(t, docker.HostCapabilityPull|docker.HostCapabilityResolve|docker.HostCapabilityPush, out[1].Capabilities)
+
+	out, err = withMirrors(hosts, map[string][]string{"docker.io": {"mirror.gcr.io"}})("ghcr.io")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(out))
+	assert.Equal(t, "ghcr.io", out[0].Host)
+}
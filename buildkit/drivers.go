@@ -2,37 +2,255 @@ package buildkit
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"log"
+	"net"
 	"net/url"
+	"os"
+	"path/filepath"
 
+	"github.com/docker/buildx/store"
+	"github.com/docker/cli/cli/config"
+	dockerclient "github.com/docker/docker/client"
+	"github.com/hashicorp/go-multierror"
 	"github.com/moby/buildkit/client"
+	_ "github.com/moby/buildkit/client/connhelper/dockercontainer" // register docker-container://
+	_ "github.com/moby/buildkit/client/connhelper/kubepod"         // register kube-pod://
 	gateway "github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/moby/buildkit/solver/pb"
 	"github.com/moby/buildkit/util/apicaps"
 	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+
+	"github.com/craftslab/copatcher/types"
+	"github.com/craftslab/copatcher/utils"
+)
+
+const (
+	DefaultAddr       = "unix:///run/buildkit/buildkitd.sock"
+	DefaultDockerHost = "unix:///var/run/docker.sock"
+	EnvBuildkitHost   = "BUILDKIT_HOST"
 )
 
 const (
-	DefaultAddr = "unix:///run/buildkit/buildkitd.sock"
+	buildxContainerPrefix = "buildx_buildkit_"
+	buildxCurrentFile     = "current"
+	buildxInstancesDir    = "instances"
+	buildxRootDir         = "buildx"
 )
 
 var (
 	errMissingCap = fmt.Errorf("missing required buildkit functionality")
 	// requiredCaps are buildkit llb ops required to function.
 	requiredCaps = []apicaps.CapID{pb.CapMergeOp, pb.CapDiffOp}
+	// capVersions are the minimum buildkitd versions providing the caps.
+	capVersions = map[apicaps.CapID]string{
+		pb.CapFileBase:          "v0.5.0",
+		pb.CapMergeOp:           "v0.10.0",
+		pb.CapDiffOp:            "v0.10.0",
+		pb.CapAnnotations:       "v0.11.0",
+		pb.CapAttestations:      "v0.11.0",
+		pb.CapSourcePolicy:      "v0.12.0",
+		pb.CapMultipleExporters: "v0.13.0",
+	}
 )
 
+// candidate is a buildkit endpoint considered during auto discovery.
+type candidate struct {
+	name string
+	addr string
+	opts []client.ClientOpt
+}
+
+// missingCapError reports a cap which is not supported by the connected buildkitd.
+type missingCapError struct {
+	id  apicaps.CapID
+	err error
+}
+
+func (e *missingCapError) Error() string {
+	if v, ok := capVersions[e.id]; ok {
+		return fmt.Sprintf("%s (requires buildkitd %s or later)", e.id, v)
+	}
+
+	return e.err.Error()
+}
+
+func (e *missingCapError) Unwrap() error {
+	return e.err
+}
+
+// buildxCurrent mirrors the "current" file written by buildx into the docker config directory.
+type buildxCurrent struct {
+	Key    string
+	Name   string
+	Global bool
+}
+
 // NewClient returns a new buildkit client with the given addr.
-// If addr is empty it will first try to connect to docker's buildkit instance and then fallback to DefaultAddr.
+// If addr is empty it will try BUILDKIT_HOST, the current buildx builder, docker's buildkit instance
+// and then fallback to DefaultAddr, using the first endpoint which passes ValidateClient.
 func NewClient(ctx context.Context, bkOpts Opts) (*client.Client, error) {
+	clt, _, err := Connect(ctx, bkOpts)
+	return clt, err
+}
+
+// Connect is like NewClient but also returns the address of the buildkit endpoint connected to.
+func Connect(ctx context.Context, bkOpts Opts) (*client.Client, string, error) {
 	opts := getCredentialOptions(bkOpts)
 
-	clt, err := client.New(ctx, bkOpts.Addr, opts...)
+	if bkOpts.Addr != "" {
+		clt, err := client.New(ctx, bkOpts.Addr, opts...)
+		if err != nil {
+			return nil, "", types.NewError(types.ErrorKindBuildkitUnreachable, errors.Wrap(err, "failed to run new"))
+		}
+		// The client connects lazily, so check the endpoint is reachable and capable before patching,
+		// as the discovered candidates are
+		if err := ValidateClient(ctx, clt); err != nil {
+			_ = clt.Close()
+			return nil, "", types.NewError(types.ErrorKindBuildkitUnreachable, errors.Wrapf(err, "failed to validate %s", bkOpts.Addr))
+		}
+		utils.GetLogger(bkOpts.Logger).Printf("using buildkit endpoint %s", bkOpts.Addr)
+		return clt, bkOpts.Addr, nil
+	}
+
+	clt, addr, err := autoClient(ctx, getCandidates(ctx, bkOpts, config.Dir()), utils.GetLogger(bkOpts.Logger))
+	if err != nil {
+		return nil, "", types.NewError(types.ErrorKindBuildkitUnreachable, err)
+	}
+
+	return clt, addr, nil
+}
+
+// autoClient returns a client for the first candidate which passes ValidateClient, and its address.
+func autoClient(ctx context.Context, candidates []candidate, logger *log.Logger) (*client.Client, string, error) {
+	var allErrors *multierror.Error
+
+	for _, c := range candidates {
+		clt, err := client.New(ctx, c.addr, c.opts...)
+		if err != nil {
+			allErrors = multierror.Append(allErrors, errors.Wrapf(err, "failed to connect to %s", c.name))
+			continue
+		}
+		if err := ValidateClient(ctx, clt); err != nil {
+			_ = clt.Close()
+			allErrors = multierror.Append(allErrors, errors.Wrapf(err, "failed to validate %s", c.name))
+			continue
+		}
+		logger.Printf("using buildkit endpoint %s (%s)", c.addr, c.name)
+		return clt, c.addr, nil
+	}
+
+	if allErrors == nil {
+		return nil, "", errors.New("no buildkit endpoint found")
+	}
+
+	return nil, "", errors.Wrap(allErrors.ErrorOrNil(), "failed to find buildkit endpoint")
+}
+
+// getCandidates lists the buildkit endpoints to auto discover in order of precedence.
+func getCandidates(ctx context.Context, bkOpts Opts, configDir string) []candidate {
+	var candidates []candidate
+
+	if addr := os.Getenv(EnvBuildkitHost); addr != "" {
+		candidates = append(candidates, newCandidate(EnvBuildkitHost, addr, bkOpts))
+	}
+
+	if c, err := getBuildxCandidate(ctx, configDir); err == nil && c != nil {
+		candidates = append(candidates, *c)
+	}
+
+	if c, err := getDockerCandidate(ctx, "docker", DefaultDockerHost); err == nil {
+		candidates = append(candidates, *c)
+	}
+
+	candidates = append(candidates, newCandidate("default", DefaultAddr, bkOpts))
+
+	return candidates
+}
+
+// newCandidate returns the candidate for addr with the credentials of bkOpts.
+func newCandidate(name, addr string, bkOpts Opts) candidate {
+	bkOpts.Addr = addr
+
+	return candidate{
+		name: name,
+		addr: addr,
+		opts: getCredentialOptions(bkOpts),
+	}
+}
+
+// getBuildxCandidate returns the candidate for the current buildx builder found in configDir.
+// It returns nil if no builder is selected.
+func getBuildxCandidate(ctx context.Context, configDir string) (*candidate, error) {
+	root := filepath.Join(configDir, buildxRootDir)
+
+	buf, err := os.ReadFile(filepath.Join(root, buildxCurrentFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read current builder")
+	}
+
+	var current buildxCurrent
+	if err := json.Unmarshal(buf, &current); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal current builder")
+	}
+
+	if current.Name == "" {
+		return nil, nil
+	}
+
+	buf, err = os.ReadFile(filepath.Join(root, buildxInstancesDir, current.Name))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to run new")
+		return nil, errors.Wrap(err, "failed to read builder instance")
 	}
 
-	return clt, nil
+	var ng store.NodeGroup
+	if err := json.Unmarshal(buf, &ng); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal builder instance")
+	}
+
+	if len(ng.Nodes) == 0 {
+		return nil, errors.Errorf("builder %s has no nodes", ng.Name)
+	}
+
+	node := ng.Nodes[0]
+	name := "buildx builder " + ng.Name
+
+	switch ng.Driver {
+	case "docker-container":
+		return &candidate{name: name, addr: "docker-container://" + buildxContainerPrefix + node.Name}, nil
+	case "remote":
+		return &candidate{name: name, addr: node.Endpoint}, nil
+	case "docker":
+		host := node.Endpoint
+		if u, e := url.Parse(host); e != nil || u.Scheme == "" {
+			host = DefaultDockerHost
+		}
+		return getDockerCandidate(ctx, name, host)
+	default:
+		return nil, errors.Errorf("unsupported buildx driver %s", ng.Driver)
+	}
+}
+
+// getDockerCandidate returns the candidate for the buildkit instance embedded in the docker daemon at host.
+func getDockerCandidate(_ context.Context, name, host string) (*candidate, error) {
+	cli, err := dockerclient.NewClientWithOpts(dockerclient.WithHost(host), dockerclient.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create docker client")
+	}
+
+	opts := []client.ClientOpt{
+		client.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return cli.DialHijack(ctx, "/grpc", "h2c", nil)
+		}),
+		client.WithSessionDialer(func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+			return cli.DialHijack(ctx, "/session", proto, meta)
+		}),
+	}
+
+	return &candidate{name: name, addr: host, opts: opts}, nil
 }
 
 func getCredentialOptions(bkOpts Opts) []client.ClientOpt {
@@ -58,18 +276,34 @@ func getServerNameFromAddr(addr string) string {
 }
 
 // ValidateClient checks to ensure the connected buildkit instance supports the features required by copa.
-func ValidateClient(ctx context.Context, c *client.Client) error {
+// The caps are checked in addition to requiredCaps, and every missing one is reported together with the
+// minimum buildkitd version providing it.
+func ValidateClient(ctx context.Context, c *client.Client, caps ...apicaps.CapID) error {
 	_, err := c.Build(ctx, client.SolveOpt{}, "", func(ctx context.Context, client gateway.Client) (*gateway.Result, error) {
 		capset := client.BuildOpts().LLBCaps
-		var err error
-		for _, _cap := range requiredCaps {
-			err = errors.Wrap(err, capset.Supports(_cap).Error())
+		var errs []error
+		for _, _cap := range getRequiredCaps(caps) {
+			if err := capset.Supports(_cap); err != nil {
+				errs = append(errs, &missingCapError{id: _cap, err: err})
+			}
 		}
-		if err != nil {
-			return nil, errors.Wrap(err, errMissingCap.Error())
+		if len(errs) != 0 {
+			return nil, errors.Wrap(stderrors.Join(errs...), errMissingCap.Error())
 		}
 		return &gateway.Result{}, nil
 	}, nil)
 
 	return err
 }
+
+func getRequiredCaps(caps []apicaps.CapID) []apicaps.CapID {
+	out := append([]apicaps.CapID{}, requiredCaps...)
+
+	for _, c := range caps {
+		if !slices.Contains(out, c) {
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
@@ -1,9 +1,19 @@
 package buildkit
 
 import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/moby/buildkit/util/apicaps"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/types"
 )
 
 func TestDriversNewClient(t *testing.T) {
@@ -22,11 +32,156 @@ func TestDriversGetServerNameFromAddr(t *testing.T) {
 }
 
 func TestValidateClient(t *testing.T) {
-	// TODO: FIXME
-	assert.Equal(t, nil, nil)
+	ctx := context.Background()
+
+	t.Run("missing caps with versions", func(t *testing.T) {
+		addr := newMockBuildkitAPI(t, pb.CapMergeOp)
+		_client, err := client.New(ctx, prefix+addr)
+		assert.NoError(t, err)
+		defer func(c *client.Client) {
+			_ = c.Close()
+		}(_client)
+		ctxT, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		err = ValidateClient(ctxT, _client, pb.CapFileBase)
+		checkMissingCapsError(t, err, pb.CapDiffOp, pb.CapFileBase)
+		assert.Contains(t, err.Error(), "diffop (requires buildkitd v0.10.0 or later)")
+		assert.Contains(t, err.Error(), "file.base (requires buildkitd v0.5.0 or later)")
+		assert.NotContains(t, err.Error(), "mergeop")
+	})
+
+	t.Run("extra caps supported", func(t *testing.T) {
+		addr := newMockBuildkitAPI(t, append(requiredCaps, pb.CapFileBase)...)
+		_client, err := NewClient(ctx, Opts{Addr: prefix + addr})
+		assert.NoError(t, err)
+		defer func(c *client.Client) {
+			_ = c.Close()
+		}(_client)
+		ctxT, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		assert.NoError(t, ValidateClient(ctxT, _client, pb.CapFileBase))
+	})
+}
+
+func TestConnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("explicit address", func(t *testing.T) {
+		addr := newMockBuildkitAPI(t, requiredCaps...)
+		_client, endpoint, err := Connect(ctx, Opts{Addr: prefix + addr})
+		assert.NoError(t, err)
+		assert.Equal(t, prefix+addr, endpoint)
+		_ = _client.Close()
+	})
+
+	t.Run("explicit address missing caps", func(t *testing.T) {
+		addr := newMockBuildkitAPI(t, pb.CapMergeOp)
+		_client, _, err := Connect(ctx, Opts{Addr: prefix + addr})
+		assert.Nil(t, _client)
+		checkMissingCapsError(t, err, pb.CapDiffOp)
+		assert.Equal(t, types.ErrorKindBuildkitUnreachable, types.GetErrorKind(err))
+	})
+}
+
+func TestGetRequiredCaps(t *testing.T) {
+	assert.Equal(t, requiredCaps, getRequiredCaps(nil))
+	assert.Equal(t, []apicaps.CapID{pb.CapMergeOp, pb.CapDiffOp, pb.CapFileBase},
+		getRequiredCaps([]apicaps.CapID{pb.CapDiffOp, pb.CapFileBase}))
 }
 
 func TestAutoClient(t *testing.T) {
-	// TODO: FIXME
-	assert.Equal(t, nil, nil)
+	ctx := context.Background()
+
+	t.Run("first valid candidate", func(t *testing.T) {
+		addr := newMockBuildkitAPI(t, requiredCaps...)
+		candidates := []candidate{
+			{name: "unreachable", addr: prefix + filepath.Join(t.TempDir(), "missing.sock")},
+			{name: "mock", addr: prefix + addr},
+		}
+		ctxT, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		_client, addr, err := autoClient(ctxT, candidates, log.Default())
+		assert.NoError(t, err)
+		assert.NotNil(t, _client)
+		assert.Equal(t, candidates[1].addr, addr)
+		defer func(c *client.Client) {
+			_ = c.Close()
+		}(_client)
+	})
+
+	t.Run("no valid candidate", func(t *testing.T) {
+		candidates := []candidate{
+			{name: "unreachable", addr: prefix + filepath.Join(t.TempDir(), "missing.sock")},
+		}
+		ctxT, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		_client, _, err := autoClient(ctxT, candidates, log.Default())
+		assert.Error(t, err)
+		assert.Nil(t, _client)
+	})
+
+	t.Run("buildkit host first", func(t *testing.T) {
+		t.Setenv(EnvBuildkitHost, "tcp://127.0.0.1:1234")
+		candidates := getCandidates(ctx, Opts{}, t.TempDir())
+		assert.NotEmpty(t, candidates)
+		assert.Equal(t, EnvBuildkitHost, candidates[0].name)
+		assert.Equal(t, "tcp://127.0.0.1:1234", candidates[0].addr)
+		assert.Equal(t, DefaultAddr, candidates[len(candidates)-1].addr)
+	})
+}
+
+func TestGetBuildxCandidate(t *testing.T) {
+	ctx := context.Background()
+
+	write := func(t *testing.T, dir, current, instance string) {
+		t.Helper()
+		root := filepath.Join(dir, buildxRootDir)
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, buildxInstancesDir), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(root, buildxCurrentFile), []byte(current), 0o600))
+		if instance != "" {
+			assert.NoError(t, os.WriteFile(filepath.Join(root, buildxInstancesDir, "builder"), []byte(instance), 0o600))
+		}
+	}
+
+	t.Run("no config", func(t *testing.T) {
+		c, err := getBuildxCandidate(ctx, t.TempDir())
+		assert.Error(t, err)
+		assert.Nil(t, c)
+	})
+
+	t.Run("default builder", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, `{"Key":"unix:///var/run/docker.sock","Name":"","Global":false}`, "")
+		c, err := getBuildxCandidate(ctx, dir)
+		assert.NoError(t, err)
+		assert.Nil(t, c)
+	})
+
+	t.Run("docker-container driver", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, `{"Key":"unix:///var/run/docker.sock","Name":"builder","Global":false}`,
+			`{"Name":"builder","Driver":"docker-container","Nodes":[{"Name":"builder0","Endpoint":"unix:///var/run/docker.sock"}]}`)
+		c, err := getBuildxCandidate(ctx, dir)
+		assert.NoError(t, err)
+		assert.Equal(t, "docker-container://buildx_buildkit_builder0", c.addr)
+	})
+
+	t.Run("remote driver", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, `{"Key":"unix:///var/run/docker.sock","Name":"builder","Global":false}`,
+			`{"Name":"builder","Driver":"remote","Nodes":[{"Name":"builder0","Endpoint":"tcp://10.0.0.1:1234"}]}`)
+		c, err := getBuildxCandidate(ctx, dir)
+		assert.NoError(t, err)
+		assert.Equal(t, "tcp://10.0.0.1:1234", c.addr)
+	})
+
+	t.Run("unsupported driver", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, `{"Key":"unix:///var/run/docker.sock","Name":"builder","Global":false}`,
+			`{"Name":"builder","Driver":"kubernetes","Nodes":[{"Name":"builder0","Endpoint":"kubernetes:///builder"}]}`)
+		c, err := getBuildxCandidate(ctx, dir)
+		assert.Error(t, err)
+		assert.Nil(t, c)
+	})
 }
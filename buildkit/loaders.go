@@ -0,0 +1,127 @@
+package buildkit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/copatcher/utils"
+)
+
+// Load targets supported by NewLoader.
+const (
+	LoadTargetContainerd = "containerd"
+	LoadTargetDocker     = "docker"
+	LoadTargetPodman     = "podman"
+)
+
+const (
+	DefaultContainerdAddr      = "/run/containerd/containerd.sock"
+	DefaultContainerdNamespace = "default"
+)
+
+// Loader loads the image tarball exported by buildkit into an image store.
+type Loader interface {
+	Load(context.Context, io.Reader) error
+}
+
+type LoaderOpts struct {
+	Target              string
+	ContainerdAddr      string
+	ContainerdNamespace string
+	// Logger logs the loaded images, which is the standard logger if nil.
+	Logger *log.Logger
+}
+
+// NewLoader returns the loader for the load target, which defaults to docker.
+func NewLoader(opts LoaderOpts) (Loader, error) {
+	switch opts.Target {
+	case "", LoadTargetDocker:
+		return &cmdLoader{name: "docker", args: []string{"load"}, logger: opts.Logger}, nil
+	case LoadTargetPodman:
+		return &cmdLoader{name: "podman", args: []string{"load"}, logger: opts.Logger}, nil
+	case LoadTargetContainerd:
+		l := &containerdLoader{
+			addr:      opts.ContainerdAddr,
+			namespace: opts.ContainerdNamespace,
+			logger:    opts.Logger,
+		}
+		if l.addr == "" {
+			l.addr = DefaultContainerdAddr
+		}
+		if l.namespace == "" {
+			l.namespace = DefaultContainerdNamespace
+		}
+		return l, nil
+	default:
+		return nil, errors.Errorf("unsupported load target %s", opts.Target)
+	}
+}
+
+// cmdLoader loads the image by piping it into the load command of a container engine cli.
+type cmdLoader struct {
+	name   string
+	args   []string
+	logger *log.Logger
+}
+
+func (l *cmdLoader) Load(ctx context.Context, pipeR io.Reader) error {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, l.name, l.args...)
+	cmd.Stdin = pipeR
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "failed to run %s %s (stdout: %q, stderr: %q)",
+			l.name, strings.Join(l.args, " "), strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()))
+	}
+
+	if out := strings.TrimSpace(stdout.String()); out != "" {
+		utils.GetLogger(l.logger).Print(out)
+	}
+
+	return nil
+}
+
+// containerdLoader imports and unpacks the image into a containerd namespace, e.g. k8s.io for k3s and nerdctl.
+type containerdLoader struct {
+	addr      string
+	namespace string
+	logger    *log.Logger
+}
+
+func (l *containerdLoader) Load(ctx context.Context, pipeR io.Reader) error {
+	clt, err := containerd.New(l.addr, containerd.WithDefaultNamespace(l.namespace))
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to containerd")
+	}
+
+	defer func(c *containerd.Client) {
+		_ = c.Close()
+	}(clt)
+
+	ctx = namespaces.WithNamespace(ctx, l.namespace)
+
+	imgs, err := clt.Import(ctx, pipeR, containerd.WithAllPlatforms(true))
+	if err != nil {
+		return errors.Wrap(err, "failed to import image")
+	}
+
+	for _, img := range imgs {
+		if err := containerd.NewImage(clt, img).Unpack(ctx, ""); err != nil {
+			return errors.Wrapf(err, "failed to unpack image %s", img.Name)
+		}
+		utils.GetLogger(l.logger).Printf("Loaded image: %s (namespace %s)", img.Name, l.namespace)
+	}
+
+	return nil
+}
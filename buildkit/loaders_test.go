@@ -0,0 +1,78 @@
+package buildkit
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLoader(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    LoaderOpts
+		want    Loader
+		wantErr bool
+	}{
+		{
+			name: "default",
+			opts: LoaderOpts{},
+			want: &cmdLoader{name: "docker", args: []string{"load"}},
+		},
+		{
+			name: "docker",
+			opts: LoaderOpts{Target: LoadTargetDocker},
+			want: &cmdLoader{name: "docker", args: []string{"load"}},
+		},
+		{
+			name: "podman",
+			opts: LoaderOpts{Target: LoadTargetPodman},
+			want: &cmdLoader{name: "podman", args: []string{"load"}},
+		},
+		{
+			name: "containerd defaults",
+			opts: LoaderOpts{Target: LoadTargetContainerd},
+			want: &containerdLoader{addr: DefaultContainerdAddr, namespace: DefaultContainerdNamespace},
+		},
+		{
+			name: "containerd namespace",
+			opts: LoaderOpts{Target: LoadTargetContainerd, ContainerdAddr: "/run/k3s/containerd/containerd.sock", ContainerdNamespace: "k8s.io"},
+			want: &containerdLoader{addr: "/run/k3s/containerd/containerd.sock", namespace: "k8s.io"},
+		},
+		{
+			name:    "unsupported",
+			opts:    LoaderOpts{Target: "unsupported"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NewLoader(tc.opts)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCmdLoaderLoad(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		l := &cmdLoader{name: "sh", args: []string{"-c", "cat > /dev/null && echo loaded"}}
+		assert.NoError(t, l.Load(ctx, strings.NewReader("image")))
+	})
+
+	t.Run("failure with output", func(t *testing.T) {
+		l := &cmdLoader{name: "sh", args: []string{"-c", "echo partial && echo invalid tar header >&2 && exit 1"}}
+		err := l.Load(ctx, strings.NewReader("image"))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), `stdout: "partial"`)
+		assert.Contains(t, err.Error(), `stderr: "invalid tar header"`)
+	})
+}
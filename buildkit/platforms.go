@@ -0,0 +1,250 @@
+package buildkit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	gateway "github.com/moby/buildkit/frontend/gateway/client"
+	gatewaypb "github.com/moby/buildkit/frontend/gateway/pb"
+	"github.com/moby/buildkit/solver/result"
+	"github.com/moby/buildkit/util/contentutil"
+	"github.com/moby/buildkit/util/imageutil"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxIndexSize limits the size of the image index read from the registry.
+	maxIndexSize = 4 << 20
+	// unknownOS is the platform os of attestation manifests in image indexes.
+	unknownOS = "unknown"
+	// attestationPath is the path of the predicate in the attestation ref.
+	attestationPath = "/predicate.json"
+	// attestationPerm is the permission of the predicate in the attestation ref.
+	attestationPerm = 0o644
+)
+
+// PlatformImage is the patched image state, config, manifest annotations and attestations of a platform.
+type PlatformImage struct {
+	Platform     ispec.Platform
+	State        *llb.State
+	ConfigData   []byte
+	Annotations  map[string]string
+	Attestations []Attestation
+}
+
+// Attestation is an in-toto predicate attached to the image, with the image manifest as its subject.
+type Attestation struct {
+	PredicateType string
+	Predicate     []byte
+}
+
+// PlatformID returns the platform in the form of os/arch[/variant], e.g. linux/arm/v7.
+func PlatformID(platform ispec.Platform) string {
+	return platforms.Format(platforms.Normalize(platform))
+}
+
+// ResolveMediaType returns the media type of the manifest of the image, e.g. of an image index.
+func ResolveMediaType(ctx context.Context, ref string, reg *Registry) (string, error) {
+	_, desc, err := NewResolver(reg).Resolve(ctx, ref)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve image")
+	}
+
+	return desc.MediaType, nil
+}
+
+// ResolvePlatforms returns the platforms of the image in the order of its image index.
+// The platform of the image config is returned for an image which is not multi-platform.
+func ResolvePlatforms(ctx context.Context, ref string, reg *Registry) ([]ispec.Platform, error) {
+	resolver := NewResolver(reg)
+
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve image")
+	}
+
+	if !images.IsIndexType(desc.MediaType) {
+		return resolveConfigPlatform(ctx, ref, reg)
+	}
+
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get fetcher")
+	}
+
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch image index")
+	}
+
+	defer func(rc io.ReadCloser) {
+		_ = rc.Close()
+	}(rc)
+
+	buf, err := io.ReadAll(io.LimitReader(rc, maxIndexSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read image index")
+	}
+
+	return parseIndexPlatforms(buf)
+}
+
+func resolveConfigPlatform(ctx context.Context, ref string, reg *Registry) ([]ispec.Platform, error) {
+	_, buf, err := imageutil.Config(ctx, ref, NewResolver(reg), contentutil.NewBuffer(), nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to run config")
+	}
+
+	var img ispec.Image
+	if err := json.Unmarshal(buf, &img); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal image config")
+	}
+
+	return []ispec.Platform{img.Platform}, nil
+}
+
+func parseIndexPlatforms(buf []byte) ([]ispec.Platform, error) {
+	var index ispec.Index
+	if err := json.Unmarshal(buf, &index); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal image index")
+	}
+
+	var out []ispec.Platform
+
+	for _, m := range index.Manifests {
+		// Skip the attestation manifests which are not images
+		if m.Platform == nil || m.Platform.OS == unknownOS {
+			continue
+		}
+		out = append(out, *m.Platform)
+	}
+
+	if len(out) == 0 {
+		return nil, errors.New("no platform found in image index")
+	}
+
+	return out, nil
+}
+
+// buildPlatformImages returns the build func solving the images, which are exported as an image index
+// keeping their order if there are more than one or if they have attestations to attach.
+// The attestations are dropped if attest is false, e.g. for the docker exporter which rejects image indexes.
+// nolint: funlen
+func buildPlatformImages(images []PlatformImage, attest bool) gateway.BuildFunc {
+	return func(ctx context.Context, c gateway.Client) (*gateway.Result, error) {
+		res := gateway.NewResult()
+		ps := exptypes.Platforms{}
+		index := len(images) > 1 || (attest && hasAttestations(images))
+
+		for _, img := range images {
+			def, err := img.State.Marshal(ctx, llb.Platform(img.Platform))
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to run marshal")
+			}
+			r, err := c.Solve(ctx, gateway.SolveRequest{
+				Definition: def.ToPB(),
+				Evaluate:   true,
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to solve %s", PlatformID(img.Platform))
+			}
+			ref, err := r.SingleRef()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get ref")
+			}
+			if !index {
+				res.SetRef(ref)
+				// Pass through resolved configData from original image
+				res.AddMeta(exptypes.ExporterImageConfigKey, img.ConfigData)
+				return res, nil
+			}
+			id := PlatformID(img.Platform)
+			res.AddRef(id, ref)
+			res.AddMeta(exptypes.ExporterImageConfigKey+"/"+id, img.ConfigData)
+			ps.Platforms = append(ps.Platforms, exptypes.Platform{ID: id, Platform: img.Platform})
+			if !attest {
+				continue
+			}
+			for _, a := range img.Attestations {
+				att, err := solveAttestation(ctx, c, a)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to solve attestation of %s", id)
+				}
+				res.AddAttestation(id, *att)
+			}
+		}
+
+		buf, err := json.Marshal(ps)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal platforms")
+		}
+
+		res.AddMeta(exptypes.ExporterPlatformsKey, buf)
+
+		return res, nil
+	}
+}
+
+// SupportsIndex reports whether the patched images exported to the output are exported as an image index,
+// which is required for multiple platforms or attestations. The docker exporter of a docker-archive output or an
+// image store load exports a single image, and the output is nil for the latter.
+func SupportsIndex(push bool, output *Output) bool {
+	return push || (output != nil && output.Type != OutputDockerArchive)
+}
+
+// supportsAttestations reports whether the exporter exports an image index with the attestations.
+func supportsAttestations(export client.ExportEntry) bool {
+	return export.Type != client.ExporterDocker
+}
+
+func hasAttestations(images []PlatformImage) bool {
+	for i := range images {
+		if len(images[i].Attestations) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// solveAttestation solves the predicate into a ref, which buildkit wraps into an in-toto statement
+// with the exported image manifest as its subject.
+func solveAttestation(ctx context.Context, c gateway.Client, a Attestation) (*gateway.Attestation, error) {
+	st := llb.Scratch().File(llb.Mkfile(attestationPath, attestationPerm, a.Predicate))
+
+	def, err := st.Marshal(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to run marshal")
+	}
+
+	r, err := c.Solve(ctx, gateway.SolveRequest{
+		Definition: def.ToPB(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to solve")
+	}
+
+	ref, err := r.SingleRef()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get ref")
+	}
+
+	return &gateway.Attestation{
+		Kind: gatewaypb.AttestationKindInToto,
+		Ref:  ref,
+		Path: attestationPath,
+		InToto: result.InTotoAttestation{
+			PredicateType: a.PredicateType,
+			Subjects: []result.InTotoSubject{
+				{Kind: gatewaypb.InTotoSubjectKindSelf},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,141 @@
+package buildkit
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	gateway "github.com/moby/buildkit/frontend/gateway/client"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockGatewayClient struct {
+	gateway.Client
+}
+
+type mockReference struct {
+	gateway.Reference
+}
+
+func (m *mockGatewayClient) Solve(context.Context, gateway.SolveRequest) (*gateway.Result, error) {
+	res := gateway.NewResult()
+	res.SetRef(&mockReference{})
+
+	return res, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestPlatformID(t *testing.T) {
+	assert.Equal(t, "linux/amd64", PlatformID(ispec.Platform{OS: "linux", Architecture: "amd64"}))
+	assert.Equal(t, "linux/arm/v7", PlatformID(ispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}))
+	assert.Equal(t, "linux/arm64", PlatformID(ispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}))
+}
+
+func TestParseIndexPlatforms(t *testing.T) {
+	t.Run("platforms in order", func(t *testing.T) {
+		buf := []byte(`{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.index.v1+json",
+  "manifests": [
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:1", "size": 1,
+     "platform": {"architecture": "arm", "os": "linux", "variant": "v7"}},
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:2", "size": 1,
+     "platform": {"architecture": "amd64", "os": "linux"}},
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:3", "size": 1,
+     "platform": {"architecture": "unknown", "os": "unknown"}}
+  ]
+}`)
+		got, err := parseIndexPlatforms(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, []ispec.Platform{
+			{OS: "linux", Architecture: "arm", Variant: "v7"},
+			{OS: "linux", Architecture: "amd64"},
+		}, got)
+	})
+
+	t.Run("no platforms", func(t *testing.T) {
+		_, err := parseIndexPlatforms([]byte(`{"schemaVersion": 2, "manifests": []}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid index", func(t *testing.T) {
+		_, err := parseIndexPlatforms([]byte("invalid"))
+		assert.Error(t, err)
+	})
+}
+
+func TestHasAttestations(t *testing.T) {
+	assert.False(t, hasAttestations([]PlatformImage{{}, {}}))
+	assert.True(t, hasAttestations([]PlatformImage{{}, {Attestations: []Attestation{{PredicateType: "https://slsa.dev/provenance/v0.2"}}}}))
+}
+
+func TestBuildPlatformImages(t *testing.T) {
+	amd64 := ispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ispec.Platform{OS: "linux", Architecture: "arm64"}
+	attestations := []Attestation{{PredicateType: "https://slsa.dev/provenance/v0.2", Predicate: []byte("{}")}}
+
+	tests := []struct {
+		name   string
+		export client.ExportEntry
+		images []PlatformImage
+		index  bool
+	}{
+		{"push", getPushExportEntry("image:patched", nil), []PlatformImage{{Platform: amd64, Attestations: attestations}}, true},
+		{"oci-layout", getExportEntry(&Output{Type: OutputOCILayout, Path: "out"}, "image:patched"),
+			[]PlatformImage{{Platform: amd64, Attestations: attestations}}, true},
+		{"oci-archive", getExportEntry(&Output{Type: OutputOCIArchive, Path: "out.tar"}, "image:patched"),
+			[]PlatformImage{{Platform: amd64, Attestations: attestations}}, true},
+		{"oci-archive without attestations", getExportEntry(&Output{Type: OutputOCIArchive, Path: "out.tar"}, "image:patched"),
+			[]PlatformImage{{Platform: amd64}}, false},
+		{"oci-archive multi-platform", getExportEntry(&Output{Type: OutputOCIArchive, Path: "out.tar"}, "image:patched"),
+			[]PlatformImage{{Platform: amd64}, {Platform: arm64}}, true},
+		{"docker-archive", getExportEntry(&Output{Type: OutputDockerArchive, Path: "out.tar"}, "image:patched"),
+			[]PlatformImage{{Platform: amd64, Attestations: attestations}}, false},
+		{"load", getLoadExportEntry("image:patched", nopWriteCloser{io.Discard}),
+			[]PlatformImage{{Platform: amd64, Attestations: attestations}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.images {
+				st := llb.Scratch()
+				tt.images[i].State = &st
+			}
+
+			res, err := buildPlatformImages(tt.images, supportsAttestations(tt.export))(context.Background(), &mockGatewayClient{})
+			assert.NoError(t, err)
+
+			if !tt.index {
+				assert.NotNil(t, res.Ref)
+				assert.Empty(t, res.Refs)
+				assert.Empty(t, res.Attestations)
+				assert.NotContains(t, res.Metadata, exptypes.ExporterPlatformsKey)
+				return
+			}
+
+			assert.Nil(t, res.Ref)
+			assert.Len(t, res.Refs, len(tt.images))
+			assert.Contains(t, res.Metadata, exptypes.ExporterPlatformsKey)
+			assert.Len(t, res.Attestations[PlatformID(amd64)], len(tt.images[0].Attestations))
+		})
+	}
+}
+
+func TestSupportsIndex(t *testing.T) {
+	assert.True(t, SupportsIndex(true, nil))
+	assert.True(t, SupportsIndex(false, &Output{Type: OutputOCILayout}))
+	assert.True(t, SupportsIndex(false, &Output{Type: OutputOCIArchive}))
+	assert.False(t, SupportsIndex(false, &Output{Type: OutputDockerArchive}))
+	assert.False(t, SupportsIndex(false, nil))
+}
@@ -0,0 +1,78 @@
+package buildkit
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/util/progress/progressui"
+	"github.com/pkg/errors"
+)
+
+type (
+	progressModeKey   struct{}
+	progressOutputKey struct{}
+	solveStatusKey    struct{}
+)
+
+// WithProgressMode returns the context whose solves display their progress in the mode, e.g. plain for the
+// concurrent solves of a batch which would garble the tty display.
+func WithProgressMode(ctx context.Context, mode progressui.DisplayMode) context.Context {
+	return context.WithValue(ctx, progressModeKey{}, mode)
+}
+
+func getProgressMode(ctx context.Context) progressui.DisplayMode {
+	if mode, ok := ctx.Value(progressModeKey{}).(progressui.DisplayMode); ok {
+		return mode
+	}
+
+	return progressui.AutoMode
+}
+
+// WithProgressOutput returns the context whose solves display their progress to w instead of stderr,
+// e.g. the log of a job.
+func WithProgressOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, progressOutputKey{}, w)
+}
+
+func getProgressOutput(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(progressOutputKey{}).(io.Writer); ok {
+		return w
+	}
+
+	return os.Stderr
+}
+
+// WithSolveStatusHandler returns the context whose solves report their status to the handler before it is
+// displayed, e.g. to stream the progress of a job.
+func WithSolveStatusHandler(ctx context.Context, fn func(*client.SolveStatus)) context.Context {
+	return context.WithValue(ctx, solveStatusKey{}, fn)
+}
+
+// displayProgress displays the solve status of the channel until it is closed.
+func displayProgress(ctx context.Context, ch chan *client.SolveStatus) error {
+	if fn, ok := ctx.Value(solveStatusKey{}).(func(*client.SolveStatus)); ok {
+		out := make(chan *client.SolveStatus)
+		go func(in chan *client.SolveStatus) {
+			defer close(out)
+			for s := range in {
+				fn(s)
+				out <- s
+			}
+		}(ch)
+		ch = out
+	}
+
+	d, err := progressui.NewDisplay(getProgressOutput(ctx), getProgressMode(ctx))
+	if err != nil {
+		return errors.Wrap(err, "failed to new display")
+	}
+
+	// not using shared context to not disrupt display but let us finish reporting errors
+	if _, err := d.UpdateFrom(context.TODO(), ch); err != nil {
+		return errors.Wrap(err, "failed to display solve status")
+	}
+
+	return nil
+}
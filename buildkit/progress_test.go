@@ -0,0 +1,42 @@
+package buildkit
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/util/progress/progressui"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisplayProgress(t *testing.T) {
+	var buf bytes.Buffer
+	var statuses []*client.SolveStatus
+
+	ctx := WithProgressMode(context.Background(), progressui.PlainMode)
+	ctx = WithProgressOutput(ctx, &buf)
+	ctx = WithSolveStatusHandler(ctx, func(s *client.SolveStatus) {
+		statuses = append(statuses, s)
+	})
+
+	now := time.Now()
+	ch := make(chan *client.SolveStatus)
+
+	go func() {
+		ch <- &client.SolveStatus{Vertexes: []*client.Vertex{{Digest: digest.FromString("apt"), Name: "apt update", Started: &now}}}
+		ch <- &client.SolveStatus{Vertexes: []*client.Vertex{{Digest: digest.FromString("apt"), Name: "apt update", Started: &now, Completed: &now}}}
+		close(ch)
+	}()
+
+	assert.Equal(t, nil, displayProgress(ctx, ch))
+	assert.Equal(t, 2, len(statuses))
+	assert.Contains(t, buf.String(), "apt update")
+}
+
+func TestGetProgressMode(t *testing.T) {
+	assert.Equal(t, progressui.AutoMode, getProgressMode(context.Background()))
+	assert.Equal(t, progressui.PlainMode, getProgressMode(WithProgressMode(context.Background(), progressui.PlainMode)))
+}
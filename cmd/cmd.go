@@ -2,31 +2,153 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 
+	"github.com/craftslab/copatcher/batch"
 	"github.com/craftslab/copatcher/buildkit"
 	"github.com/craftslab/copatcher/config"
+	"github.com/craftslab/copatcher/history"
+	"github.com/craftslab/copatcher/metrics"
 	"github.com/craftslab/copatcher/patcher"
 	"github.com/craftslab/copatcher/report"
+	"github.com/craftslab/copatcher/result"
+	"github.com/craftslab/copatcher/sbom"
+	"github.com/craftslab/copatcher/server"
+	"github.com/craftslab/copatcher/signature"
+	"github.com/craftslab/copatcher/types"
+	"github.com/craftslab/copatcher/utils"
 )
 
 var (
-	app          = kingpin.New("copatcher", "Container patcher").Version(config.Version + "-build-" + config.Build)
-	address      = app.Flag("address", "Address of buildkitd service").Default(buildkit.DefaultAddr).String()
-	ignoreErrors = app.Flag("ignore-errors", "Ignore errors and continue patching").Bool()
-	image        = app.Flag("image", "Application image name and tag to patch").Required().String()
-	reportFile   = app.Flag("report", "Report file generated by container-diff").Required().String()
-	tag          = app.Flag("tag", "Tag for the patched image").Required().String()
-	timeout      = app.Flag("timeout", "Timeout for the operation").Default(patcher.DefaultTimeout).String()
+	app        = kingpin.New("copatcher", "Container patcher").Version(config.Version + "-build-" + config.Build)
+	configFile = app.Flag("config", "Config file with the profiles (copatcher.yaml if exists)").Envar(config.EnvFile).String()
+	profile    = app.Flag("profile", "Profile of the config file (its default profile if empty)").Envar(config.EnvProfile).String()
+
+	// userFlags are set if the flags of the settings are set by the user, which override the config.
+	userFlags = map[string]*bool{}
+
+	patchCmd         = app.Command("patch", "Patch an image with the packages of a report").Default()
+	address          = userFlag("address", "Address of buildkitd service (auto discovered if empty)").String()
+	containerd       = userFlag("containerd-address", "Address of containerd service for containerd load target").Default(buildkit.DefaultContainerdAddr).String()
+	namespace        = userFlag("containerd-namespace", "Namespace of containerd for containerd load target").Default(buildkit.DefaultContainerdNamespace).String()
+	ignoreErrors     = userFlag("ignore-errors", "Ignore errors and continue patching").Bool()
+	image            = patchCmd.Flag("image", "Application image reference to patch, i.e. name:tag or name@digest").Required().String()
+	insecure         = userFlag("insecure-registry", "Skip the TLS verification of registries when resolving images").Bool()
+	keepArtifacts    = userFlag("keep-artifacts", "Keep the working folder of the run with the probe output and results manifests for debugging").Bool()
+	loadTarget       = userFlag("load-target", "Image store to load the patched image into (docker, podman or containerd)").Default(buildkit.LoadTargetDocker).String()
+	multiPlatform    = patchCmd.Flag("multi-platform", "Patch the image of each platform in the image index (requires --push or an oci output)").Bool()
+	output           = userFlag("output", "Output of the patched image (oci-layout://DIR, oci-archive://FILE or docker-archive://FILE)").String()
+	phaseTimeouts    = userFlag("phase-timeout", "Timeout of a phase (resolve, probe, fetch, install, validate or export), e.g. fetch=10m").StringMap()
+	plainHTTP        = userFlag("plain-http", "Allow resolving from and pushing to registries over plain HTTP").Bool()
+	platformReports  = patchCmd.Flag("platform-report", "Report file of a platform used instead of the shared report, e.g. linux/arm/v7=report.json").StringMap()
+	provenanceOutput = userFlag("provenance-output", "File to write the provenance statement of a docker-archive or loaded image to (next to the docker-archive if empty)").String()
+	push             = userFlag("push", "Push the patched image to its registry").Bool()
+	reportFile       = patchCmd.Flag("report", "Report file of updates, or a Trivy or Grype json report").Required().String()
+	resultFormat     = userFlag("result-format", "Format of the patch result (json, markdown or junit)").Default(result.FormatJSON).Enum(result.FormatJSON, result.FormatMarkdown, result.FormatJUnit)
+	resultOutput     = userFlag("result-output", "File to write the patch result of each package to").String()
+	retries          = userFlag("retries", "Retries of the network bound phases (resolve, probe and fetch)").Default(strconv.Itoa(utils.DefaultRetries)).Int()
+	retryBackoff     = userFlag("retry-backoff", "Delay of the first retry, doubled for each next one").Default(utils.DefaultBackoff).Duration()
+	sbomFormat       = userFlag("sbom", "Attach an SBOM attestation of the pushed or oci image in the format (cyclonedx or spdx)").Enum(sbom.FormatCycloneDX, sbom.FormatSPDX)
+	sbomOutput       = userFlag("sbom-output", "File to write the SBOM of the patched image to (cyclonedx unless --sbom is set)").String()
+	signKey          = userFlag("sign-key", "ECDSA or ed25519 private key file to sign the pushed or oci-layout image with").String()
+	tag              = userFlag("tag", "Tag for the patched image (derived from the image tag or digest if empty)").String()
+	timeout          = userFlag("timeout", "Timeout for the operation").Default(patcher.DefaultTimeout).Duration()
+	vexOutput        = userFlag("vex-output", "File to write the OpenVEX document of the vulnerabilities fixed by the patch to").String()
+	workDir          = userFlag("work-dir", "Folder to create the unique working folder of each run in").Default(patcher.DefaultFolder).String()
+
+	batchCmd           = app.Command("batch", "Patch the images of the jobs of a job file concurrently")
+	batchFile          = batchCmd.Arg("file", "Job file with the image, report, tag and options of each job").Required().String()
+	batchMetricsListen = batchCmd.Flag("metrics-listen", "Address to serve the Prometheus metrics on while the jobs run (disabled if empty)").String()
+	batchWorkers       = batchCmd.Flag("workers", "Number of jobs patched concurrently (the workers of the job file or 4 if 0)").Int()
+
+	configCmd     = app.Command("config", "Manage the configuration")
+	configViewCmd = configCmd.Command("view", "Show the effective configuration merged from the environment, profile and defaults, with the secrets redacted")
+
+	historyCmd     = app.Command("history", "Query the patches recorded in the history by image, package or CVE")
+	historyCVE     = historyCmd.Flag("cve", "Vulnerability id of a package in the results, e.g. CVE-2023-0286").String()
+	historyFormat  = historyCmd.Flag("format", "Format of the records (table or json)").Default(history.FormatTable).Enum(history.FormatTable, history.FormatJSON)
+	historyImage   = historyCmd.Flag("image", "Image of the patches, which matches all its tags and digests if it is a name only").String()
+	historyLimit   = historyCmd.Flag("limit", "Number of the newest records to query (all if 0)").Int()
+	historyPackage = historyCmd.Flag("package", "Package in the results, which may be a glob pattern, e.g. libssl*").String()
+
+	serveCmd        = app.Command("serve", "Serve the REST and gRPC APIs to queue and run patch jobs")
+	serveGRPCListen = serveCmd.Flag("grpc-listen", "Address to listen on for the gRPC API (disabled if empty)").String()
+	serveListen     = serveCmd.Flag("listen", "Address to listen on").Default(server.DefaultAddr).String()
+	serveQueueSize  = serveCmd.Flag("queue-size", "Number of jobs queued before new jobs are rejected").Default(strconv.Itoa(server.DefaultQueueSize)).Int()
+	serveWorkers    = serveCmd.Flag("workers", "Number of jobs patched concurrently").Default(strconv.Itoa(server.DefaultWorkers)).Int()
+
+	verifyCmd       = app.Command("verify-signature", "Verify the signature of an image offline with a public key")
+	verifyImage     = verifyCmd.Flag("image", "Image reference to verify, i.e. name:tag or name@digest (optional for single image oci layout)").String()
+	verifyInsecure  = verifyCmd.Flag("insecure-registry", "Skip the TLS verification of the registry").Bool()
+	verifyKey       = verifyCmd.Flag("key", "ECDSA or ed25519 public key file, e.g. cosign.pub").Required().String()
+	verifyLayout    = verifyCmd.Flag("oci-layout", "OCI layout directory to verify the image in instead of its registry").String()
+	verifyPlainHTTP = verifyCmd.Flag("plain-http", "Allow pulling from the registry over plain HTTP").Bool()
 )
 
+// Exit codes of the errors returned by Run.
+const (
+	ExitSuccess             = 0
+	ExitFailure             = 1
+	ExitPartialPatch        = 2
+	ExitNoUpdates           = 3
+	ExitBadReport           = 4
+	ExitBuildkitUnreachable = 5
+	ExitExport              = 6
+)
+
+// userFlag defines the patch flag of a setting of the config, which overrides the config if set by the user.
+func userFlag(name, help string) *kingpin.FlagClause {
+	set := new(bool)
+	userFlags[name] = set
+
+	return patchCmd.Flag(name, help).IsSetByUser(set)
+}
+
+func isUserFlag(name string) bool {
+	return *userFlags[name]
+}
+
 func Run(ctx context.Context) error {
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
+	case batchCmd.FullCommand():
+		if err := runBatch(ctx); err != nil {
+			return errors.Wrap(err, "failed to run batch")
+		}
+		return nil
+	case configViewCmd.FullCommand():
+		if err := runConfigView(ctx); err != nil {
+			return errors.Wrap(err, "failed to view config")
+		}
+		return nil
+	case historyCmd.FullCommand():
+		if err := runHistory(ctx); err != nil {
+			return errors.Wrap(err, "failed to query history")
+		}
+		return nil
+	case serveCmd.FullCommand():
+		if err := runServe(ctx); err != nil {
+			return errors.Wrap(err, "failed to serve")
+		}
+		return nil
+	case verifyCmd.FullCommand():
+		if err := runVerify(ctx); err != nil {
+			return errors.Wrap(err, "failed to verify signature")
+		}
+		return nil
+	default:
+		return runPatch(ctx)
+	}
+}
 
+func runPatch(ctx context.Context) error {
 	cfg, err := initConfig(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to init config")
@@ -42,18 +164,142 @@ func Run(ctx context.Context) error {
 		return errors.Wrap(err, "failed to init patcher")
 	}
 
-	if err := runPatcher(ctx, pt); err != nil {
+	h, err := initHistory(ctx, cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to init history")
+	}
+
+	ctx, rec := history.WithRecorder(ctx, &history.Record{
+		ID:              uuid.NewString(),
+		Mode:            history.ModePatch,
+		Image:           *image,
+		Tag:             cfg.Output.Tag,
+		MultiPlatform:   *multiPlatform,
+		Report:          *reportFile,
+		PlatformReports: *platformReports,
+	})
+
+	err = runPatcher(ctx, pt)
+
+	if e := h.Add(ctx, rec.Finish(batch.GetStatus(err), err)); e != nil {
+		log.Printf("failed to add history: %v", e)
+	}
+
+	if err != nil {
 		return errors.Wrap(err, "failed to run patcher")
 	}
 
 	return nil
 }
 
+// initConfig resolves the config from the flags set by the user, the environment, the profile and the defaults.
 func initConfig(_ context.Context) (*config.Config, error) {
-	c := config.New()
+	c := defaultConfig()
+
+	if err := config.Load(c, *configFile, *profile, os.LookupEnv); err != nil {
+		return nil, errors.Wrap(err, "failed to load config")
+	}
+
+	if err := applyUserFlags(c); err != nil {
+		return nil, errors.Wrap(err, "failed to apply flags")
+	}
+
+	if err := patcher.ValidateConfig(c); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+
 	return c, nil
 }
 
+func defaultConfig() *config.Config {
+	c := config.New()
+
+	c.Output.ContainerdAddress = buildkit.DefaultContainerdAddr
+	c.Output.ContainerdNamespace = buildkit.DefaultContainerdNamespace
+	c.Output.LoadTarget = buildkit.LoadTargetDocker
+	c.Output.ResultFormat = result.FormatJSON
+	c.Output.WorkDir = patcher.DefaultFolder
+	c.Timeouts.Total, _ = time.ParseDuration(patcher.DefaultTimeout)
+	c.Timeouts.Retries = utils.DefaultRetries
+	c.Timeouts.RetryBackoff, _ = time.ParseDuration(utils.DefaultBackoff)
+	c.History.Path = history.DefaultPath()
+	c.Server.MaxJobs = server.DefaultMaxJobs
+	c.Server.Retention, _ = time.ParseDuration(server.DefaultRetention)
+
+	return c
+}
+
+// applyUserFlags overrides the settings of the config with the flags set by the user.
+func applyUserFlags(c *config.Config) error {
+	for _, f := range []struct {
+		name  string
+		flag  *string
+		value *string
+	}{
+		{"address", address, &c.Buildkit.Address},
+		{"containerd-address", containerd, &c.Output.ContainerdAddress},
+		{"containerd-namespace", namespace, &c.Output.ContainerdNamespace},
+		{"load-target", loadTarget, &c.Output.LoadTarget},
+		{"output", output, &c.Output.Output},
+		{"provenance-output", provenanceOutput, &c.Output.ProvenanceOutput},
+		{"result-format", resultFormat, &c.Output.ResultFormat},
+		{"result-output", resultOutput, &c.Output.ResultOutput},
+		{"sbom", sbomFormat, &c.Output.SBOM},
+		{"sbom-output", sbomOutput, &c.Output.SBOMOutput},
+		{"sign-key", signKey, &c.Output.SignKey},
+		{"tag", tag, &c.Output.Tag},
+		{"vex-output", vexOutput, &c.Output.VEXOutput},
+		{"work-dir", workDir, &c.Output.WorkDir},
+	} {
+		if isUserFlag(f.name) {
+			*f.value = *f.flag
+		}
+	}
+
+	for _, f := range []struct {
+		name  string
+		flag  *bool
+		value *bool
+	}{
+		{"ignore-errors", ignoreErrors, &c.Packages.IgnoreErrors},
+		{"insecure-registry", insecure, &c.Registry.Insecure},
+		{"keep-artifacts", keepArtifacts, &c.Output.KeepArtifacts},
+		{"plain-http", plainHTTP, &c.Registry.PlainHTTP},
+		{"push", push, &c.Output.Push},
+	} {
+		if isUserFlag(f.name) {
+			*f.value = *f.flag
+		}
+	}
+
+	if isUserFlag("phase-timeout") {
+		timeouts, err := utils.ParsePhaseTimeouts(*phaseTimeouts)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse phase timeouts")
+		}
+		if c.Timeouts.Phases == nil {
+			c.Timeouts.Phases = map[string]time.Duration{}
+		}
+		for k, v := range timeouts {
+			c.Timeouts.Phases[string(k)] = v
+		}
+	}
+
+	if isUserFlag("retries") {
+		c.Timeouts.Retries = *retries
+	}
+
+	if isUserFlag("retry-backoff") {
+		c.Timeouts.RetryBackoff = *retryBackoff
+	}
+
+	if isUserFlag("timeout") {
+		c.Timeouts.Total = *timeout
+	}
+
+	return nil
+}
+
 func initReport(ctx context.Context, cfg *config.Config) (report.Report, error) {
 	c := report.DefaultConfig()
 
@@ -63,18 +309,35 @@ func initReport(ctx context.Context, cfg *config.Config) (report.Report, error)
 }
 
 func initPatcher(ctx context.Context, cfg *config.Config, rp report.Report) (patcher.Patcher, error) {
-	c := patcher.DefaultConfig()
+	c, err := patcher.NewConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to new config")
+	}
 
-	c.Config = *cfg
-	c.IgnoreErrors = *ignoreErrors
 	c.Image = *image
+	c.MultiPlatform = *multiPlatform
+	c.PlatformReports = *platformReports
 	c.Report = rp
-	c.Tag = *tag
-	c.Timeout, _ = time.ParseDuration(*timeout)
 
 	return patcher.New(ctx, c), nil
 }
 
+func initHistory(ctx context.Context, cfg *config.Config) (history.History, error) {
+	c := history.DefaultConfig()
+
+	if !cfg.History.Disabled {
+		c.Path = cfg.History.Path
+	}
+
+	h := history.New(ctx, c)
+
+	if err := h.Init(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to init")
+	}
+
+	return h, nil
+}
+
 func runPatcher(ctx context.Context, pt patcher.Patcher) error {
 	if err := pt.Init(ctx); err != nil {
 		return errors.Wrap(err, "failed to init")
@@ -91,3 +354,177 @@ func runPatcher(ctx context.Context, pt patcher.Patcher) error {
 
 	return nil
 }
+
+func runBatch(ctx context.Context) error {
+	cfg, err := initConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to init config")
+	}
+
+	h, err := initHistory(ctx, cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to init history")
+	}
+
+	c := batch.DefaultConfig()
+
+	c.Config = *cfg
+	c.History = h
+	c.Workers = *batchWorkers
+
+	if *batchMetricsListen != "" {
+		c.Metrics = metrics.New()
+		metricsCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			if err := c.Metrics.Serve(metricsCtx, *batchMetricsListen); err != nil {
+				log.Printf("failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
+	b := batch.New(ctx, c)
+
+	if err := b.Init(ctx); err != nil {
+		return errors.Wrap(err, "failed to init")
+	}
+
+	defer func(b batch.Batch, ctx context.Context) {
+		_ = b.Deinit(ctx)
+	}(b, ctx)
+
+	results, err := b.Run(ctx, *batchFile)
+	if results != nil {
+		_ = batch.WriteSummary(os.Stdout, results)
+	}
+
+	return err
+}
+
+func runConfigView(ctx context.Context) error {
+	cfg, err := initConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to init config")
+	}
+
+	buf, err := config.Marshal(cfg.Redact())
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal config")
+	}
+
+	fmt.Print(string(buf))
+
+	return nil
+}
+
+func runHistory(ctx context.Context) error {
+	cfg, err := initConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to init config")
+	}
+
+	c := history.DefaultConfig()
+
+	c.Path = cfg.History.Path
+
+	h := history.New(ctx, c)
+
+	q := &history.Query{
+		Image:   *historyImage,
+		Package: *historyPackage,
+		CVE:     *historyCVE,
+		Limit:   *historyLimit,
+	}
+
+	records, err := h.Query(ctx, q)
+	if err != nil {
+		return errors.Wrap(err, "failed to query")
+	}
+
+	return history.Write(os.Stdout, *historyFormat, records, q)
+}
+
+func runServe(ctx context.Context) error {
+	cfg, err := initConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to init config")
+	}
+
+	h, err := initHistory(ctx, cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to init history")
+	}
+
+	c := server.DefaultConfig()
+
+	c.Addr = *serveListen
+	c.Config = *cfg
+	c.GRPCAddr = *serveGRPCListen
+	c.History = h
+	c.Metrics = metrics.New()
+	c.QueueSize = *serveQueueSize
+	c.Workers = *serveWorkers
+
+	srv := server.New(ctx, c)
+
+	if err := srv.Init(ctx); err != nil {
+		return errors.Wrap(err, "failed to init")
+	}
+
+	defer func(srv server.Server, ctx context.Context) {
+		_ = srv.Deinit(ctx)
+	}(srv, ctx)
+
+	return srv.Run(ctx)
+}
+
+func runVerify(ctx context.Context) error {
+	pub, err := signature.LoadPublicKey(*verifyKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to load public key")
+	}
+
+	if *verifyLayout != "" {
+		name, dgst, e := signature.VerifyLayout(*verifyLayout, *verifyImage, pub)
+		if e != nil {
+			return errors.Wrap(e, "failed to verify layout")
+		}
+		fmt.Printf("Verified signature of %s@%s\n", name, dgst)
+		return nil
+	}
+
+	if *verifyImage == "" {
+		return errors.New("image is required without oci layout")
+	}
+
+	dgst, err := signature.VerifyRegistry(ctx, buildkit.NewResolver(&buildkit.Registry{Insecure: *verifyInsecure, PlainHTTP: *verifyPlainHTTP}), *verifyImage, pub)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify registry")
+	}
+
+	fmt.Printf("Verified signature of %s@%s\n", *verifyImage, dgst)
+
+	return nil
+}
+
+// ExitCode maps the kind of the error returned by Run to the exit code.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+
+	switch types.GetErrorKind(err) {
+	case types.ErrorKindPartialPatch:
+		return ExitPartialPatch
+	case types.ErrorKindNoUpdates:
+		return ExitNoUpdates
+	case types.ErrorKindBadReport:
+		return ExitBadReport
+	case types.ErrorKindBuildkitUnreachable:
+		return ExitBuildkitUnreachable
+	case types.ErrorKindExport:
+		return ExitExport
+	default:
+		return ExitFailure
+	}
+}
@@ -3,7 +3,10 @@ package cmd
 import (
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/types"
 )
 
 func TestInitConfig(t *testing.T) {
@@ -25,3 +28,25 @@ func TestRunPatcher(t *testing.T) {
 	// TODO: FIXME
 	assert.Equal(t, nil, nil)
 }
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"success", nil, ExitSuccess},
+		{"failure", errors.New("failed"), ExitFailure},
+		{"partial patch", types.NewError(types.ErrorKindPartialPatch, errors.New("failed")), ExitPartialPatch},
+		{"no updates", types.NewError(types.ErrorKindNoUpdates, errors.New("failed")), ExitNoUpdates},
+		{"bad report", errors.Wrap(types.NewError(types.ErrorKindBadReport, errors.New("failed")), "failed to run"), ExitBadReport},
+		{"buildkit unreachable", types.NewError(types.ErrorKindBuildkitUnreachable, errors.New("failed")), ExitBuildkitUnreachable},
+		{"export", types.NewError(types.ErrorKindExport, errors.New("failed")), ExitExport},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, ExitCode(tt.err))
+		})
+	}
+}
@@ -1,6 +1,110 @@
 package config
 
+import (
+	"time"
+)
+
+// Config is the configuration of copatcher, which is resolved from the flags, the COPATCHER_* environment
+// variables, the profile of the config file and the defaults in order of precedence.
 type Config struct {
+	Buildkit Buildkit `yaml:"buildkit"`
+	Registry Registry `yaml:"registry"`
+	Tooling  Tooling  `yaml:"tooling"`
+	Timeouts Timeouts `yaml:"timeouts"`
+	Output   Output   `yaml:"output"`
+	Packages Packages `yaml:"packages"`
+	Server   Server   `yaml:"server"`
+	Webhook  Webhook  `yaml:"webhook"`
+	History  History  `yaml:"history"`
+}
+
+// Buildkit is the buildkit endpoint and its TLS credentials.
+type Buildkit struct {
+	Address string `yaml:"address"`
+	CACert  string `yaml:"caCert"`
+	Cert    string `yaml:"cert"`
+	Key     string `yaml:"key"`
+}
+
+// Registry is the registry settings to resolve, pull and push images.
+type Registry struct {
+	// Insecure skips the TLS verification of the registries, e.g. with a private CA.
+	Insecure bool `yaml:"insecure"`
+	// PlainHTTP allows pulling and pushing over plain HTTP, which is always allowed for localhost.
+	PlainHTTP bool `yaml:"plainHttp"`
+	// Mirrors are the mirror hosts of the registry hosts to resolve image configs, e.g. docker.io: [mirror.gcr.io].
+	Mirrors map[string][]string `yaml:"mirrors"`
+}
+
+// Tooling is the tooling images to fetch the packages with.
+type Tooling struct {
+	// Images override the repository of the tooling image of the os types, e.g. ubuntu: registry.example.com/ubuntu.
+	Images map[string]string `yaml:"images"`
+}
+
+// Timeouts is the timeouts of the patch and its phases, and the retries of the network bound phases.
+type Timeouts struct {
+	Total        time.Duration            `yaml:"total"`
+	Phases       map[string]time.Duration `yaml:"phases"`
+	Retries      int                      `yaml:"retries"`
+	RetryBackoff time.Duration            `yaml:"retryBackoff"`
+}
+
+// Output is the outputs of the patched image and its documents.
+type Output struct {
+	ContainerdAddress   string `yaml:"containerdAddress"`
+	ContainerdNamespace string `yaml:"containerdNamespace"`
+	KeepArtifacts       bool   `yaml:"keepArtifacts"`
+	LoadTarget          string `yaml:"loadTarget"`
+	Output              string `yaml:"output"`
+	ProvenanceOutput    string `yaml:"provenanceOutput"`
+	Push                bool   `yaml:"push"`
+	ResultFormat        string `yaml:"resultFormat"`
+	ResultOutput        string `yaml:"resultOutput"`
+	SBOM                string `yaml:"sbom"`
+	SBOMOutput          string `yaml:"sbomOutput"`
+	SignKey             string `yaml:"signKey"`
+	Tag                 string `yaml:"tag"`
+	VEXOutput           string `yaml:"vexOutput"`
+	WorkDir             string `yaml:"workDir"`
+}
+
+// Packages is the policies of the packages to update.
+type Packages struct {
+	IgnoreErrors bool `yaml:"ignoreErrors"`
+	// Exclude lists the packages not to update, which may be glob patterns, e.g. linux-*.
+	Exclude []string `yaml:"exclude"`
+}
+
+// Server is the authentication of the apis and the retention of the jobs in server mode.
+type Server struct {
+	// Token is the token the requests of the REST and gRPC apis must send in the Authorization header if set.
+	Token string `yaml:"token"`
+	// Retention is the time the finished jobs are kept with their logs and results, which is unlimited if 0.
+	Retention time.Duration `yaml:"retention"`
+	// MaxJobs is the number of the finished jobs kept, which is unlimited if 0.
+	MaxJobs int `yaml:"maxJobs"`
+}
+
+// Webhook is the registry push events which re-patch the pushed images in server mode.
+type Webhook struct {
+	// Images are the glob patterns of the pushed images to patch, e.g. registry.example.com/apps/*.
+	Images []string `yaml:"images"`
+	// Scanner is the scanner (trivy or grype) which scans the pushed image if the report url is empty.
+	Scanner string `yaml:"scanner"`
+	// ReportURL is the template of the url to fetch the report of the pushed image from instead of scanning it,
+	// e.g. https://reports.example.com/{{.Repository}}/{{.Digest}}.json.
+	ReportURL string `yaml:"reportUrl"`
+	// Secret is the token the deliveries must send in the Authorization header if set.
+	Secret string `yaml:"secret"`
+}
+
+// History is the database of the patches of all modes.
+type History struct {
+	// Disabled disables the history, which is recorded by default.
+	Disabled bool `yaml:"disabled"`
+	// Path is the database file, which defaults to copatcher/history.db in the state dir of the user.
+	Path string `yaml:"path"`
 }
 
 var (
@@ -8,6 +112,58 @@ var (
 	Version string
 )
 
+// Redacted replaces the secrets of the config when it is viewed.
+const Redacted = "REDACTED"
+
 func New() *Config {
 	return &Config{}
 }
+
+// Clone returns a deep copy of the config, which can be overlaid without changing the config.
+func (c *Config) Clone() *Config {
+	out := *c
+
+	out.Registry.Mirrors = nil
+	if c.Registry.Mirrors != nil {
+		out.Registry.Mirrors = make(map[string][]string, len(c.Registry.Mirrors))
+		for k, v := range c.Registry.Mirrors {
+			out.Registry.Mirrors[k] = append([]string(nil), v...)
+		}
+	}
+
+	out.Tooling.Images = nil
+	if c.Tooling.Images != nil {
+		out.Tooling.Images = make(map[string]string, len(c.Tooling.Images))
+		for k, v := range c.Tooling.Images {
+			out.Tooling.Images[k] = v
+		}
+	}
+
+	out.Timeouts.Phases = nil
+	if c.Timeouts.Phases != nil {
+		out.Timeouts.Phases = make(map[string]time.Duration, len(c.Timeouts.Phases))
+		for k, v := range c.Timeouts.Phases {
+			out.Timeouts.Phases[k] = v
+		}
+	}
+
+	out.Packages.Exclude = append([]string(nil), c.Packages.Exclude...)
+	out.Webhook.Images = append([]string(nil), c.Webhook.Images...)
+
+	return &out
+}
+
+// Redact returns a copy of the config whose secrets are replaced, e.g. to view it.
+func (c *Config) Redact() *Config {
+	out := c.Clone()
+
+	if out.Server.Token != "" {
+		out.Server.Token = Redacted
+	}
+
+	if out.Webhook.Secret != "" {
+		out.Webhook.Secret = Redacted
+	}
+
+	return out
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -10,3 +11,41 @@ func TestConfig(t *testing.T) {
 	cfg := New()
 	assert.NotEqual(t, nil, cfg)
 }
+
+func TestClone(t *testing.T) {
+	cfg := New()
+	cfg.Registry.Mirrors = map[string][]string{"docker.io": {"mirror.gcr.io"}}
+	cfg.Tooling.Images = map[string]string{"ubuntu": "registry.example.com/ubuntu"}
+	cfg.Timeouts.Phases = map[string]time.Duration{"fetch": time.Minute}
+	cfg.Packages.Exclude = []string{"linux-*"}
+	cfg.Webhook.Images = []string{"registry.example.com/apps/*"}
+
+	out := cfg.Clone()
+	assert.Equal(t, cfg, out)
+
+	out.Registry.Mirrors["docker.io"][0] = "mirror.example.com"
+	out.Tooling.Images["debian"] = "registry.example.com/debian"
+	out.Timeouts.Phases["fetch"] = time.Hour
+	out.Packages.Exclude[0] = "libc6"
+	out.Webhook.Images[0] = "registry.example.com/*"
+
+	assert.Equal(t, []string{"mirror.gcr.io"}, cfg.Registry.Mirrors["docker.io"])
+	assert.Equal(t, 1, len(cfg.Tooling.Images))
+	assert.Equal(t, time.Minute, cfg.Timeouts.Phases["fetch"])
+	assert.Equal(t, []string{"linux-*"}, cfg.Packages.Exclude)
+	assert.Equal(t, []string{"registry.example.com/apps/*"}, cfg.Webhook.Images)
+}
+
+func TestRedact(t *testing.T) {
+	cfg := New()
+	cfg.Server.Token = "token"
+	cfg.Webhook.Secret = "secret"
+
+	out := cfg.Redact()
+	assert.Equal(t, Redacted, out.Server.Token)
+	assert.Equal(t, Redacted, out.Webhook.Secret)
+	assert.Equal(t, "token", cfg.Server.Token)
+	assert.Equal(t, "secret", cfg.Webhook.Secret)
+
+	assert.Equal(t, "", New().Redact().Webhook.Secret)
+}
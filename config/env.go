@@ -0,0 +1,133 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	envListSep  = ","
+	envValueSep = "="
+	// envMapListSep separates the values of a list in a map, e.g. docker.io=mirror.gcr.io;mirror.example.com.
+	envMapListSep = ";"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadEnv overlays the COPATCHER_* environment variables named after the yaml keys of the settings onto the config,
+// e.g. COPATCHER_BUILDKIT_CA_CERT for buildkit.caCert. Lists are comma separated, and maps are comma separated
+// key=value pairs.
+func LoadEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	return loadEnv(reflect.ValueOf(cfg).Elem(), strings.TrimSuffix(EnvPrefix, "_"), lookup)
+}
+
+func loadEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := prefix + "_" + getEnvName(f.Tag.Get("yaml"))
+		if f.Type.Kind() == reflect.Struct {
+			if err := loadEnv(v.Field(i), name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		s, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(v.Field(i), s, envListSep); err != nil {
+			return errors.Wrapf(err, "invalid %s", name)
+		}
+	}
+
+	return nil
+}
+
+// getEnvName converts the yaml key to the upper snake case, e.g. caCert to CA_CERT.
+func getEnvName(key string) string {
+	var b strings.Builder
+
+	for i, r := range key {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+func setValue(v reflect.Value, s, listSep string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse duration")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse bool")
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse int")
+		}
+		v.SetInt(int64(n))
+	case reflect.Slice:
+		out := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, item := range splitList(s, listSep) {
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(e, item, listSep); err != nil {
+				return err
+			}
+			out = reflect.Append(out, e)
+		}
+		v.Set(out)
+	case reflect.Map:
+		out := reflect.MakeMap(v.Type())
+		for _, item := range splitList(s, envListSep) {
+			k, val, ok := strings.Cut(item, envValueSep)
+			if !ok {
+				return errors.Errorf("invalid key=value %s", item)
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(e, val, envMapListSep); err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)), e)
+		}
+		v.Set(out)
+	default:
+		return errors.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+func splitList(s, sep string) []string {
+	var out []string
+
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+
+	return out
+}
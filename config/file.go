@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultFile = "copatcher.yaml"
+	EnvPrefix   = "COPATCHER_"
+	EnvFile     = EnvPrefix + "CONFIG"
+	EnvProfile  = EnvPrefix + "PROFILE"
+)
+
+// File is the config file with the named profiles.
+type File struct {
+	// Default is the profile used if none is specified.
+	Default  string               `yaml:"default"`
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+}
+
+// Load overlays the profile of the config file and then the COPATCHER_* environment variables onto the config.
+// The profile is the default one of the file if empty. The file may be missing only if it is the default file
+// and no profile is specified.
+func Load(cfg *Config, name, profile string, lookup func(string) (string, bool)) error {
+	file := name
+	if file == "" {
+		file = DefaultFile
+	}
+
+	buf, err := os.ReadFile(file)
+	switch {
+	case err == nil:
+		if err := loadProfile(cfg, buf, profile); err != nil {
+			return errors.Wrapf(err, "failed to load profile of %s", file)
+		}
+	case os.IsNotExist(err) && name == "" && profile == "":
+	default:
+		return errors.Wrap(err, "failed to read config file")
+	}
+
+	if err := LoadEnv(cfg, lookup); err != nil {
+		return errors.Wrap(err, "failed to load environment")
+	}
+
+	return nil
+}
+
+func loadProfile(cfg *Config, buf []byte, profile string) error {
+	var f File
+
+	if err := yaml.Unmarshal(buf, &f); err != nil {
+		return errors.Wrap(err, "failed to unmarshal file")
+	}
+
+	if profile == "" {
+		profile = f.Default
+	}
+
+	if profile == "" {
+		return nil
+	}
+
+	node, ok := f.Profiles[profile]
+	if !ok {
+		return errors.Errorf("profile %s not found", profile)
+	}
+
+	// Only the settings of the profile are decoded onto the config
+	if err := node.Decode(cfg); err != nil {
+		return errors.Wrapf(err, "failed to decode profile %s", profile)
+	}
+
+	return nil
+}
+
+// Marshal returns the config in yaml.
+func Marshal(cfg *Config) ([]byte, error) {
+	buf, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal config")
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFile = `default: ci
+profiles:
+  ci:
+    buildkit:
+      address: tcp://buildkitd:1234
+    registry:
+      mirrors:
+        docker.io: [mirror.gcr.io]
+    timeouts:
+      total: 10m
+      phases:
+        fetch: 5m
+    packages:
+      exclude: [linux-*]
+  local:
+    output:
+      loadTarget: podman
+`
+
+func TestLoad(t *testing.T) {
+	name := filepath.Join(t.TempDir(), DefaultFile)
+	assert.Equal(t, nil, os.WriteFile(name, []byte(testFile), 0o600))
+
+	noEnv := func(string) (string, bool) { return "", false }
+
+	tests := []struct {
+		name    string
+		file    string
+		profile string
+		env     map[string]string
+		check   func(t *testing.T, cfg *Config)
+		wantErr bool
+	}{
+		{
+			name: "default profile",
+			file: name,
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, "tcp://buildkitd:1234", cfg.Buildkit.Address)
+				assert.Equal(t, map[string][]string{"docker.io": {"mirror.gcr.io"}}, cfg.Registry.Mirrors)
+				assert.Equal(t, 10*time.Minute, cfg.Timeouts.Total)
+				assert.Equal(t, map[string]time.Duration{"fetch": 5 * time.Minute}, cfg.Timeouts.Phases)
+				assert.Equal(t, []string{"linux-*"}, cfg.Packages.Exclude)
+				assert.Equal(t, "docker", cfg.Output.LoadTarget)
+			},
+		},
+		{
+			name:    "named profile",
+			file:    name,
+			profile: "local",
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, "", cfg.Buildkit.Address)
+				assert.Equal(t, "podman", cfg.Output.LoadTarget)
+			},
+		},
+		{
+			name:    "missing profile",
+			file:    name,
+			profile: "nope",
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			file:    filepath.Join(t.TempDir(), "missing.yaml"),
+			wantErr: true,
+		},
+		{
+			name: "environment",
+			file: name,
+			env: map[string]string{
+				"COPATCHER_BUILDKIT_ADDRESS":   "tcp://other:1234",
+				"COPATCHER_OUTPUT_PUSH":        "true",
+				"COPATCHER_PACKAGES_EXCLUDE":   "a,b",
+				"COPATCHER_REGISTRY_MIRRORS":   "docker.io=m1;m2,ghcr.io=m3",
+				"COPATCHER_TIMEOUTS_PHASES":    "probe=1m",
+				"COPATCHER_TIMEOUTS_RETRIES":   "4",
+				"COPATCHER_OUTPUT_SBOM_OUTPUT": "sbom.json",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, "tcp://other:1234", cfg.Buildkit.Address)
+				assert.Equal(t, true, cfg.Output.Push)
+				assert.Equal(t, []string{"a", "b"}, cfg.Packages.Exclude)
+				assert.Equal(t, map[string][]string{"docker.io": {"m1", "m2"}, "ghcr.io": {"m3"}}, cfg.Registry.Mirrors)
+				assert.Equal(t, time.Minute, cfg.Timeouts.Phases["probe"])
+				assert.Equal(t, 4, cfg.Timeouts.Retries)
+				assert.Equal(t, "sbom.json", cfg.Output.SBOMOutput)
+			},
+		},
+		{
+			name:    "invalid environment",
+			file:    name,
+			env:     map[string]string{"COPATCHER_TIMEOUTS_TOTAL": "invalid"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := New()
+			cfg.Output.LoadTarget = "docker"
+			lookup := noEnv
+			if tt.env != nil {
+				lookup = func(key string) (string, bool) {
+					v, ok := tt.env[key]
+					return v, ok
+				}
+			}
+			err := Load(cfg, tt.file, tt.profile, lookup)
+			if tt.wantErr {
+				assert.NotEqual(t, nil, err)
+				return
+			}
+			assert.Equal(t, nil, err)
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestGetEnvName(t *testing.T) {
+	assert.Equal(t, "ADDRESS", getEnvName("address"))
+	assert.Equal(t, "CA_CERT", getEnvName("caCert"))
+	assert.Equal(t, "RETRY_BACKOFF", getEnvName("retryBackoff"))
+}
+
+func TestMarshal(t *testing.T) {
+	cfg := New()
+	cfg.Buildkit.Address = "tcp://buildkitd:1234"
+
+	buf, err := Marshal(cfg)
+	assert.Equal(t, nil, err)
+	assert.Contains(t, string(buf), "address: tcp://buildkitd:1234")
+}
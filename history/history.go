@@ -0,0 +1,323 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/exp/slices"
+
+	"github.com/craftslab/copatcher/patcher"
+	"github.com/craftslab/copatcher/result"
+)
+
+// Modes of the patches.
+const (
+	ModeBatch = "batch"
+	ModePatch = "patch"
+	ModeServe = "serve"
+)
+
+const (
+	// EnvStateHome is the state dir of the user, which defaults to ~/.local/state.
+	EnvStateHome = "XDG_STATE_HOME"
+)
+
+const (
+	// openTimeout is the time to wait for the database locked by another process, which holds it only for a write.
+	openTimeout = 10 * time.Second
+
+	dbPerm = 0o600
+
+	defaultFile   = "history.db"
+	defaultFolder = "copatcher"
+)
+
+var recordsBucket = []byte("records")
+
+type History interface {
+	Init(context.Context) error
+	Deinit(context.Context) error
+	Add(context.Context, *Record) error
+	Query(context.Context, *Query) ([]Record, error)
+}
+
+type Config struct {
+	// Path is the database file, which disables the history if empty.
+	Path string
+}
+
+// Record is a patch with its inputs, and the result of each package and the digest of the patched image if exported.
+type Record struct {
+	ID              string            `json:"id"`
+	Mode            string            `json:"mode"`
+	Image           string            `json:"image"`
+	Tag             string            `json:"tag,omitempty"`
+	MultiPlatform   bool              `json:"multiPlatform"`
+	Report          string            `json:"report,omitempty"`
+	PlatformReports map[string]string `json:"platformReports,omitempty"`
+	Status          string            `json:"status"`
+	Error           string            `json:"error,omitempty"`
+	Started         time.Time         `json:"started"`
+	Finished        time.Time         `json:"finished"`
+	Result          *result.Document  `json:"result,omitempty"`
+}
+
+// Query filters the records, which are returned from the newest one.
+type Query struct {
+	// Image is the image, which matches all its tags and digests if it is a name only, e.g. nginx.
+	Image string
+	// Package is the name of a package in the results, which may be a glob pattern, e.g. libssl*.
+	Package string
+	// CVE is the id of a vulnerability of a package in the results.
+	CVE string
+	// Limit is the number of records returned, which is unlimited if 0.
+	Limit int
+}
+
+// Recorder records a patch with the result reported by the patch.
+type Recorder struct {
+	mu     sync.Mutex
+	record Record
+}
+
+type history struct {
+	cfg *Config
+	// mu serializes the opens of the database, which is locked by each open even in the same process.
+	mu sync.Mutex
+}
+
+func New(_ context.Context, cfg *Config) History {
+	return &history{
+		cfg: cfg,
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{}
+}
+
+// DefaultPath returns the database file in the state dir of the user, e.g. ~/.local/state/copatcher/history.db,
+// which is empty if the home dir is unknown.
+func DefaultPath() string {
+	if dir := os.Getenv(EnvStateHome); dir != "" {
+		return filepath.Join(dir, defaultFolder, defaultFile)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".local", "state", defaultFolder, defaultFile)
+}
+
+// Init creates the database with its bucket if the history is enabled.
+func (h *history) Init(_ context.Context) error {
+	if h.cfg.Path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.cfg.Path), os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create folder")
+	}
+
+	return h.update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(recordsBucket)
+		return err
+	})
+}
+
+func (h *history) Deinit(_ context.Context) error {
+	return nil
+}
+
+// Add stores the record, which is skipped if the history is disabled.
+func (h *history) Add(_ context.Context, r *Record) error {
+	if h.cfg.Path == "" {
+		return nil
+	}
+
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal record")
+	}
+
+	return h.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(recordsBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(getKey(r), buf)
+	})
+}
+
+// Query returns the records matching the query from the newest one.
+func (h *history) Query(_ context.Context, q *Query) ([]Record, error) {
+	if h.cfg.Path == "" {
+		return nil, errors.New("history is disabled")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// The database is created by the first patch recorded
+	if _, err := os.Stat(h.cfg.Path); os.IsNotExist(err) {
+		return []Record{}, nil
+	}
+
+	db, err := bolt.Open(h.cfg.Path, dbPerm, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+
+	defer func(db *bolt.DB) {
+		_ = db.Close()
+	}(db)
+
+	out := []Record{}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(recordsBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return errors.Wrapf(err, "failed to unmarshal record %s", k)
+			}
+			if !q.Match(&r) {
+				continue
+			}
+			out = append(out, r)
+			if q.Limit > 0 && len(out) >= q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query records")
+	}
+
+	return out, nil
+}
+
+// WithRecorder starts the record of a patch, and returns the context whose patch reports its result to the recorder.
+func WithRecorder(ctx context.Context, r *Record) (context.Context, *Recorder) {
+	rec := &Recorder{record: *r}
+	rec.record.Started = time.Now()
+
+	return patcher.WithResultHandler(ctx, func(doc *result.Document) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.record.Result = doc
+	}), rec
+}
+
+// Finish returns the record of the patch finished with the status and the error.
+func (r *Recorder) Finish(status string, err error) *Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := r.record
+	out.Status = status
+	out.Finished = time.Now()
+
+	if err != nil {
+		out.Error = err.Error()
+	}
+
+	return &out
+}
+
+func (h *history) update(fn func(*bolt.Tx) error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	db, err := bolt.Open(h.cfg.Path, dbPerm, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return errors.Wrap(err, "failed to open database")
+	}
+
+	if err := db.Update(fn); err != nil {
+		_ = db.Close()
+		return errors.Wrap(err, "failed to update database")
+	}
+
+	return db.Close()
+}
+
+// getKey orders the records by their finish time.
+func getKey(r *Record) []byte {
+	return []byte(fmt.Sprintf("%020d/%s", r.Finished.UnixNano(), r.ID))
+}
+
+// Match reports whether the record matches the image, and has a package matching the package and the cve.
+func (q *Query) Match(r *Record) bool {
+	if q.Image != "" && !matchImage(q.Image, r.Image) {
+		return false
+	}
+
+	if q.Package == "" && q.CVE == "" {
+		return true
+	}
+
+	if r.Result == nil {
+		return false
+	}
+
+	for i := range r.Result.Platforms {
+		for j := range r.Result.Platforms[i].Packages {
+			if q.MatchPackage(&r.Result.Platforms[i].Packages[j]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// MatchPackage reports whether the package matches the package and the cve.
+func (q *Query) MatchPackage(p *result.Package) bool {
+	if q.Package != "" {
+		if ok, _ := path.Match(q.Package, p.Name); !ok {
+			return false
+		}
+	}
+
+	if q.CVE != "" && !slices.Contains(p.Vulnerabilities, q.CVE) {
+		return false
+	}
+
+	return true
+}
+
+// matchImage compares the normalized names of the images if the pattern is a name only, or their references.
+func matchImage(pattern, image string) bool {
+	want, err := reference.ParseNormalizedNamed(pattern)
+	if err != nil {
+		return pattern == image
+	}
+
+	got, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+
+	if reference.IsNameOnly(want) {
+		return want.Name() == got.Name()
+	}
+
+	return want.String() == reference.TagNameOnly(got).String()
+}
@@ -0,0 +1,155 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/result"
+)
+
+func newTestRecord(id, image string, finished time.Time, pkgs ...result.Package) *Record {
+	doc := &result.Document{
+		Image:        image,
+		PatchedImage: image + "-patched",
+		Digest:       "sha256:1234",
+		Platforms:    []result.Platform{{Platform: "linux/amd64", OS: "ubuntu", Packages: pkgs}},
+	}
+	doc.Update()
+
+	return &Record{
+		ID:       id,
+		Mode:     ModeBatch,
+		Image:    image,
+		Status:   "patched",
+		Started:  finished.Add(-time.Minute),
+		Finished: finished,
+		Result:   doc,
+	}
+}
+
+func newTestHistory(t *testing.T) History {
+	h := New(context.Background(), &Config{Path: filepath.Join(t.TempDir(), "history", "history.db")})
+	assert.Equal(t, nil, h.Init(context.Background()))
+
+	finished := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	for _, r := range []*Record{
+		newTestRecord("1", "nginx:1.25", finished,
+			result.Package{Name: "libssl3", RequestedVersion: "3.0.11-1", InstalledVersion: "3.0.11-1", Status: result.StatusPatched,
+				Vulnerabilities: []string{"CVE-2023-0286", "CVE-2023-0464"}},
+			result.Package{Name: "zlib1g", RequestedVersion: "1.2.13", Status: result.StatusFailed}),
+		newTestRecord("2", "docker.io/library/nginx:1.24", finished.Add(time.Hour),
+			result.Package{Name: "libssl3", RequestedVersion: "3.0.11-1", InstalledVersion: "3.0.11-1", Status: result.StatusPatched,
+				Vulnerabilities: []string{"CVE-2023-0286"}}),
+		newTestRecord("3", "ubuntu:22.04", finished.Add(2*time.Hour),
+			result.Package{Name: "curl", RequestedVersion: "7.81.0-1", InstalledVersion: "7.81.0-1", Status: result.StatusPatched}),
+		{ID: "4", Mode: ModePatch, Image: "nginx:1.25", Status: "failed", Error: "failed to patch", Finished: finished.Add(3 * time.Hour)},
+	} {
+		assert.Equal(t, nil, h.Add(context.Background(), r))
+	}
+
+	return h
+}
+
+func getIDs(records []Record) []string {
+	out := []string{}
+	for i := range records {
+		out = append(out, records[i].ID)
+	}
+
+	return out
+}
+
+func TestQuery(t *testing.T) {
+	h := newTestHistory(t)
+
+	tests := []struct {
+		name  string
+		query *Query
+		ids   []string
+	}{
+		{"all", &Query{}, []string{"4", "3", "2", "1"}},
+		{"limit", &Query{Limit: 2}, []string{"4", "3"}},
+		{"image name", &Query{Image: "nginx"}, []string{"4", "2", "1"}},
+		{"image tag", &Query{Image: "docker.io/library/nginx:1.25"}, []string{"4", "1"}},
+		{"package", &Query{Image: "nginx", Package: "libssl*"}, []string{"2", "1"}},
+		{"cve", &Query{CVE: "CVE-2023-0464"}, []string{"1"}},
+		{"package and cve", &Query{Package: "zlib1g", CVE: "CVE-2023-0464"}, []string{}},
+		{"no match", &Query{Image: "redis"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := h.Query(context.Background(), tt.query)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, tt.ids, getIDs(records))
+		})
+	}
+
+	records, err := h.Query(context.Background(), &Query{Image: "ubuntu"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "sha256:1234", records[0].Result.Digest)
+	assert.Equal(t, "curl", records[0].Result.Platforms[0].Packages[0].Name)
+
+	_, err = New(context.Background(), DefaultConfig()).Query(context.Background(), &Query{})
+	assert.NotEqual(t, nil, err)
+
+	// The database of the default path is missing until a patch is recorded
+	records, err = New(context.Background(), &Config{Path: filepath.Join(t.TempDir(), "missing.db")}).Query(context.Background(), &Query{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(records))
+}
+
+func TestDefaultPath(t *testing.T) {
+	t.Setenv(EnvStateHome, "/var/lib/state")
+	assert.Equal(t, "/var/lib/state/copatcher/history.db", DefaultPath())
+
+	t.Setenv(EnvStateHome, "")
+	t.Setenv("HOME", "/home/user")
+	assert.Equal(t, "/home/user/.local/state/copatcher/history.db", DefaultPath())
+}
+
+func TestRecorder(t *testing.T) {
+	_, rec := WithRecorder(context.Background(), &Record{ID: "1", Mode: ModeServe, Image: "nginx:1.25"})
+
+	out := rec.Finish("failed", errors.New("failed to patch"))
+	assert.Equal(t, "failed to patch", out.Error)
+	assert.Nil(t, out.Result)
+	assert.Equal(t, false, out.Started.IsZero())
+	assert.Equal(t, false, out.Finished.Before(out.Started))
+
+	out = rec.Finish("patched", nil)
+	assert.Equal(t, "patched", out.Status)
+	assert.Empty(t, out.Error)
+}
+
+func TestWrite(t *testing.T) {
+	h := newTestHistory(t)
+
+	records, err := h.Query(context.Background(), &Query{Image: "nginx"})
+	assert.Equal(t, nil, err)
+
+	var buf bytes.Buffer
+
+	assert.Equal(t, nil, Write(&buf, FormatTable, records, &Query{}))
+	assert.Contains(t, buf.String(), "2024-03-01T08:00:00Z  batch  nginx:1.25")
+	assert.Contains(t, buf.String(), "sha256:1234  patched  1/2")
+
+	buf.Reset()
+	assert.Equal(t, nil, Write(&buf, FormatTable, records, &Query{CVE: "CVE-2023-0286"}))
+	assert.Contains(t, buf.String(), "linux/amd64  libssl3  3.0.11-1")
+	assert.Contains(t, buf.String(), "CVE-2023-0286,CVE-2023-0464")
+	assert.NotContains(t, buf.String(), "zlib1g")
+
+	buf.Reset()
+	assert.Equal(t, nil, Write(&buf, FormatJSON, records, &Query{}))
+	assert.Contains(t, buf.String(), `"vulnerabilities": [`)
+
+	assert.NotEqual(t, nil, Write(&buf, "invalid", records, &Query{}))
+}
@@ -0,0 +1,96 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Formats supported by Write.
+const (
+	FormatJSON  = "json"
+	FormatTable = "table"
+)
+
+// Write writes the records in the format. The table lists the packages of the records matching the package or the
+// cve of the query if any, or the records otherwise.
+func Write(w io.Writer, format string, records []Record, q *Query) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(records); err != nil {
+			return errors.Wrap(err, "failed to encode records")
+		}
+		return nil
+	case FormatTable:
+		if q.Package != "" || q.CVE != "" {
+			return writePackages(w, records, q)
+		}
+		return writeRecords(w, records)
+	default:
+		return errors.Errorf("unsupported history format %s", format)
+	}
+}
+
+func writeRecords(w io.Writer, records []Record) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(tw, "FINISHED\tMODE\tIMAGE\tPATCHED IMAGE\tDIGEST\tSTATUS\tPATCHED PACKAGES")
+
+	for i := range records {
+		r := &records[i]
+		patched, dgst, pkgs := "", "", ""
+		if r.Result != nil {
+			patched, dgst = r.Result.PatchedImage, r.Result.Digest
+			pkgs = fmt.Sprintf("%d/%d", r.Result.Summary.Patched, r.Result.Summary.Total())
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", formatTime(r.Finished), r.Mode, r.Image, patched, dgst,
+			r.Status, pkgs)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "failed to flush records")
+	}
+
+	return nil
+}
+
+func writePackages(w io.Writer, records []Record, q *Query) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(tw, "FINISHED\tIMAGE\tPATCHED IMAGE\tPLATFORM\tPACKAGE\tREQUESTED\tINSTALLED\tSTATUS\tVULNERABILITIES")
+
+	for i := range records {
+		r := &records[i]
+		if r.Result == nil {
+			continue
+		}
+		for _, p := range r.Result.Platforms {
+			for j := range p.Packages {
+				pkg := &p.Packages[j]
+				if !q.MatchPackage(pkg) {
+					continue
+				}
+				_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", formatTime(r.Finished), r.Image,
+					r.Result.PatchedImage, p.Platform, pkg.Name, pkg.RequestedVersion, pkg.InstalledVersion, pkg.Status,
+					strings.Join(pkg.Vulnerabilities, ","))
+			}
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "failed to flush packages")
+	}
+
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
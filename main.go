@@ -4,15 +4,27 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/craftslab/copatcher/cmd"
 )
 
 func main() {
-	if err := cmd.Run(context.Background()); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	// Restore the default behavior after the first signal, so that a second one terminates immediately
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := cmd.Run(ctx); err != nil {
 		fmt.Println(err.Error())
-		os.Exit(1)
+		stop()
+		os.Exit(cmd.ExitCode(err))
 	}
 
-	os.Exit(0)
+	stop()
+	os.Exit(cmd.ExitSuccess)
 }
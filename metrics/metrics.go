@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/craftslab/copatcher/patcher"
+	"github.com/craftslab/copatcher/result"
+	"github.com/craftslab/copatcher/types"
+	"github.com/craftslab/copatcher/utils"
+)
+
+const (
+	namespace = "copatcher"
+
+	// shutdownTimeout is the time to wait for the scrapes in flight to finish after the server is stopped.
+	shutdownTimeout = 5 * time.Second
+
+	// unknownEcosystem labels the packages of the platforms without a package type.
+	unknownEcosystem = "unknown"
+)
+
+// Metrics are the Prometheus metrics of the jobs of the server and batch modes, which are all no-ops on a nil
+// Metrics.
+type Metrics struct {
+	registry         *prometheus.Registry
+	jobs             *prometheus.CounterVec
+	phases           *prometheus.HistogramVec
+	packages         *prometheus.CounterVec
+	buildkitFailures prometheus.Counter
+	queueDepth       prometheus.Gauge
+}
+
+// New returns the metrics registered in their own registry with the go and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_total",
+			Help:      "Number of the finished jobs by mode and outcome.",
+		}, []string{"mode", "status"}),
+		phases: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "phase_duration_seconds",
+			Help:      "Duration of the attempts of the phases of the patches.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+		}, []string{"phase"}),
+		packages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "packages_patched_total",
+			Help:      "Number of the packages patched by ecosystem.",
+		}, []string{"ecosystem"}),
+		buildkitFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "buildkit_connection_failures_total",
+			Help:      "Number of the failures to connect to BuildKit.",
+		}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queue_depth",
+			Help:      "Number of the jobs waiting to be patched.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.jobs,
+		m.phases,
+		m.packages,
+		m.buildkitFailures,
+		m.queueDepth,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Serve serves the metrics on /metrics of the address until the context is canceled.
+func (m *Metrics) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.Handler())
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ch := make(chan error, 1)
+	go func() {
+		log.Printf("serve metrics on %s", addr)
+		ch <- srv.ListenAndServe()
+	}()
+
+	var err error
+
+	select {
+	case err = <-ch:
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	_ = srv.Shutdown(shutdownCtx)
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "failed to serve")
+	}
+
+	return nil
+}
+
+// WithContext returns the context whose patch reports the durations of its phases and its patched packages to the
+// metrics.
+func (m *Metrics) WithContext(ctx context.Context) context.Context {
+	if m == nil {
+		return ctx
+	}
+
+	ctx = utils.WithPhaseHandler(ctx, func(e utils.PhaseEvent) {
+		if e.Done {
+			m.phases.WithLabelValues(string(e.Phase)).Observe(e.Duration.Seconds())
+		}
+	})
+
+	return patcher.WithResultHandler(ctx, m.observeResult)
+}
+
+// ObserveJob counts the job of the mode finished with the status and the error.
+func (m *Metrics) ObserveJob(mode, status string, err error) {
+	if m == nil {
+		return
+	}
+
+	m.jobs.WithLabelValues(mode, status).Inc()
+	m.ObserveConnect(err)
+}
+
+// ObserveConnect counts the error if it is a failure to connect to buildkit.
+func (m *Metrics) ObserveConnect(err error) {
+	if m == nil || types.GetErrorKind(err) != types.ErrorKindBuildkitUnreachable {
+		return
+	}
+
+	m.buildkitFailures.Inc()
+}
+
+// SetQueueDepth sets the number of the jobs waiting to be patched.
+func (m *Metrics) SetQueueDepth(n int) {
+	if m == nil {
+		return
+	}
+
+	m.queueDepth.Set(float64(n))
+}
+
+func (m *Metrics) observeResult(doc *result.Document) {
+	for i := range doc.Platforms {
+		ecosystem := doc.Platforms[i].PackageType
+		if ecosystem == "" {
+			ecosystem = unknownEcosystem
+		}
+		for _, p := range doc.Platforms[i].Packages {
+			if p.Status == result.StatusPatched {
+				m.packages.WithLabelValues(ecosystem).Inc()
+			}
+		}
+	}
+}
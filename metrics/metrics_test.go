@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/result"
+	"github.com/craftslab/copatcher/types"
+	"github.com/craftslab/copatcher/utils"
+)
+
+func TestObserveJob(t *testing.T) {
+	m := New()
+
+	m.ObserveJob("serve", "patched", nil)
+	m.ObserveJob("serve", "failed", errors.Wrap(types.NewError(types.ErrorKindBuildkitUnreachable, errors.New("failed")), "failed to patch"))
+	m.ObserveJob("batch", "failed", errors.New("failed"))
+	m.ObserveConnect(types.NewError(types.ErrorKindBuildkitUnreachable, errors.New("failed")))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.jobs.WithLabelValues("serve", "patched")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.jobs.WithLabelValues("serve", "failed")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.jobs.WithLabelValues("batch", "failed")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.buildkitFailures))
+
+	m.SetQueueDepth(3)
+	assert.Equal(t, float64(3), testutil.ToFloat64(m.queueDepth))
+}
+
+func TestWithContext(t *testing.T) {
+	m := New()
+
+	ctx := m.WithContext(context.Background())
+
+	phases := &utils.Phases{}
+	assert.Equal(t, nil, phases.Run(ctx, utils.PhaseProbe, false, func(context.Context) error {
+		return nil
+	}))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.phases, "copatcher_phase_duration_seconds"))
+
+	doc := &result.Document{
+		Platforms: []result.Platform{
+			{PackageType: "deb", Packages: []result.Package{
+				{Name: "openssl", Status: result.StatusPatched},
+				{Name: "curl", Status: result.StatusPatched},
+				{Name: "zlib1g", Status: result.StatusFailed},
+			}},
+			{Packages: []result.Package{{Name: "openssl", Status: result.StatusPatched}}},
+		},
+	}
+	m.observeResult(doc)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.packages.WithLabelValues("deb")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.packages.WithLabelValues(unknownEcosystem)))
+}
+
+func TestNil(t *testing.T) {
+	var m *Metrics
+
+	ctx := context.Background()
+	assert.Equal(t, ctx, m.WithContext(ctx))
+
+	m.ObserveJob("serve", "failed", errors.New("failed"))
+	m.SetQueueDepth(1)
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.ObserveJob("serve", "patched", nil)
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL)
+	assert.Equal(t, nil, err)
+
+	defer func() {
+		_ = rsp.Body.Close()
+	}()
+
+	buf := new(strings.Builder)
+	_, _ = io.Copy(buf, rsp.Body)
+
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Contains(t, buf.String(), `copatcher_jobs_total{mode="serve",status="patched"} 1`)
+	assert.Contains(t, buf.String(), "copatcher_queue_depth 0")
+	assert.Contains(t, buf.String(), "go_goroutines")
+}
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.Equal(t, nil, New().Serve(ctx, "127.0.0.1:0"))
+	assert.NotEqual(t, nil, New().Serve(context.Background(), "invalid"))
+}
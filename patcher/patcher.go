@@ -2,12 +2,22 @@ package patcher
 
 import (
 	"context"
+	"crypto"
 	"fmt"
+	"log"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/containerd/containerd/platforms"
 	"github.com/distribution/reference"
+	"github.com/hashicorp/go-multierror"
+	slsa02 "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
 	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"golang.org/x/exp/slices"
 
@@ -15,14 +25,28 @@ import (
 	"github.com/craftslab/copatcher/config"
 	"github.com/craftslab/copatcher/pkgmgr"
 	"github.com/craftslab/copatcher/report"
+	"github.com/craftslab/copatcher/result"
+	"github.com/craftslab/copatcher/sbom"
+	"github.com/craftslab/copatcher/signature"
+	"github.com/craftslab/copatcher/types"
 	"github.com/craftslab/copatcher/utils"
 )
 
 const (
-	DefaultFolder  = "/tmp/copatcher"
-	DefaultPerm    = 0o744
-	DefaultTag     = "patched"
-	DefaultTimeout = "5m"
+	// cancelTimeout is the time to wait for the patch to stop after it is canceled.
+	cancelTimeout = 10 * time.Second
+	// digestTagLen is the length of the digest kept in the tag derived from it.
+	digestTagLen = 12
+	// runFolderPattern is the pattern of the unique working folder of a run in the work dir.
+	runFolderPattern = "run-*"
+)
+
+const (
+	DefaultFilePerm = 0o644
+	DefaultFolder   = "/tmp/copatcher"
+	DefaultPerm     = 0o744
+	DefaultTag      = "patched"
+	DefaultTimeout  = "5m"
 )
 
 type Patcher interface {
@@ -32,12 +56,50 @@ type Patcher interface {
 }
 
 type Config struct {
-	Config       config.Config
-	IgnoreErrors bool
-	Image        string
-	Report       report.Report
-	Tag          string
-	Timeout      time.Duration
+	Address string
+	// Client is the buildkit client shared by the patchers, e.g. of a batch, which is used instead of
+	// connecting to Address and is not closed by the patcher.
+	Client        *client.Client
+	Config        config.Config
+	IgnoreErrors  bool
+	KeepArtifacts bool
+	Image         string
+	Insecure      bool
+	Loader        buildkit.LoaderOpts
+	// Logger logs the patch, which is the standard logger if nil, e.g. the log of a job.
+	Logger          *log.Logger
+	MultiPlatform   bool
+	Output          string
+	PlainHTTP       bool
+	Phases          utils.Phases
+	PlatformReports map[string]string
+	// ProvenanceOutput is the file to write the provenance statement of an image exported with the docker exporter
+	// to, which cannot attach it.
+	ProvenanceOutput string
+	Push             bool
+	Report           report.Report
+	ResultFormat     string
+	ResultOutput     string
+	SBOM             string
+	SBOMOutput       string
+	SignKey          string
+	Tag              string
+	Timeout          time.Duration
+	VEXOutput        string
+	WorkDir          string
+}
+
+// patchResult is the patched image of a platform.
+type patchResult struct {
+	manifest types.UpdateManifest
+	image    buildkit.PlatformImage
+	errPkgs  []string
+	// err is the error of the platform whose original image is kept.
+	err          error
+	pkgmgr       pkgmgr.PackageManager
+	report       string
+	reportDigest digest.Digest
+	sourceDigest digest.Digest
 }
 
 type patcher struct {
@@ -46,8 +108,24 @@ type patcher struct {
 }
 
 func New(_ context.Context, cfg *Config) Patcher {
+	// The phases and the loader log to the logger of the patch unless set
+	if cfg.Phases.Logger == nil {
+		cfg.Phases.Logger = cfg.Logger
+	}
+
+	if cfg.Loader.Logger == nil {
+		cfg.Loader.Logger = cfg.Logger
+	}
+
 	return &patcher{
 		cfg: cfg,
+		opts: buildkit.Opts{
+			Addr:       cfg.Address,
+			CACertPath: cfg.Config.Buildkit.CACert,
+			CertPath:   cfg.Config.Buildkit.Cert,
+			KeyPath:    cfg.Config.Buildkit.Key,
+			Logger:     cfg.Logger,
+		},
 	}
 }
 
@@ -55,6 +133,72 @@ func DefaultConfig() *Config {
 	return &Config{}
 }
 
+// NewConfig returns the patcher config of the settings of cfg, whose image and report are set by the caller.
+func NewConfig(cfg *config.Config) (*Config, error) {
+	if err := ValidateConfig(cfg); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+
+	c := DefaultConfig()
+
+	c.Address = cfg.Buildkit.Address
+	c.Config = *cfg
+	c.IgnoreErrors = cfg.Packages.IgnoreErrors
+	c.Insecure = cfg.Registry.Insecure
+	c.KeepArtifacts = cfg.Output.KeepArtifacts
+	c.Loader = buildkit.LoaderOpts{
+		Target:              cfg.Output.LoadTarget,
+		ContainerdAddr:      cfg.Output.ContainerdAddress,
+		ContainerdNamespace: cfg.Output.ContainerdNamespace,
+	}
+	c.Output = cfg.Output.Output
+	c.Phases = utils.Phases{Timeouts: map[utils.Phase]time.Duration{}, Retries: cfg.Timeouts.Retries, Backoff: cfg.Timeouts.RetryBackoff}
+	for k, v := range cfg.Timeouts.Phases {
+		phase, err := utils.ParsePhase(k)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse phase")
+		}
+		c.Phases.Timeouts[phase] = v
+	}
+	c.PlainHTTP = cfg.Registry.PlainHTTP
+	c.ProvenanceOutput = cfg.Output.ProvenanceOutput
+	c.Push = cfg.Output.Push
+	c.ResultFormat = cfg.Output.ResultFormat
+	c.ResultOutput = cfg.Output.ResultOutput
+	c.SBOM = cfg.Output.SBOM
+	c.SBOMOutput = cfg.Output.SBOMOutput
+	c.SignKey = cfg.Output.SignKey
+	c.Tag = cfg.Output.Tag
+	c.Timeout = cfg.Timeouts.Total
+	c.VEXOutput = cfg.Output.VEXOutput
+	c.WorkDir = cfg.Output.WorkDir
+
+	return c, nil
+}
+
+// ValidateConfig checks the settings of the config which are not validated by the flags.
+func ValidateConfig(cfg *config.Config) error {
+	if cfg.Output.SBOM != "" {
+		if _, err := sbom.GetPredicateType(cfg.Output.SBOM); err != nil {
+			return err
+		}
+	}
+
+	switch cfg.Output.ResultFormat {
+	case result.FormatJSON, result.FormatMarkdown, result.FormatJUnit:
+	default:
+		return errors.Errorf("unsupported result format %s", cfg.Output.ResultFormat)
+	}
+
+	for k := range cfg.Timeouts.Phases {
+		if _, err := utils.ParsePhase(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (p *patcher) Init(ctx context.Context) error {
 	if err := p.cfg.Report.Init(ctx); err != nil {
 		return errors.Wrap(err, "failed to init report")
@@ -69,97 +213,517 @@ func (p *patcher) Deinit(ctx context.Context) error {
 	return nil
 }
 
+// Run patches the image until it is done, timed out or canceled by the context. The working folder of the run
+// is unique in the work dir and removed after the patch returns unless the artifacts are kept, which is waited for
+// a bounded time to cancel its solves and close its buildkit session. The folder of a patch not stopped in time is
+// kept until it stops, not to be removed under its solves.
 func (p *patcher) Run(ctx context.Context, name string) error {
+	workDir := p.cfg.WorkDir
+	if workDir == "" {
+		workDir = DefaultFolder
+	}
+
+	// The work dir is shared by concurrent runs, so it is neither required to be new nor removed
+	if err := os.MkdirAll(workDir, DefaultPerm); err != nil {
+		return errors.Wrap(err, "failed to create work dir")
+	}
+
+	folder, err := os.MkdirTemp(workDir, runFolderPattern)
+	if err != nil {
+		return errors.Wrap(err, "failed to create working folder")
+	}
+
 	timeoutCtx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
 	defer cancel()
 
-	ch := make(chan error)
+	// The channel is buffered not to leak the goroutine if it is not waited for
+	ch := make(chan error, 1)
+	stopped := make(chan struct{})
 	go func() {
-		ch <- p.patch(timeoutCtx, name)
+		defer close(stopped)
+		ch <- p.patch(timeoutCtx, name, folder)
 	}()
 
+	defer p.cleanup(folder, stopped)
+
 	select {
 	case err := <-ch:
 		return errors.Wrap(err, "failed to patch")
 	case <-timeoutCtx.Done():
-		<-time.After(1 * time.Second)
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(cancelTimeout):
+		p.logger().Printf("patch not stopped in %s after canceled", cancelTimeout)
+	}
+
+	if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
 		return errors.New("patch exceeded timeout")
 	}
+
+	return errors.Wrap(timeoutCtx.Err(), "patch canceled")
+}
+
+// cleanup removes the working folder unless the artifacts are kept. The removal is deferred until the patch is
+// stopped if it still runs, e.g. after it is canceled.
+func (p *patcher) cleanup(folder string, stopped <-chan struct{}) {
+	if p.cfg.KeepArtifacts {
+		p.logger().Printf("keep artifacts in %s", folder)
+		return
+	}
+
+	select {
+	case <-stopped:
+		_ = os.RemoveAll(folder)
+	default:
+		p.logger().Printf("keep working folder %s until patch stops", folder)
+		go func() {
+			<-stopped
+			_ = os.RemoveAll(folder)
+		}()
+	}
 }
 
 // nolint: funlen,gocyclo
-func (p *patcher) patch(ctx context.Context, name string) error {
-	imageName, err := reference.ParseNamed(p.cfg.Image)
+func (p *patcher) patch(ctx context.Context, name, folder string) error {
+	imageName, err := reference.ParseNormalizedNamed(p.cfg.Image)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse named")
+		return errors.Wrap(err, "failed to parse normalized named")
 	}
 
 	if reference.IsNameOnly(imageName) {
 		imageName = reference.TagNameOnly(imageName)
 	}
 
-	taggedName, ok := imageName.(reference.Tagged)
-	if !ok {
-		return errors.New("invalid tagged name")
+	// Resolve the image by its canonical reference, which keeps the digest if any
+	p.cfg.Image = imageName.String()
+
+	if p.cfg.Push && p.cfg.Output != "" {
+		return errors.New("push and output are mutually exclusive")
 	}
 
-	tag := taggedName.Tag()
-	if p.cfg.Tag == "" {
-		if tag == "" {
-			p.cfg.Tag = DefaultTag
-		} else {
-			p.cfg.Tag = fmt.Sprintf("%s-%s", tag, DefaultTag)
+	var output *buildkit.Output
+	if p.cfg.Output != "" {
+		if output, err = buildkit.ParseOutput(p.cfg.Output); err != nil {
+			return errors.Wrap(err, "failed to parse output")
 		}
 	}
 
+	loader, err := buildkit.NewLoader(p.cfg.Loader)
+	if err != nil {
+		return errors.Wrap(err, "failed to create loader")
+	}
+
+	signer, err := p.getSigner(output)
+	if err != nil {
+		return errors.Wrap(err, "failed to get signer")
+	}
+
+	if err := p.validateIndexOutput(output); err != nil {
+		return errors.Wrap(err, "invalid output")
+	}
+
+	if p.cfg.Tag == "" {
+		p.cfg.Tag = getDefaultTag(imageName)
+	}
+
 	patchedImageName := fmt.Sprintf("%s:%s", imageName.Name(), p.cfg.Tag)
 
-	if isNew, e := utils.EnsurePath(DefaultFolder, DefaultPerm); e != nil {
-		return errors.Wrap(e, "failed to create working folder")
-	} else if isNew {
-		defer func(p string) {
-			_ = os.RemoveAll(p)
-		}(DefaultFolder)
+	started := time.Now()
+
+	_client, endpoint := p.cfg.Client, p.cfg.Address
+	if _client == nil {
+		if _client, endpoint, err = buildkit.Connect(ctx, p.opts); err != nil {
+			return errors.Wrap(err, "failed to create new client")
+		}
+		defer func(c *client.Client) {
+			_ = c.Close()
+		}(_client)
 	}
 
-	manifest, err := p.cfg.Report.Run(ctx, name)
+	var results []patchResult
+
+	if p.cfg.MultiPlatform {
+		results, err = p.patchPlatforms(ctx, _client, name, folder)
+		if err != nil {
+			return p.handleFailedResult(ctx, results, patchedImageName, errors.Wrap(err, "failed to patch platforms"))
+		}
+	} else {
+		res, e := p.patchImage(ctx, _client, name, nil, folder)
+		if e != nil {
+			res.err = e
+			return p.handleFailedResult(ctx, []patchResult{*res}, patchedImageName, e)
+		}
+		results = append(results, *res)
+	}
+
+	if !hasUpdates(results) {
+		return types.NewError(types.ErrorKindNoUpdates, errors.New("no applicable updates"))
+	}
+
+	created := time.Now()
+
+	images, err := p.getPlatformImages(results, patchedImageName, endpoint, started, created)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse report")
+		return err
 	}
 
-	_client, err := buildkit.NewClient(ctx, p.opts)
+	var dgst digest.Digest
+
+	// The export is not retried as the loader consumes the exported image
+	err = p.cfg.Phases.Run(ctx, utils.PhaseExport, false, func(ctx context.Context) error {
+		var e error
+		dgst, e = p.export(ctx, _client, images, patchedImageName, output, loader, signer)
+		return e
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed to create new client")
+		return errors.Wrap(err, "failed to export")
+	}
+
+	if err := p.writeProvenance(images, patchedImageName, dgst, output); err != nil {
+		return errors.Wrap(err, "failed to write provenance")
 	}
 
-	defer func(c *client.Client) {
-		_ = c.Close()
-	}(_client)
+	if p.cfg.VEXOutput != "" {
+		if err := p.writeVEX(results, imageName.Name(), dgst, created); err != nil {
+			return errors.Wrap(err, "failed to write vex")
+		}
+	}
+
+	if err := p.handleResult(ctx, getResult(results, p.cfg.Image, patchedImageName, dgst, created)); err != nil {
+		return err
+	}
+
+	if pkgs, platforms := getFailedCount(results); pkgs != 0 || platforms != 0 {
+		return types.NewError(types.ErrorKindPartialPatch,
+			errors.Errorf("patched with %d failed packages and %d failed platforms", pkgs, platforms))
+	}
 
-	_config, err := buildkit.InitializeBuildkitConfig(ctx, _client, p.cfg.Image, &manifest)
+	return nil
+}
+
+// getPlatformImages returns the patched images of the results with their provenance labels, annotations, history
+// and attestations. The original image kept for an ignored platform is passed through as is.
+// nolint: lll
+func (p *patcher) getPlatformImages(results []patchResult, name, endpoint string, started, created time.Time) ([]buildkit.PlatformImage, error) {
+	images := make([]buildkit.PlatformImage, 0, len(results))
+
+	for i := range results {
+		img := results[i].image
+		// The original image kept for an ignored platform has no patch layer nor provenance
+		if results[i].pkgmgr != nil {
+			labels, e := getProvenanceLabels(&results[i], created)
+			if e != nil {
+				return nil, errors.Wrap(e, "failed to get provenance labels")
+			}
+			if img.ConfigData, e = buildkit.WithConfigLabels(img.ConfigData, labels); e != nil {
+				return nil, errors.Wrap(e, "failed to add config labels")
+			}
+			history := results[i].pkgmgr.GetHistory(created, getPatchedPackages(&results[i]))
+			if img.ConfigData, e = buildkit.WithConfigHistory(img.ConfigData, history); e != nil {
+				return nil, errors.Wrap(e, "failed to add config history")
+			}
+			var predicate []byte
+			if predicate, e = p.getProvenancePredicate(&results[i], endpoint, started, created); e != nil {
+				return nil, errors.Wrap(e, "failed to get provenance predicate")
+			}
+			img.Attestations = append(img.Attestations, buildkit.Attestation{
+				PredicateType: slsa02.PredicateSLSAProvenance,
+				Predicate:     predicate,
+			})
+			if e = p.addSBOM(&results[i], &img, name, created, len(results) > 1); e != nil {
+				return nil, errors.Wrap(e, "failed to add sbom")
+			}
+			img.Annotations = getProvenanceAnnotations(labels, p.cfg.Image, results[i].sourceDigest)
+		}
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// hasUpdates reports whether any package of the reports is installed in the patched images.
+func hasUpdates(results []patchResult) bool {
+	for i := range results {
+		if results[i].pkgmgr != nil && len(results[i].pkgmgr.GetInstalledVersions()) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getFailedCount returns the number of packages and platforms failed to be patched but ignored.
+func getFailedCount(results []patchResult) (pkgs, platforms int) {
+	for i := range results {
+		pkgs += len(results[i].errPkgs)
+		if results[i].err != nil {
+			platforms++
+		}
+	}
+
+	return pkgs, platforms
+}
+
+// validateIndexOutput checks the image index required by multiple platforms or an sbom attestation can be exported,
+// which is rejected by the docker exporter of a docker-archive output or an image store load.
+func (p *patcher) validateIndexOutput(output *buildkit.Output) error {
+	if buildkit.SupportsIndex(p.cfg.Push, output) {
+		return nil
+	}
+
+	if p.cfg.MultiPlatform {
+		return errors.New("multi-platform requires push or oci output")
+	}
+
+	if p.cfg.SBOM != "" {
+		return errors.New("sbom attestation requires push or oci output, use sbom output instead")
+	}
+
+	return nil
+}
+
+// getSigner loads the signing key, which requires the image to be pushed or exported into an oci layout
+// where its signature is stored.
+func (p *patcher) getSigner(output *buildkit.Output) (crypto.Signer, error) {
+	if p.cfg.SignKey == "" {
+		return nil, nil
+	}
+
+	if !p.cfg.Push && (output == nil || output.Type != buildkit.OutputOCILayout) {
+		return nil, errors.New("signing requires push or oci-layout output")
+	}
+
+	return signature.LoadPrivateKey(p.cfg.SignKey, []byte(os.Getenv(signature.EnvPassword)))
+}
+
+// export pushes, outputs or loads the patched images, and signs them if signer is not nil.
+// It returns the digest of the exported manifest or index.
+// nolint: lll
+func (p *patcher) export(ctx context.Context, clt *client.Client, images []buildkit.PlatformImage, name string, output *buildkit.Output, loader buildkit.Loader, signer crypto.Signer) (digest.Digest, error) {
+	repo := name[:strings.LastIndex(name, ":")]
+
+	switch {
+	case p.cfg.Push:
+		reg := p.getRegistry()
+		dgst, err := buildkit.SolveToRegistry(ctx, clt, images, name, reg)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to solve to registry")
+		}
+		if signer != nil {
+			if err := signature.Push(ctx, buildkit.NewResolver(reg), repo, dgst, signer); err != nil {
+				return "", types.NewError(types.ErrorKindExport, errors.Wrap(err, "failed to push signature"))
+			}
+			p.logger().Printf("signed %s@%s", repo, dgst)
+		}
+		p.logger().Printf("pushed %s@%s", name, dgst)
+		return dgst, nil
+	case output != nil:
+		dgst, err := buildkit.SolveToOutput(ctx, clt, images, name, output)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to solve to output")
+		}
+		if signer != nil {
+			if err := signature.WriteLayout(output.Path, repo, dgst, signer); err != nil {
+				return "", types.NewError(types.ErrorKindExport, errors.Wrap(err, "failed to write signature"))
+			}
+			p.logger().Printf("signed %s@%s", repo, dgst)
+		}
+		return dgst, nil
+	default:
+		dgst, err := buildkit.SolveToDocker(ctx, clt, images, name, loader)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to solve to docker")
+		}
+		return dgst, nil
+	}
+}
+
+// ExcludeUpdates drops the updates of the packages matching the patterns of the package policy.
+func ExcludeUpdates(updates types.UpdatePackages, patterns []string) types.UpdatePackages {
+	return excludeUpdates(updates, patterns, nil)
+}
+
+// excludeUpdates is like ExcludeUpdates but logs the dropped updates to the logger.
+func excludeUpdates(updates types.UpdatePackages, patterns []string, logger *log.Logger) types.UpdatePackages {
+	if len(patterns) == 0 {
+		return updates
+	}
+
+	out := types.UpdatePackages{}
+
+	for _, u := range updates {
+		if slices.ContainsFunc(patterns, func(pattern string) bool {
+			ok, _ := path.Match(pattern, u.Name)
+			return ok
+		}) {
+			utils.GetLogger(logger).Printf("exclude update of package %s", u.Name)
+			continue
+		}
+		out = append(out, u)
+	}
+
+	return out
+}
+
+func (p *patcher) logger() *log.Logger {
+	return utils.GetLogger(p.cfg.Logger)
+}
+
+// getRegistry returns the registry to resolve the image from with the mirrors, and push the patched image to.
+func (p *patcher) getRegistry() *buildkit.Registry {
+	return &buildkit.Registry{
+		Insecure:  p.cfg.Insecure,
+		Mirrors:   p.cfg.Config.Registry.Mirrors,
+		PlainHTTP: p.cfg.PlainHTTP,
+	}
+}
+
+// getDefaultTag derives the tag of the patched image from the tag of the image,
+// or from the digest of the image if it is referenced by digest only.
+func getDefaultTag(imageName reference.Named) string {
+	if tagged, ok := imageName.(reference.Tagged); ok && tagged.Tag() != "" {
+		return fmt.Sprintf("%s-%s", tagged.Tag(), DefaultTag)
+	}
+
+	if digested, ok := imageName.(reference.Digested); ok {
+		encoded := digested.Digest().Encoded()
+		if len(encoded) > digestTagLen {
+			encoded = encoded[:digestTagLen]
+		}
+		return fmt.Sprintf("%s-%s-%s", digested.Digest().Algorithm(), encoded, DefaultTag)
+	}
+
+	return DefaultTag
+}
+
+// patchPlatforms patches the image of each platform in the image index with the report of the platform,
+// falling back to the shared report.
+func (p *patcher) patchPlatforms(ctx context.Context, clt *client.Client, name, workDir string) ([]patchResult, error) {
+	_platforms, err := buildkit.ResolvePlatforms(ctx, p.cfg.Image, p.getRegistry())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve platforms")
+	}
+
+	results := make([]patchResult, 0, len(_platforms))
+
+	var failed, ignored *multierror.Error
+
+	for i := range _platforms {
+		id := buildkit.PlatformID(_platforms[i])
+		folder := filepath.Join(workDir, strings.ReplaceAll(id, "/", "-"))
+		if _, err = utils.EnsurePath(folder, DefaultPerm); err != nil {
+			return nil, errors.Wrap(err, "failed to create platform folder")
+		}
+		res, e := p.patchImage(ctx, clt, p.getPlatformReport(id, name), &_platforms[i], folder)
+		if e != nil {
+			e = errors.Wrapf(e, "platform %s", id)
+			// Keep the original image of the platform in the index if errors are ignored
+			if p.cfg.IgnoreErrors && res.image.State != nil {
+				p.logger().Printf("keep original image of platform %s: %v", id, e)
+				res.err = e
+				ignored = multierror.Append(ignored, e)
+			} else {
+				failed = multierror.Append(failed, e)
+				// Keep the result of the failed packages to report them
+				if len(res.errPkgs) != 0 {
+					res.err = e
+					results = append(results, *res)
+				}
+				continue
+			}
+		}
+		results = append(results, *res)
+	}
+
+	if failed != nil {
+		return results, failed.ErrorOrNil()
+	}
+
+	if ignored != nil && ignored.Len() == len(_platforms) {
+		return nil, errors.Wrap(ignored.ErrorOrNil(), "failed to patch any platform")
+	}
+
+	if ignored != nil {
+		p.logger().Printf("patched %d of %d platforms", len(_platforms)-ignored.Len(), len(_platforms))
+	}
+
+	return results, nil
+}
+
+func (p *patcher) getPlatformReport(id, name string) string {
+	for k, v := range p.cfg.PlatformReports {
+		if _platform, err := platforms.Parse(k); err == nil && buildkit.PlatformID(_platform) == id {
+			return v
+		}
+	}
+
+	return name
+}
+
+// patchImage patches the image of the platform, which is inferred from the report if nil.
+// The original image is kept in the result if it can be loaded but fails to be patched.
+// nolint: lll
+func (p *patcher) patchImage(ctx context.Context, clt *client.Client, name string, _platform *ispec.Platform, folder string) (*patchResult, error) {
+	res := &patchResult{report: name}
+
+	manifest, err := p.cfg.Report.Run(ctx, name)
 	if err != nil {
-		return errors.Wrap(err, "failed to init buildkit config")
+		return res, types.NewError(types.ErrorKindBadReport, errors.Wrap(err, "failed to parse report"))
+	}
+
+	if res.reportDigest, err = utils.GetFileDigest(name); err != nil {
+		return res, errors.Wrap(err, "failed to get report digest")
+	}
+
+	manifest.Updates = excludeUpdates(manifest.Updates, p.cfg.Config.Packages.Exclude, p.cfg.Logger)
+
+	var _config *buildkit.Config
+
+	if _platform != nil {
+		manifest.Metadata.Config.Arch = _platform.Architecture
+		_config, err = buildkit.InitializeBuildkitPlatformConfig(ctx, clt, p.cfg.Image, *_platform, p.getRegistry(), &p.cfg.Phases)
+	} else {
+		_config, err = buildkit.InitializeBuildkitConfig(ctx, clt, p.cfg.Image, &manifest, p.getRegistry(), &p.cfg.Phases)
 	}
 
-	_pkgmgr, err := pkgmgr.GetPackageManager(manifest.Metadata.OS.Type, _config, DefaultFolder)
 	if err != nil {
-		return errors.Wrap(err, "failed to get package manager")
+		return res, errors.Wrap(err, "failed to init buildkit config")
 	}
 
-	patchedImageState, errPkgs, err := _pkgmgr.InstallUpdates(ctx, &manifest, p.cfg.IgnoreErrors)
+	_config.ToolImages = p.cfg.Config.Tooling.Images
+
+	res.manifest = manifest
+	res.sourceDigest = _config.ImageDigest
+	res.image = buildkit.PlatformImage{
+		Platform:   _config.Platform,
+		State:      &_config.ImageState,
+		ConfigData: _config.ConfigData,
+	}
+
+	_pkgmgr, err := pkgmgr.GetPackageManager(manifest.Metadata.OS.Type, _config, folder)
 	if err != nil {
-		return errors.Wrap(err, "failed to install updates")
+		return res, errors.Wrap(err, "failed to get package manager")
 	}
 
-	if err := buildkit.SolveToDocker(ctx, _config.Client, patchedImageState, _config.ConfigData, patchedImageName); err != nil {
-		return errors.Wrap(err, "failed to solve to docker")
+	if err := buildkit.ValidateClient(ctx, clt, append(_pkgmgr.GetRequiredCaps(), buildkit.ExportCaps...)...); err != nil {
+		return res, errors.Wrap(err, "failed to validate client")
 	}
 
-	for _, update := range manifest.Updates {
-		if !slices.Contains(errPkgs, update.Name) {
-			// TODO: FIXME
+	patchedImageState, errPkgs, err := _pkgmgr.InstallUpdates(ctx, &manifest, p.cfg.IgnoreErrors)
+	if err != nil {
+		// The failed packages are reported with the original image of the platform
+		if len(errPkgs) != 0 {
+			res.errPkgs = errPkgs
+			res.pkgmgr = _pkgmgr
 		}
+		return res, errors.Wrap(err, "failed to install updates")
 	}
 
-	return nil
+	res.image.State = patchedImageState
+	res.errPkgs = errPkgs
+	res.pkgmgr = _pkgmgr
+
+	return res, nil
 }
@@ -1,12 +1,242 @@
 package patcher
 
 import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/distribution/reference"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/buildkit"
+	"github.com/craftslab/copatcher/types"
 )
 
 func TestPatch(t *testing.T) {
 	// TODO: FIXME
 	assert.Equal(t, nil, nil)
 }
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := log.New(&buf, "", 0)
+	p := New(context.Background(), &Config{Logger: logger}).(*patcher)
+	assert.Equal(t, logger, p.cfg.Phases.Logger)
+	assert.Equal(t, logger, p.cfg.Loader.Logger)
+	assert.Equal(t, logger, p.opts.Logger)
+
+	p.cfg.KeepArtifacts = true
+	p.cleanup("/tmp/copatcher/run-1", nil)
+	assert.Equal(t, "keep artifacts in /tmp/copatcher/run-1\n", buf.String())
+}
+
+func TestGetPlatformReport(t *testing.T) {
+	p := &patcher{
+		cfg: &Config{
+			PlatformReports: map[string]string{
+				"linux/arm/v7":  "arm.json",
+				"linux/aarch64": "arm64.json",
+			},
+		},
+	}
+
+	assert.Equal(t, "arm.json", p.getPlatformReport("linux/arm/v7", "report.json"))
+	assert.Equal(t, "arm64.json", p.getPlatformReport("linux/arm64", "report.json"))
+	assert.Equal(t, "report.json", p.getPlatformReport("linux/amd64", "report.json"))
+}
+
+func TestGetDefaultTag(t *testing.T) {
+	testCases := []struct {
+		name  string
+		image string
+		want  string
+	}{
+		{
+			name:  "tagged",
+			image: "ubuntu:22.04",
+			want:  "22.04-patched",
+		},
+		{
+			name:  "canonical tagged",
+			image: "docker.io/library/ubuntu:22.04",
+			want:  "22.04-patched",
+		},
+		{
+			name:  "digested",
+			image: "localhost:5000/ubuntu@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			want:  "sha256-0123456789ab-patched",
+		},
+		{
+			name:  "tagged and digested",
+			image: "ubuntu:22.04@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			want:  "22.04-patched",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			named, err := reference.ParseNormalizedNamed(tc.image)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, getDefaultTag(named))
+		})
+	}
+}
+
+func TestGetSigner(t *testing.T) {
+	p := &patcher{cfg: &Config{}}
+
+	signer, err := p.getSigner(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, signer)
+
+	p.cfg.SignKey = "cosign.key"
+
+	_, err = p.getSigner(nil)
+	assert.Error(t, err)
+
+	_, err = p.getSigner(&buildkit.Output{Type: buildkit.OutputOCIArchive, Path: "image.tar"})
+	assert.Error(t, err)
+
+	_, err = p.getSigner(&buildkit.Output{Type: buildkit.OutputOCILayout, Path: "image"})
+	assert.ErrorContains(t, err, "failed to read key")
+}
+
+func TestValidateIndexOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		output  *buildkit.Output
+		wantErr bool
+	}{
+		{"load", Config{}, nil, false},
+		{"multi-platform push", Config{MultiPlatform: true, Push: true}, nil, false},
+		{"multi-platform oci-layout", Config{MultiPlatform: true}, &buildkit.Output{Type: buildkit.OutputOCILayout, Path: "image"}, false},
+		{"multi-platform oci-archive", Config{MultiPlatform: true}, &buildkit.Output{Type: buildkit.OutputOCIArchive, Path: "image.tar"}, false},
+		{"multi-platform load", Config{MultiPlatform: true}, nil, true},
+		{"multi-platform docker-archive", Config{MultiPlatform: true}, &buildkit.Output{Type: buildkit.OutputDockerArchive, Path: "image.tar"}, true},
+		{"sbom push", Config{SBOM: "spdx", Push: true}, nil, false},
+		{"sbom load", Config{SBOM: "spdx"}, nil, true},
+		{"sbom output load", Config{SBOMOutput: "sbom.json"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &patcher{cfg: &tt.cfg}
+			assert.Equal(t, tt.wantErr, p.validateIndexOutput(tt.output) != nil)
+		})
+	}
+}
+
+func TestGetPlatformImages(t *testing.T) {
+	p := &patcher{cfg: &Config{Image: "docker.io/library/ubuntu:22.04"}}
+
+	configData := []byte(`{"architecture":"arm64","os":"linux","config":{}}`)
+
+	res := newTestPatchResult()
+	res.pkgmgr = &fakePackageManager{}
+	res.image = buildkit.PlatformImage{Platform: ispec.Platform{OS: "linux", Architecture: "amd64"}, ConfigData: configData}
+
+	kept := newTestPatchResult()
+	kept.errPkgs = nil
+	kept.err = errors.New("failed to install updates")
+	kept.image = buildkit.PlatformImage{Platform: ispec.Platform{OS: "linux", Architecture: "arm64"}, ConfigData: configData}
+
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	images, err := p.getPlatformImages([]patchResult{*res, *kept}, "docker.io/library/ubuntu:22.04-patched", "", created, created)
+	assert.NoError(t, err)
+	assert.Len(t, images, 2)
+
+	assert.NotEqual(t, configData, images[0].ConfigData)
+	assert.NotEmpty(t, images[0].Annotations)
+	assert.Len(t, images[0].Attestations, 1)
+
+	// The original image kept for the ignored platform is passed through as is
+	assert.Equal(t, kept.image, images[1])
+}
+
+func TestHasUpdates(t *testing.T) {
+	res := newTestPatchResult()
+	assert.False(t, hasUpdates([]patchResult{*res}))
+
+	res.pkgmgr = &fakePackageManager{}
+	assert.True(t, hasUpdates([]patchResult{*newTestPatchResult(), *res}))
+}
+
+func TestGetFailedCount(t *testing.T) {
+	res := newTestPatchResult()
+	ignored := newTestPatchResult()
+	ignored.errPkgs = nil
+	ignored.err = errors.New("failed to install updates")
+
+	pkgs, platforms := getFailedCount([]patchResult{*res, *ignored})
+	assert.Equal(t, 1, pkgs)
+	assert.Equal(t, 1, platforms)
+}
+
+func TestExcludeUpdates(t *testing.T) {
+	updates := types.UpdatePackages{
+		{Name: "libssl3"},
+		{Name: "linux-libc-dev"},
+		{Name: "linux-image"},
+	}
+
+	assert.Equal(t, updates, ExcludeUpdates(updates, nil))
+	assert.Equal(t, types.UpdatePackages{{Name: "libssl3"}}, ExcludeUpdates(updates, []string{"linux-*"}))
+	assert.Equal(t, types.UpdatePackages{{Name: "linux-libc-dev"}, {Name: "linux-image"}}, ExcludeUpdates(updates, []string{"libssl3"}))
+}
+
+func TestRunWorkDir(t *testing.T) {
+	tests := []struct {
+		name          string
+		keepArtifacts bool
+		folders       int
+	}{
+		{"remove", false, 0},
+		{"keep artifacts", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := filepath.Join(t.TempDir(), "copatcher")
+			p := New(context.Background(), &Config{
+				Image:         "INVALID:image",
+				KeepArtifacts: tt.keepArtifacts,
+				Timeout:       time.Minute,
+				WorkDir:       workDir,
+			})
+			assert.Error(t, p.Run(context.Background(), "report.json"))
+
+			folders, err := filepath.Glob(filepath.Join(workDir, runFolderPattern))
+			assert.NoError(t, err)
+			assert.Len(t, folders, tt.folders)
+		})
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	folder := t.TempDir()
+	stopped := make(chan struct{})
+
+	p := &patcher{cfg: &Config{}}
+	p.cleanup(folder, stopped)
+	assert.DirExists(t, folder)
+
+	close(stopped)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(folder)
+		return os.IsNotExist(err)
+	}, time.Second, 10*time.Millisecond)
+
+	folder = t.TempDir()
+	p.cfg.KeepArtifacts = true
+	p.cleanup(folder, stopped)
+	assert.DirExists(t, folder)
+}
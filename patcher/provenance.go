@@ -0,0 +1,282 @@
+package patcher
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	slsa02 "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+
+	"github.com/craftslab/copatcher/buildkit"
+	"github.com/craftslab/copatcher/config"
+	"github.com/craftslab/copatcher/types"
+)
+
+// Labels and annotations recording the provenance of the patched image.
+const (
+	labelPrefix = "io.github.craftslab.copatcher."
+
+	LabelCreated      = labelPrefix + "created"
+	LabelPackages     = labelPrefix + "packages"
+	LabelReportDigest = labelPrefix + "report.digest"
+	LabelSourceDigest = labelPrefix + "source.digest"
+	LabelVersion      = labelPrefix + "version"
+)
+
+// getPatchedPackages returns the updates of the report which are applied without errors.
+func getPatchedPackages(res *patchResult) types.UpdatePackages {
+	out := types.UpdatePackages{}
+
+	for _, update := range res.manifest.Updates {
+		if !slices.Contains(res.errPkgs, update.Name) {
+			out = append(out, update)
+		}
+	}
+
+	return out
+}
+
+// getProvenanceLabels returns the labels to add to the config of the patched image.
+func getProvenanceLabels(res *patchResult, created time.Time) (map[string]string, error) {
+	pkgs, err := json.Marshal(getPatchedPackages(res))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal packages")
+	}
+
+	return map[string]string{
+		LabelCreated:      created.UTC().Format(time.RFC3339),
+		LabelPackages:     string(pkgs),
+		LabelReportDigest: res.reportDigest.String(),
+		LabelSourceDigest: res.sourceDigest.String(),
+		LabelVersion:      config.Version + "-build-" + config.Build,
+	}, nil
+}
+
+// getProvenanceAnnotations returns the annotations to add to the manifest of the patched image,
+// which are the labels together with the pre-defined OCI annotations.
+func getProvenanceAnnotations(labels map[string]string, image string, sourceDigest digest.Digest) map[string]string {
+	annotations := map[string]string{
+		ispec.AnnotationBaseImageDigest: sourceDigest.String(),
+		ispec.AnnotationBaseImageName:   image,
+		ispec.AnnotationCreated:         labels[LabelCreated],
+	}
+
+	for k, v := range labels {
+		annotations[k] = v
+	}
+
+	return annotations
+}
+
+const (
+	provenanceBuilderID = "https://github.com/craftslab/copatcher"
+	provenanceBuildType = "https://github.com/craftslab/copatcher/patch@v1"
+	// provenanceOutputSuffix is appended to a docker-archive output to write the provenance statement next to it.
+	provenanceOutputSuffix = ".provenance.json"
+)
+
+// provenanceParameters are the invocation parameters of the patch.
+type provenanceParameters struct {
+	Image    string `json:"image"`
+	Report   string `json:"report"`
+	Tag      string `json:"tag"`
+	Platform string `json:"platform"`
+}
+
+// provenanceEnvironment is the environment the patch runs in.
+type provenanceEnvironment struct {
+	BuildkitEndpoint string `json:"buildkitEndpoint"`
+	Version          string `json:"version"`
+}
+
+// provenanceBuildConfig lists the packages applied by the package manager.
+type provenanceBuildConfig struct {
+	PackageType    string              `json:"packageType"`
+	Packages       []provenancePackage `json:"packages"`
+	FailedPackages []string            `json:"failedPackages,omitempty"`
+}
+
+type provenancePackage struct {
+	Name             string `json:"name"`
+	InstalledVersion string `json:"installedVersion"`
+	UpdatedVersion   string `json:"updatedVersion"`
+	AppliedVersion   string `json:"appliedVersion"`
+}
+
+// getProvenancePredicate returns the SLSA provenance predicate of the patched image of the result.
+func (p *patcher) getProvenancePredicate(res *patchResult, endpoint string, started, finished time.Time) ([]byte, error) {
+	platform := buildkit.PlatformID(res.image.Platform)
+	installed := res.pkgmgr.GetInstalledVersions()
+
+	buildConfig := provenanceBuildConfig{
+		PackageType:    res.pkgmgr.GetPackageType(),
+		Packages:       []provenancePackage{},
+		FailedPackages: res.errPkgs,
+	}
+
+	for _, u := range getPatchedPackages(res) {
+		buildConfig.Packages = append(buildConfig.Packages, provenancePackage{
+			Name:             u.Name,
+			InstalledVersion: u.InstalledVersion,
+			UpdatedVersion:   u.UpdatedVersion,
+			AppliedVersion:   installed[u.Name],
+		})
+	}
+
+	materials := []common.ProvenanceMaterial{
+		{URI: getPackageURL(p.cfg.Image, platform), Digest: getDigestSet(res.sourceDigest)},
+		{URI: getFileURL(res.report), Digest: getDigestSet(res.reportDigest)},
+	}
+
+	for _, image := range res.pkgmgr.GetToolImages() {
+		materials = append(materials, common.ProvenanceMaterial{URI: getPackageURL(image, platform)})
+	}
+
+	predicate := slsa02.ProvenancePredicate{
+		Builder:   common.ProvenanceBuilder{ID: provenanceBuilderID + "@" + config.Version},
+		BuildType: provenanceBuildType,
+		Invocation: slsa02.ProvenanceInvocation{
+			Parameters: provenanceParameters{
+				Image:    p.cfg.Image,
+				Report:   res.report,
+				Tag:      p.cfg.Tag,
+				Platform: platform,
+			},
+			Environment: provenanceEnvironment{
+				BuildkitEndpoint: endpoint,
+				Version:          config.Version + "-build-" + config.Build,
+			},
+		},
+		BuildConfig: buildConfig,
+		Metadata: &slsa02.ProvenanceMetadata{
+			BuildStartedOn:  &started,
+			BuildFinishedOn: &finished,
+			Completeness: slsa02.ProvenanceComplete{
+				Parameters: true,
+				Materials:  true,
+			},
+		},
+		Materials: materials,
+	}
+
+	buf, err := json.Marshal(predicate)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal provenance")
+	}
+
+	return buf, nil
+}
+
+// getPackageURL returns the package url of the docker image for the platform,
+// e.g. pkg:docker/ubuntu@22.04?platform=linux%2Famd64.
+func getPackageURL(image, platform string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+
+	version := ""
+
+	if digested, ok := named.(reference.Digested); ok {
+		version = digested.Digest().String()
+	} else if tagged, ok := named.(reference.Tagged); ok {
+		version = tagged.Tag()
+	}
+
+	out := "pkg:docker/" + reference.FamiliarName(named)
+	if version != "" {
+		out += "@" + version
+	}
+
+	return out + "?platform=" + url.QueryEscape(platform)
+}
+
+func getFileURL(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(name)}).String()
+}
+
+func getDigestSet(dgst digest.Digest) common.DigestSet {
+	if dgst == "" {
+		return nil
+	}
+
+	return common.DigestSet{dgst.Algorithm().String(): dgst.Encoded()}
+}
+
+// getProvenanceOutput returns the file to write the provenance statement to, which is empty if the statement is
+// attached to the exported image index, or if the image is loaded into an image store without a provenance output.
+func (p *patcher) getProvenanceOutput(output *buildkit.Output) string {
+	if buildkit.SupportsIndex(p.cfg.Push, output) {
+		return ""
+	}
+
+	if p.cfg.ProvenanceOutput != "" {
+		return p.cfg.ProvenanceOutput
+	}
+
+	if output != nil {
+		return output.Path + provenanceOutputSuffix
+	}
+
+	return ""
+}
+
+// writeProvenance writes the in-toto statement of the provenance of the image exported with the docker exporter,
+// whose subject is the exported image.
+func (p *patcher) writeProvenance(images []buildkit.PlatformImage, name string, dgst digest.Digest, output *buildkit.Output) error {
+	if buildkit.SupportsIndex(p.cfg.Push, output) {
+		if p.cfg.ProvenanceOutput != "" {
+			p.logger().Printf("skip provenance output as provenance is attached to %s", name)
+		}
+		return nil
+	}
+
+	// The docker exporter exports a single image
+	var predicate []byte
+	for _, a := range images[0].Attestations {
+		if a.PredicateType == slsa02.PredicateSLSAProvenance {
+			predicate = a.Predicate
+		}
+	}
+
+	if predicate == nil {
+		return nil
+	}
+
+	file := p.getProvenanceOutput(output)
+	if file == "" {
+		p.logger().Printf("skip provenance of %s loaded into image store without provenance output", name)
+		return nil
+	}
+
+	buf, err := json.MarshalIndent(in_toto.Statement{
+		StatementHeader: in_toto.StatementHeader{
+			Type:          in_toto.StatementInTotoV01,
+			PredicateType: slsa02.PredicateSLSAProvenance,
+			Subject:       []in_toto.Subject{{Name: name, Digest: getDigestSet(dgst)}},
+		},
+		Predicate: json.RawMessage(predicate),
+	}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal statement")
+	}
+
+	if err := os.WriteFile(file, buf, DefaultFilePerm); err != nil {
+		return errors.Wrap(err, "failed to write statement")
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package patcher
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	slsa02 "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/util/apicaps"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/buildkit"
+	"github.com/craftslab/copatcher/types"
+)
+
+type fakePackageManager struct{}
+
+func (f *fakePackageManager) InstallUpdates(context.Context, *types.UpdateManifest, bool) (*llb.State, []string, error) {
+	return nil, nil, nil
+}
+
+func (f *fakePackageManager) GetPackageType() string {
+	return "deb"
+}
+
+func (f *fakePackageManager) GetRequiredCaps() []apicaps.CapID {
+	return nil
+}
+
+func (f *fakePackageManager) GetHistory(time.Time, types.UpdatePackages) []ispec.History {
+	return nil
+}
+
+func (f *fakePackageManager) GetToolImages() []string {
+	return []string{"ubuntu:22.04"}
+}
+
+func (f *fakePackageManager) GetInstalledVersions() map[string]string {
+	return map[string]string{"openssl": "3.0.2-0ubuntu1.14", "curl": "7.81.0-1ubuntu1.13"}
+}
+
+func (f *fakePackageManager) GetPackages() map[string]string {
+	return map[string]string{"openssl": "3.0.2-0ubuntu1.14", "curl": "7.81.0-1ubuntu1.13", "zlib1g": "1:1.2.11.dfsg-2ubuntu9.2"}
+}
+
+func newTestPatchResult() *patchResult {
+	return &patchResult{
+		manifest: types.UpdateManifest{
+			Updates: types.UpdatePackages{
+				{Name: "openssl", InstalledVersion: "3.0.2-0ubuntu1.10", UpdatedVersion: "3.0.2-0ubuntu1.12"},
+				{Name: "curl", InstalledVersion: "7.81.0-1ubuntu1.13", UpdatedVersion: "7.81.0-1ubuntu1.15"},
+			},
+		},
+		errPkgs:      []string{"curl"},
+		reportDigest: digest.FromString("report"),
+		sourceDigest: digest.FromString("source"),
+	}
+}
+
+func TestGetPatchedPackages(t *testing.T) {
+	res := newTestPatchResult()
+
+	assert.Equal(t, types.UpdatePackages{res.manifest.Updates[0]}, getPatchedPackages(res))
+}
+
+func TestGetProvenanceLabels(t *testing.T) {
+	res := newTestPatchResult()
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	labels, err := getProvenanceLabels(res, created)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-03-01T08:00:00Z", labels[LabelCreated])
+	assert.Equal(t, digest.FromString("report").String(), labels[LabelReportDigest])
+	assert.Equal(t, digest.FromString("source").String(), labels[LabelSourceDigest])
+	assert.Contains(t, labels, LabelVersion)
+
+	var pkgs types.UpdatePackages
+	assert.NoError(t, json.Unmarshal([]byte(labels[LabelPackages]), &pkgs))
+	assert.Equal(t, types.UpdatePackages{res.manifest.Updates[0]}, pkgs)
+
+	annotations := getProvenanceAnnotations(labels, "docker.io/library/ubuntu:22.04", res.sourceDigest)
+	assert.Equal(t, "docker.io/library/ubuntu:22.04", annotations[ispec.AnnotationBaseImageName])
+	assert.Equal(t, res.sourceDigest.String(), annotations[ispec.AnnotationBaseImageDigest])
+	assert.Equal(t, labels[LabelCreated], annotations[ispec.AnnotationCreated])
+	assert.Equal(t, labels[LabelPackages], annotations[LabelPackages])
+}
+
+func TestGetProvenancePredicate(t *testing.T) {
+	p := &patcher{cfg: &Config{Image: "docker.io/library/ubuntu:22.04", Tag: "22.04-patched"}}
+
+	res := newTestPatchResult()
+	res.pkgmgr = &fakePackageManager{}
+	res.report = "/tmp/report.json"
+	res.image = buildkit.PlatformImage{Platform: ispec.Platform{OS: "linux", Architecture: "amd64"}}
+
+	started := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Minute)
+
+	buf, err := p.getProvenancePredicate(res, "unix:///run/buildkit/buildkitd.sock", started, finished)
+	assert.NoError(t, err)
+
+	var predicate struct {
+		slsa02.ProvenancePredicate
+		Invocation struct {
+			Parameters  provenanceParameters  `json:"parameters"`
+			Environment provenanceEnvironment `json:"environment"`
+		} `json:"invocation"`
+		BuildConfig provenanceBuildConfig `json:"buildConfig"`
+	}
+	assert.NoError(t, json.Unmarshal(buf, &predicate))
+
+	assert.Equal(t, provenanceBuildType, predicate.BuildType)
+	assert.Equal(t, "docker.io/library/ubuntu:22.04", predicate.Invocation.Parameters.Image)
+	assert.Equal(t, "linux/amd64", predicate.Invocation.Parameters.Platform)
+	assert.Equal(t, "unix:///run/buildkit/buildkitd.sock", predicate.Invocation.Environment.BuildkitEndpoint)
+	assert.Equal(t, []provenancePackage{
+		{
+			Name:             "openssl",
+			InstalledVersion: "3.0.2-0ubuntu1.10",
+			UpdatedVersion:   "3.0.2-0ubuntu1.12",
+			AppliedVersion:   "3.0.2-0ubuntu1.14",
+		},
+	}, predicate.BuildConfig.Packages)
+	assert.Equal(t, []string{"curl"}, predicate.BuildConfig.FailedPackages)
+
+	assert.Len(t, predicate.Materials, 3)
+	assert.Equal(t, "pkg:docker/ubuntu@22.04?platform=linux%2Famd64", predicate.Materials[0].URI)
+	assert.Equal(t, digest.FromString("source").Encoded(), predicate.Materials[0].Digest["sha256"])
+	assert.Equal(t, "file:///tmp/report.json", predicate.Materials[1].URI)
+	assert.Equal(t, digest.FromString("report").Encoded(), predicate.Materials[1].Digest["sha256"])
+	assert.Equal(t, "pkg:docker/ubuntu@22.04?platform=linux%2Famd64", predicate.Materials[2].URI)
+	assert.Empty(t, predicate.Materials[2].Digest)
+	assert.Equal(t, finished, *predicate.Metadata.BuildFinishedOn)
+}
+
+func TestWriteProvenance(t *testing.T) {
+	dir := t.TempDir()
+	dgst := digest.FromString("image")
+	images := []buildkit.PlatformImage{{Attestations: []buildkit.Attestation{
+		{PredicateType: slsa02.PredicateSLSAProvenance, Predicate: []byte(`{"buildType":"test"}`)},
+	}}}
+
+	tests := []struct {
+		name             string
+		push             bool
+		output           *buildkit.Output
+		provenanceOutput string
+		file             string
+	}{
+		{"push", true, nil, "", ""},
+		{"oci-layout", false, &buildkit.Output{Type: buildkit.OutputOCILayout, Path: filepath.Join(dir, "oci")}, "", ""},
+		{"oci-archive with provenance output", false, &buildkit.Output{Type: buildkit.OutputOCIArchive, Path: filepath.Join(dir, "oci.tar")},
+			filepath.Join(dir, "oci.provenance.json"), ""},
+		{"docker-archive", false, &buildkit.Output{Type: buildkit.OutputDockerArchive, Path: filepath.Join(dir, "docker.tar")}, "",
+			filepath.Join(dir, "docker.tar.provenance.json")},
+		{"docker-archive with provenance output", false, &buildkit.Output{Type: buildkit.OutputDockerArchive, Path: filepath.Join(dir, "image.tar")},
+			filepath.Join(dir, "image.json"), filepath.Join(dir, "image.json")},
+		{"load", false, nil, "", ""},
+		{"load with provenance output", false, nil, filepath.Join(dir, "load.json"), filepath.Join(dir, "load.json")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &patcher{cfg: &Config{Push: tt.push, ProvenanceOutput: tt.provenanceOutput}}
+			assert.Equal(t, tt.file, p.getProvenanceOutput(tt.output))
+			assert.NoError(t, p.writeProvenance(images, "ubuntu:22.04-patched", dgst, tt.output))
+
+			if tt.file == "" {
+				return
+			}
+
+			buf, err := os.ReadFile(tt.file)
+			assert.NoError(t, err)
+
+			var statement in_toto.Statement
+			assert.NoError(t, json.Unmarshal(buf, &statement))
+			assert.Equal(t, in_toto.StatementInTotoV01, statement.Type)
+			assert.Equal(t, slsa02.PredicateSLSAProvenance, statement.PredicateType)
+			assert.Equal(t, []in_toto.Subject{{Name: "ubuntu:22.04-patched", Digest: getDigestSet(dgst)}}, statement.Subject)
+			assert.Equal(t, map[string]interface{}{"buildType": "test"}, statement.Predicate)
+		})
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+}
+
+func TestGetPackageURL(t *testing.T) {
+	dgst := digest.FromString("image")
+
+	assert.Equal(t, "pkg:docker/ubuntu@22.04?platform=linux%2Farm%2Fv7", getPackageURL("ubuntu:22.04", "linux/arm/v7"))
+	assert.Equal(t, "pkg:docker/ghcr.io/craftslab/app@"+dgst.String()+"?platform=linux%2Famd64",
+		getPackageURL("ghcr.io/craftslab/app@"+dgst.String(), "linux/amd64"))
+	assert.Equal(t, "pkg:docker/debian?platform=linux%2Famd64", getPackageURL("debian", "linux/amd64"))
+}
@@ -0,0 +1,167 @@
+package patcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+
+	"github.com/craftslab/copatcher/buildkit"
+	"github.com/craftslab/copatcher/result"
+)
+
+type resultHandlerKey struct{}
+
+// WithResultHandler returns the context whose patch reports its result to the handler after the handlers of the
+// parent context, which is called once the patched image is exported.
+func WithResultHandler(ctx context.Context, fn func(*result.Document)) context.Context {
+	if parent := getResultHandler(ctx); parent != nil {
+		return context.WithValue(ctx, resultHandlerKey{}, func(doc *result.Document) {
+			parent(doc)
+			fn(doc)
+		})
+	}
+
+	return context.WithValue(ctx, resultHandlerKey{}, fn)
+}
+
+func getResultHandler(ctx context.Context) func(*result.Document) {
+	fn, _ := ctx.Value(resultHandlerKey{}).(func(*result.Document))
+	return fn
+}
+
+// getResult returns the result of patching the image into the patched image of the digest.
+func getResult(results []patchResult, image, name string, dgst digest.Digest, created time.Time) *result.Document {
+	doc := &result.Document{
+		Image:        image,
+		PatchedImage: name,
+		Digest:       dgst.String(),
+		Created:      created.UTC().Format(time.RFC3339),
+		Platforms:    make([]result.Platform, 0, len(results)),
+	}
+
+	for i := range results {
+		res := &results[i]
+		_platform := result.Platform{
+			Platform:     buildkit.PlatformID(res.image.Platform),
+			OS:           res.manifest.Metadata.OS.Type,
+			PackageType:  getPackageTypeOfOS(res.manifest.Metadata.OS.Type),
+			ReportDigest: res.reportDigest.String(),
+			Packages:     getPackageResults(res),
+		}
+		if res.pkgmgr != nil {
+			_platform.PackageType = res.pkgmgr.GetPackageType()
+		}
+		if res.err != nil {
+			_platform.Error = res.err.Error()
+		}
+		doc.Platforms = append(doc.Platforms, _platform)
+	}
+
+	doc.Update()
+
+	return doc
+}
+
+// getPackageResults returns the result of each unique update of the report.
+// A package is already current if its installed version is not changed by the patch.
+func getPackageResults(res *patchResult) []result.Package {
+	installed := map[string]string{}
+	if res.pkgmgr != nil {
+		installed = res.pkgmgr.GetInstalledVersions()
+	}
+
+	out := make([]result.Package, 0, len(res.manifest.Updates))
+
+	for _, update := range res.manifest.Updates {
+		pkg := result.Package{Name: update.Name, RequestedVersion: update.UpdatedVersion}
+		// Reports list a package once for each of its vulnerabilities
+		if i := slices.IndexFunc(out, func(p result.Package) bool {
+			return p.Name == pkg.Name && p.RequestedVersion == pkg.RequestedVersion
+		}); i >= 0 {
+			out[i].Vulnerabilities = addVulnerability(out[i].Vulnerabilities, update.VulnerabilityID)
+			continue
+		}
+		pkg.Vulnerabilities = addVulnerability(nil, update.VulnerabilityID)
+		version, ok := installed[update.Name]
+		pkg.InstalledVersion = version
+		switch {
+		case res.pkgmgr == nil:
+			pkg.Status = result.StatusSkipped
+			pkg.Error = "platform not patched"
+		case slices.Contains(res.errPkgs, update.Name):
+			pkg.Status = result.StatusFailed
+			pkg.Error = fmt.Sprintf("installed version %s does not satisfy requested version %s", version, update.UpdatedVersion)
+		case !ok:
+			pkg.Status = result.StatusSkipped
+			pkg.Error = "package not updated"
+		case version == update.InstalledVersion:
+			pkg.Status = result.StatusCurrent
+		default:
+			pkg.Status = result.StatusPatched
+		}
+		out = append(out, pkg)
+	}
+
+	return out
+}
+
+func addVulnerability(ids []string, id string) []string {
+	if id == "" || slices.Contains(ids, id) {
+		return ids
+	}
+
+	return append(ids, id)
+}
+
+// handleResult passes the result to the result handler of the context, and writes it to the result output.
+func (p *patcher) handleResult(ctx context.Context, doc *result.Document) error {
+	if handler := getResultHandler(ctx); handler != nil {
+		handler(doc)
+	}
+
+	if p.cfg.ResultOutput != "" {
+		if err := p.writeResult(doc); err != nil {
+			return errors.Wrap(err, "failed to write result")
+		}
+	}
+
+	return nil
+}
+
+// handleFailedResult handles the result of the failed packages of the results, which are not exported, and returns
+// the error of the patch.
+func (p *patcher) handleFailedResult(ctx context.Context, results []patchResult, name string, err error) error {
+	if !slices.ContainsFunc(results, func(res patchResult) bool { return len(res.errPkgs) != 0 }) {
+		return err
+	}
+
+	// The error of the patch is returned over the one of the result
+	if e := p.handleResult(ctx, getResult(results, p.cfg.Image, name, "", time.Now())); e != nil {
+		p.logger().Printf("%v", e)
+	}
+
+	return err
+}
+
+func (p *patcher) writeResult(doc *result.Document) error {
+	format := p.cfg.ResultFormat
+	if format == "" {
+		format = result.FormatJSON
+	}
+
+	buf, err := result.Generate(format, doc)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate result")
+	}
+
+	if err := os.WriteFile(p.cfg.ResultOutput, buf, DefaultFilePerm); err != nil {
+		return errors.Wrap(err, "failed to write file")
+	}
+
+	return nil
+}
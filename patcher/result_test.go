@@ -0,0 +1,153 @@
+package patcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/result"
+	"github.com/craftslab/copatcher/types"
+)
+
+func TestGetPackageResults(t *testing.T) {
+	res := newTestPatchResult()
+	res.pkgmgr = &fakePackageManager{}
+	res.manifest.Updates = append(res.manifest.Updates,
+		types.UpdatePackage{Name: "openssl", InstalledVersion: "3.0.2-0ubuntu1.10", UpdatedVersion: "3.0.2-0ubuntu1.12"},
+		types.UpdatePackage{Name: "zlib1g", InstalledVersion: "1:1.2.11.dfsg-2ubuntu9", UpdatedVersion: "1:1.2.11.dfsg-2ubuntu9.2"})
+	res.errPkgs = nil
+
+	t.Run("patched", func(t *testing.T) {
+		assert.Equal(t, []result.Package{
+			{Name: "openssl", RequestedVersion: "3.0.2-0ubuntu1.12", InstalledVersion: "3.0.2-0ubuntu1.14", Status: result.StatusPatched},
+			{Name: "curl", RequestedVersion: "7.81.0-1ubuntu1.15", InstalledVersion: "7.81.0-1ubuntu1.13", Status: result.StatusCurrent},
+			{Name: "zlib1g", RequestedVersion: "1:1.2.11.dfsg-2ubuntu9.2", Status: result.StatusSkipped, Error: "package not updated"},
+		}, getPackageResults(res))
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		r := *res
+		r.errPkgs = []string{"curl"}
+		out := getPackageResults(&r)
+		assert.Equal(t, result.StatusFailed, out[1].Status)
+		assert.Equal(t, "installed version 7.81.0-1ubuntu1.13 does not satisfy requested version 7.81.0-1ubuntu1.15", out[1].Error)
+	})
+
+	t.Run("vulnerabilities", func(t *testing.T) {
+		r := *res
+		r.manifest.Updates = types.UpdatePackages{
+			{Name: "openssl", InstalledVersion: "3.0.2-0ubuntu1.10", UpdatedVersion: "3.0.2-0ubuntu1.12", VulnerabilityID: "CVE-2023-0286"},
+			{Name: "openssl", InstalledVersion: "3.0.2-0ubuntu1.10", UpdatedVersion: "3.0.2-0ubuntu1.12", VulnerabilityID: "CVE-2023-0464"},
+			{Name: "openssl", InstalledVersion: "3.0.2-0ubuntu1.10", UpdatedVersion: "3.0.2-0ubuntu1.12", VulnerabilityID: "CVE-2023-0286"},
+			{Name: "curl", InstalledVersion: "7.81.0-1ubuntu1.13", UpdatedVersion: "7.81.0-1ubuntu1.15"},
+		}
+		out := getPackageResults(&r)
+		assert.Len(t, out, 2)
+		assert.Equal(t, []string{"CVE-2023-0286", "CVE-2023-0464"}, out[0].Vulnerabilities)
+		assert.Empty(t, out[1].Vulnerabilities)
+	})
+
+	t.Run("not patched", func(t *testing.T) {
+		r := *res
+		r.pkgmgr = nil
+		for _, p := range getPackageResults(&r) {
+			assert.Equal(t, result.StatusSkipped, p.Status)
+			assert.Empty(t, p.InstalledVersion)
+		}
+	})
+}
+
+func TestGetResult(t *testing.T) {
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	res := newTestPatchResult()
+	res.pkgmgr = &fakePackageManager{}
+	res.image.Platform = ispec.Platform{OS: "linux", Architecture: "amd64"}
+
+	ignored := newTestPatchResult()
+	ignored.image.Platform = ispec.Platform{OS: "linux", Architecture: "arm64"}
+	ignored.err = errors.New("failed to install updates")
+
+	doc := getResult([]patchResult{*res, *ignored}, "ubuntu:22.04", "docker.io/library/ubuntu:22.04-patched",
+		digest.FromString("patched"), created)
+	assert.Equal(t, "2024-03-01T08:00:00Z", doc.Created)
+	assert.Len(t, doc.Platforms, 2)
+	assert.Equal(t, "linux/amd64", doc.Platforms[0].Platform)
+	assert.Equal(t, "deb", doc.Platforms[0].PackageType)
+	assert.Equal(t, digest.FromString("report").String(), doc.Platforms[0].ReportDigest)
+	assert.Empty(t, doc.Platforms[0].Error)
+	assert.Equal(t, "failed to install updates", doc.Platforms[1].Error)
+	assert.Equal(t, result.Summary{Patched: 1, Failed: 1, Skipped: 2}, doc.Summary)
+
+	name := filepath.Join(t.TempDir(), "result.xml")
+	p := &patcher{cfg: &Config{ResultFormat: result.FormatJUnit, ResultOutput: name}}
+	assert.NoError(t, p.writeResult(doc))
+
+	buf, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf), "<testsuites")
+}
+
+func TestWithResultHandler(t *testing.T) {
+	assert.Nil(t, getResultHandler(context.Background()))
+
+	var got *result.Document
+
+	ctx := WithResultHandler(context.Background(), func(doc *result.Document) {
+		got = doc
+	})
+
+	var calls int
+
+	ctx = WithResultHandler(ctx, func(*result.Document) {
+		calls++
+	})
+
+	doc := &result.Document{Image: "ubuntu:22.04"}
+	getResultHandler(ctx)(doc)
+	assert.Equal(t, doc, got)
+	assert.Equal(t, 1, calls)
+}
+
+func TestHandleFailedResult(t *testing.T) {
+	errPatch := errors.New("failed to install updates")
+
+	res := newTestPatchResult()
+	res.pkgmgr = &fakePackageManager{}
+	res.err = errPatch
+
+	t.Run("failed packages", func(t *testing.T) {
+		var got *result.Document
+		ctx := WithResultHandler(context.Background(), func(doc *result.Document) {
+			got = doc
+		})
+		name := filepath.Join(t.TempDir(), "result.json")
+		p := &patcher{cfg: &Config{Image: "docker.io/library/ubuntu:22.04", ResultOutput: name}}
+		err := p.handleFailedResult(ctx, []patchResult{*res}, "docker.io/library/ubuntu:22.04-patched", errPatch)
+		assert.Equal(t, errPatch, err)
+		assert.NotNil(t, got)
+		assert.Empty(t, got.Digest)
+		assert.Equal(t, "failed to install updates", got.Platforms[0].Error)
+		assert.Equal(t, result.StatusFailed, got.Platforms[0].Packages[1].Status)
+		assert.Equal(t, 1, got.Summary.Failed)
+		assert.FileExists(t, name)
+	})
+
+	t.Run("no failed packages", func(t *testing.T) {
+		r := *res
+		r.errPkgs = nil
+		r.pkgmgr = nil
+		name := filepath.Join(t.TempDir(), "result.json")
+		p := &patcher{cfg: &Config{ResultOutput: name}}
+		err := p.handleFailedResult(context.Background(), []patchResult{r}, "ubuntu:22.04-patched", errPatch)
+		assert.Equal(t, errPatch, err)
+		assert.NoFileExists(t, name)
+	})
+}
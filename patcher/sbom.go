@@ -0,0 +1,81 @@
+package patcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/copatcher/buildkit"
+	"github.com/craftslab/copatcher/config"
+	"github.com/craftslab/copatcher/sbom"
+)
+
+// getSBOMFormat returns the format of the sbom, which defaults to cyclonedx if only written to a file.
+func (p *patcher) getSBOMFormat() string {
+	if p.cfg.SBOM == "" && p.cfg.SBOMOutput != "" {
+		return sbom.FormatCycloneDX
+	}
+
+	return p.cfg.SBOM
+}
+
+// addSBOM attaches the sbom of the patched image of the result to img and writes it to the sbom output.
+// It is skipped if the packages of the patched image are unknown, e.g. for an image without updates.
+func (p *patcher) addSBOM(res *patchResult, img *buildkit.PlatformImage, name string, created time.Time, multi bool) error {
+	format := p.getSBOMFormat()
+	if format == "" {
+		return nil
+	}
+
+	pkgs := res.pkgmgr.GetPackages()
+	if pkgs == nil {
+		p.logger().Printf("skip sbom of platform %s with unknown packages", buildkit.PlatformID(img.Platform))
+		return nil
+	}
+
+	buf, err := sbom.Generate(format, &sbom.Document{
+		Name:      name,
+		OSType:    res.manifest.Metadata.OS.Type,
+		OSVersion: res.manifest.Metadata.OS.Version,
+		Packages:  sbom.NewPackages(res.pkgmgr.GetPackageType(), pkgs),
+		Created:   created,
+		Version:   config.Version,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to generate sbom")
+	}
+
+	if p.cfg.SBOM != "" {
+		predicateType, e := sbom.GetPredicateType(format)
+		if e != nil {
+			return errors.Wrap(e, "failed to get predicate type")
+		}
+		img.Attestations = append(img.Attestations, buildkit.Attestation{
+			PredicateType: predicateType,
+			Predicate:     buf,
+		})
+	}
+
+	if p.cfg.SBOMOutput != "" {
+		if err := os.WriteFile(getSBOMOutput(p.cfg.SBOMOutput, buildkit.PlatformID(img.Platform), multi), buf, DefaultFilePerm); err != nil {
+			return errors.Wrap(err, "failed to write sbom")
+		}
+	}
+
+	return nil
+}
+
+// getSBOMOutput returns the sbom file of the platform, which is suffixed with the platform if there are more than one,
+// e.g. sbom-linux-arm-v7.json for sbom.json.
+func getSBOMOutput(name, platform string, multi bool) string {
+	if !multi {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+
+	return strings.TrimSuffix(name, ext) + "-" + strings.ReplaceAll(platform, "/", "-") + ext
+}
@@ -0,0 +1,59 @@
+package patcher
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/buildkit"
+	"github.com/craftslab/copatcher/sbom"
+)
+
+func TestGetSBOMOutput(t *testing.T) {
+	assert.Equal(t, "sbom.json", getSBOMOutput("sbom.json", "linux/arm/v7", false))
+	assert.Equal(t, "out/sbom-linux-arm-v7.json", getSBOMOutput("out/sbom.json", "linux/arm/v7", true))
+}
+
+func TestAddSBOM(t *testing.T) {
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	res := newTestPatchResult()
+	res.pkgmgr = &fakePackageManager{}
+	res.manifest.Metadata.OS.Type = "ubuntu"
+	res.manifest.Metadata.OS.Version = "22.04"
+
+	t.Run("disabled", func(t *testing.T) {
+		p := &patcher{cfg: &Config{}}
+		img := &buildkit.PlatformImage{}
+		assert.NoError(t, p.addSBOM(res, img, "ubuntu:22.04-patched", created, false))
+		assert.Empty(t, img.Attestations)
+	})
+
+	t.Run("attestation", func(t *testing.T) {
+		p := &patcher{cfg: &Config{SBOM: sbom.FormatSPDX}}
+		img := &buildkit.PlatformImage{}
+		assert.NoError(t, p.addSBOM(res, img, "ubuntu:22.04-patched", created, false))
+		assert.Len(t, img.Attestations, 1)
+		assert.Equal(t, sbom.PredicateSPDX, img.Attestations[0].PredicateType)
+	})
+
+	t.Run("output", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "sbom.json")
+		p := &patcher{cfg: &Config{SBOMOutput: name}}
+		img := &buildkit.PlatformImage{Platform: ispec.Platform{OS: "linux", Architecture: "amd64"}}
+		assert.NoError(t, p.addSBOM(res, img, "ubuntu:22.04-patched", created, false))
+		assert.Empty(t, img.Attestations)
+
+		buf, err := os.ReadFile(name)
+		assert.NoError(t, err)
+
+		var bom map[string]any
+		assert.NoError(t, json.Unmarshal(buf, &bom))
+		assert.Equal(t, "CycloneDX", bom["bomFormat"])
+		assert.Len(t, bom["components"], 3)
+	})
+}
@@ -0,0 +1,103 @@
+package patcher
+
+import (
+	"os"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+
+	"github.com/craftslab/copatcher/config"
+	"github.com/craftslab/copatcher/pkgmgr"
+	"github.com/craftslab/copatcher/sbom"
+	"github.com/craftslab/copatcher/vex"
+)
+
+const (
+	vexAuthor = "copatcher"
+)
+
+// getVEX returns the OpenVEX document of the patched image in the repository, which marks the vulnerabilities
+// of the packages installed at or above the updated versions as fixed, and the others as under investigation.
+// It returns nil if the reports carry no vulnerability ids.
+func getVEX(results []patchResult, repo string, dgst digest.Digest, created time.Time) *vex.Document {
+	doc := vex.New(vexAuthor, vexAuthor+" "+config.Version, created)
+	product := vex.ImageURL(repo, dgst)
+
+	for i := range results {
+		res := &results[i]
+		pkgType := ""
+		installed := map[string]string{}
+		if res.pkgmgr != nil {
+			pkgType = res.pkgmgr.GetPackageType()
+			installed = res.pkgmgr.GetInstalledVersions()
+		}
+		for _, update := range res.manifest.Updates {
+			if update.VulnerabilityID == "" {
+				continue
+			}
+			// The original image is kept for a platform failed to be patched if errors are ignored
+			version, ok := installed[update.Name]
+			status := vex.StatusUnderInvestigation
+			if ok && !slices.Contains(res.errPkgs, update.Name) &&
+				isFixedVersion(res.manifest.Metadata.OS.Type, version, update.UpdatedVersion) {
+				status = vex.StatusFixed
+			}
+			if !ok {
+				version = update.InstalledVersion
+			}
+			pkg := &sbom.Package{Name: update.Name, Version: version, Type: pkgType}
+			if pkg.Type == "" {
+				pkg.Type = getPackageTypeOfOS(res.manifest.Metadata.OS.Type)
+			}
+			doc.Add(update.VulnerabilityID, status, product,
+				sbom.PackageURL(res.manifest.Metadata.OS.Type, res.manifest.Metadata.OS.Version, pkg))
+		}
+	}
+
+	if len(doc.Statements) == 0 {
+		return nil
+	}
+
+	return doc
+}
+
+// isFixedVersion reports whether the installed version is at or above the updated version of the os type.
+func isFixedVersion(osType, installed, updated string) bool {
+	cmp, err := pkgmgr.GetVersionComparer(osType)
+	if err != nil || !cmp.IsValid(installed) || !cmp.IsValid(updated) {
+		return false
+	}
+
+	return !cmp.LessThan(installed, updated)
+}
+
+// getPackageTypeOfOS returns the package type of the os, for the platforms without a package manager.
+func getPackageTypeOfOS(osType string) string {
+	switch osType {
+	case "debian", "ubuntu":
+		return "deb"
+	default:
+		return osType
+	}
+}
+
+func (p *patcher) writeVEX(results []patchResult, repo string, dgst digest.Digest, created time.Time) error {
+	doc := getVEX(results, repo, dgst, created)
+	if doc == nil {
+		p.logger().Printf("skip vex without vulnerability ids in report")
+		return nil
+	}
+
+	buf, err := doc.Marshal()
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal vex")
+	}
+
+	if err := os.WriteFile(p.cfg.VEXOutput, buf, DefaultFilePerm); err != nil {
+		return errors.Wrap(err, "failed to write file")
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package patcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/types"
+	"github.com/craftslab/copatcher/vex"
+)
+
+func TestGetVEX(t *testing.T) {
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	dgst := digest.FromString("patched")
+
+	t.Run("without vulnerability ids", func(t *testing.T) {
+		res := newTestPatchResult()
+		res.pkgmgr = &fakePackageManager{}
+		assert.Nil(t, getVEX([]patchResult{*res}, "docker.io/library/ubuntu", dgst, created))
+	})
+
+	t.Run("statements", func(t *testing.T) {
+		res := newTestPatchResult()
+		res.pkgmgr = &fakePackageManager{}
+		res.manifest.Metadata.OS.Type = "ubuntu"
+		res.manifest.Metadata.OS.Version = "22.04"
+		res.manifest.Updates[0].VulnerabilityID = "CVE-2023-5678"
+		res.manifest.Updates[1].VulnerabilityID = "CVE-2023-38545"
+
+		unpatched := newTestPatchResult()
+		unpatched.manifest.Metadata.OS.Type = "ubuntu"
+		unpatched.errPkgs = nil
+		unpatched.manifest.Updates[0].VulnerabilityID = "CVE-2023-5678"
+
+		doc := getVEX([]patchResult{*res, *unpatched}, "docker.io/library/ubuntu", dgst, created)
+		assert.NotNil(t, doc)
+		assert.Len(t, doc.Statements, 2)
+
+		product := vex.ImageURL("docker.io/library/ubuntu", dgst)
+		assert.Equal(t, "CVE-2023-5678", doc.Statements[0].Vulnerability.Name)
+		assert.Equal(t, vex.StatusUnderInvestigation, doc.Statements[0].Status)
+		assert.Equal(t, product, doc.Statements[0].Products[0].ID)
+		assert.Equal(t, []vex.Component{
+			{ID: "pkg:deb/ubuntu/openssl@3.0.2-0ubuntu1.14?distro=ubuntu-22.04"},
+			{ID: "pkg:deb/ubuntu/openssl@3.0.2-0ubuntu1.10"},
+		}, doc.Statements[0].Products[0].Subcomponents)
+
+		assert.Equal(t, "CVE-2023-38545", doc.Statements[1].Vulnerability.Name)
+		assert.Equal(t, vex.StatusUnderInvestigation, doc.Statements[1].Status)
+	})
+
+	t.Run("fixed", func(t *testing.T) {
+		res := newTestPatchResult()
+		res.pkgmgr = &fakePackageManager{}
+		res.manifest.Metadata.OS.Type = "ubuntu"
+		res.manifest.Updates[0].VulnerabilityID = "CVE-2023-5678"
+
+		doc := getVEX([]patchResult{*res}, "docker.io/library/ubuntu", dgst, created)
+		assert.NotNil(t, doc)
+		assert.Len(t, doc.Statements, 1)
+		assert.Equal(t, vex.StatusFixed, doc.Statements[0].Status)
+	})
+
+	t.Run("not installed", func(t *testing.T) {
+		res := newTestPatchResult()
+		res.pkgmgr = &fakePackageManager{}
+		res.manifest.Metadata.OS.Type = "ubuntu"
+		res.manifest.Updates = append(res.manifest.Updates,
+			types.UpdatePackage{Name: "zlib1g", InstalledVersion: "1:1.2.11.dfsg-2ubuntu9", UpdatedVersion: "1:1.2.11.dfsg-2ubuntu9.2", VulnerabilityID: "CVE-2022-37434"})
+
+		doc := getVEX([]patchResult{*res}, "docker.io/library/ubuntu", dgst, created)
+		assert.NotNil(t, doc)
+		assert.Len(t, doc.Statements, 1)
+		assert.Equal(t, vex.StatusUnderInvestigation, doc.Statements[0].Status)
+		assert.Equal(t, "pkg:deb/ubuntu/zlib1g@1:1.2.11.dfsg-2ubuntu9", doc.Statements[0].Products[0].Subcomponents[0].ID)
+	})
+
+	t.Run("below updated version", func(t *testing.T) {
+		res := newTestPatchResult()
+		res.pkgmgr = &fakePackageManager{}
+		res.errPkgs = nil
+		res.manifest.Metadata.OS.Type = "ubuntu"
+		res.manifest.Updates[1].VulnerabilityID = "CVE-2023-38545"
+
+		doc := getVEX([]patchResult{*res}, "docker.io/library/ubuntu", dgst, created)
+		assert.NotNil(t, doc)
+		assert.Equal(t, vex.StatusUnderInvestigation, doc.Statements[0].Status)
+	})
+}
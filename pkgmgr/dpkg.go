@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/craftslab/copatcher/buildkit"
 	"github.com/craftslab/copatcher/types"
@@ -14,6 +16,9 @@ import (
 	"github.com/hashicorp/go-multierror"
 	debVer "github.com/knqyf263/go-deb-version"
 	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/moby/buildkit/util/apicaps"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 )
 
@@ -26,13 +31,24 @@ const (
 
 	fileMode = 0o744
 	kvLen    = 2
+
+	// statusdManifest lists the packages in status.d of distroless images probed.
+	statusdManifest = "status.d.manifest"
+
+	// cacheBustEnv keys the cache of apt update to the patch instead of ignoring it for each solve.
+	cacheBustEnv = "COPATCHER_CACHE_BUST"
 )
 
 type dpkgManager struct {
 	config        *buildkit.Config
 	workingFolder string
 	isDistroless  bool
+	isPatched     bool
 	statusdNames  string
+	cacheBust     string
+	toolImage     string
+	installed     map[string]string
+	packages      map[string]string
 }
 
 type dpkgStatusType uint
@@ -74,13 +90,20 @@ func isLessThanDebianVersion(v1, v2 string) bool {
 }
 
 // Map the target image OSType & OSVersion to an appropriate tooling image.
-func getAPTImageName(manifest *types.UpdateManifest) string {
+// getAPTImageName returns the tooling image of the os, whose repository may be overridden for the os type,
+// e.g. registry.example.com/library/ubuntu for ubuntu.
+func getAPTImageName(manifest *types.UpdateManifest, toolImages map[string]string) string {
 	version := manifest.Metadata.OS.Version
 	if manifest.Metadata.OS.Type == debianOS {
 		version = strings.Split(manifest.Metadata.OS.Version, ".")[0] + "-slim"
 	}
 
-	return fmt.Sprintf("%s:%s", manifest.Metadata.OS.Type, version)
+	repo := manifest.Metadata.OS.Type
+	if r, ok := toolImages[repo]; ok && r != "" {
+		repo = r
+	}
+
+	return fmt.Sprintf("%s:%s", repo, version)
 }
 
 func getDPKGStatusType(dir string) dpkgStatusType {
@@ -116,7 +139,9 @@ func (dm *dpkgManager) InstallUpdates(ctx context.Context, manifest *types.Updat
 	}
 
 	// Probe for additional information to execute the appropriate update install graphs
-	toolImageName := getAPTImageName(manifest)
+	toolImageName := getAPTImageName(manifest, dm.config.ToolImages)
+	dm.toolImage = toolImageName
+
 	if e := dm.probeDPKGStatus(ctx, toolImageName); e != nil {
 		return nil, nil, errors.Wrap(e, "failed to probe dpkg status")
 	}
@@ -134,12 +159,33 @@ func (dm *dpkgManager) InstallUpdates(ctx context.Context, manifest *types.Updat
 		}
 	}
 
+	dm.isPatched = true
+
 	// Validate that the deployed packages are of the requested version or better
 	resultManifestPath := filepath.Join(dm.workingFolder, resultsPath, resultManifest)
 
-	errPkgs, err := validateDebianPackageVersions(updates, debComparer, resultManifestPath, ignoreErrors)
+	errPkgs, validateErr := validateDebianPackageVersions(updates, debComparer, resultManifestPath, ignoreErrors)
+
+	// The results manifest of regular images lists every package in the dpkg status
+	versions, err := dpkgParseResultsManifest(resultManifestPath)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to validate debian package versions")
+		return nil, nil, errors.Wrap(err, "failed to parse results manifest")
+	}
+
+	dm.installed = map[string]string{}
+	for _, u := range updates {
+		if v, ok := versions[u.Name]; ok {
+			dm.installed[u.Name] = v
+		}
+	}
+
+	// The failed packages are returned with the error to report them
+	if validateErr != nil {
+		return nil, errPkgs, errors.Wrap(validateErr, "failed to validate debian package versions")
+	}
+
+	if dm.packages, err = dm.getPatchedPackages(versions); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get patched packages")
 	}
 
 	return updatedImageState, errPkgs, nil
@@ -155,22 +201,21 @@ func (dm *dpkgManager) probeDPKGStatus(ctx context.Context, toolImage string) er
 		llb.ResolveModeDefault,
 	)
 
-	updated := toolingBase.Run(
-		llb.Shlex("apt update"),
-		llb.WithProxy(utils.GetProxy()),
-		llb.IgnoreCache,
-	).Root()
+	updated := dm.aptUpdate(toolingBase)
 
 	const installBusyBoxCmd = "apt install busybox-static"
 	busyBoxInstalled := updated.Run(llb.Shlex(installBusyBoxCmd), llb.WithProxy(utils.GetProxy())).Root()
 	busyBoxApplied := dm.config.ImageState.File(llb.Copy(busyBoxInstalled, "/bin/busybox", "/bin/busybox"))
 	mkFolders := busyBoxApplied.File(llb.Mkdir(resultsPath, fileMode, llb.WithParents(true)))
 
-	const probeTemplate = `/bin/busybox sh -c "if [ -f %[1]s ]; then cp %[1]s %[3]s ; fi && if [ -d %[2]s ]; then ls -1 %[2]s > %[4]s ; fi"`
-	probeCmd := fmt.Sprintf(probeTemplate, dpkgStatusPath, dpkgStatusFolder, resultsPath, filepath.Join(resultsPath, "status.d"))
+	// The name and version of the packages in status.d are kept for the untouched packages of distroless images
+	const probeTemplate = `/bin/busybox sh -c "if [ -f %[1]s ]; then cp %[1]s %[3]s ; fi && if [ -d %[2]s ]; then ls -1 %[2]s > %[4]s ;` +
+		` /bin/busybox grep -h -e ^Package: -e ^Version: %[2]s/* > %[5]s || true ; fi"`
+	probeCmd := fmt.Sprintf(probeTemplate, dpkgStatusPath, dpkgStatusFolder, resultsPath,
+		filepath.Join(resultsPath, "status.d"), filepath.Join(resultsPath, statusdManifest))
 	probed := mkFolders.Run(llb.Shlex(probeCmd)).Root()
 	outState := llb.Diff(busyBoxApplied, probed)
-	if err := buildkit.SolveToLocal(ctx, dm.config.Client, &outState, dm.workingFolder); err != nil {
+	if err := dm.solveToLocal(ctx, utils.PhaseProbe, true, &outState); err != nil {
 		return err
 	}
 
@@ -205,16 +250,14 @@ func (dm *dpkgManager) installUpdates(ctx context.Context, updates types.UpdateP
 	// Since this takes place in the target container, it can interfere with install actions
 	// such as the installation of the updated debian-archive-keyring package, so it's probably best
 	// to separate it out to an explicit container edit command or opt-in before patching.
-	aptUpdated := dm.config.ImageState.Run(
-		llb.Shlex("apt update"),
-		llb.WithProxy(utils.GetProxy()),
-		llb.IgnoreCache,
-	).Root()
+	aptUpdated := dm.aptUpdate(dm.config.ImageState)
 
 	// Install all requested update packages without specifying the version. This works around:
 	//  - Reports being slightly out of date, where a newer security revision has displaced the one specified leading to not found errors.
 	//  - Reports not specifying version epochs correct (e.g. bsdutils=2.36.1-8+deb11u1 instead of with epoch as 1:2.36.1-8+dev11u1)
 	// Note that this keeps the log files from the operation, which we can consider removing as a size optimization in the future.
+	// The packages are downloaded first so that the network bound fetch is retried apart from the install.
+	const aptDownloadTemplate = `apt install --download-only --no-install-recommends --allow-change-held-packages -y %s`
 	const aptInstallTemplate = `sh -c "apt install --no-install-recommends --allow-change-held-packages -y %s && apt clean -y"`
 	pkgStrings := []string{}
 
@@ -222,8 +265,19 @@ func (dm *dpkgManager) installUpdates(ctx context.Context, updates types.UpdateP
 		pkgStrings = append(pkgStrings, u.Name)
 	}
 
+	downloadCmd := fmt.Sprintf(aptDownloadTemplate, strings.Join(pkgStrings, " "))
+	aptDownloaded := aptUpdated.Run(llb.Shlex(downloadCmd), llb.WithProxy(utils.GetProxy())).Root()
+
+	if err := dm.solve(ctx, utils.PhaseFetch, true, &aptDownloaded); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch updates")
+	}
+
 	installCmd := fmt.Sprintf(aptInstallTemplate, strings.Join(pkgStrings, " "))
-	aptInstalled := aptUpdated.Run(llb.Shlex(installCmd), llb.WithProxy(utils.GetProxy())).Root()
+	aptInstalled := aptDownloaded.Run(llb.Shlex(installCmd), llb.WithProxy(utils.GetProxy())).Root()
+
+	if err := dm.solve(ctx, utils.PhaseInstall, false, &aptInstalled); err != nil {
+		return nil, errors.Wrap(err, "failed to install updates")
+	}
 
 	// Write results.manifest to host for post-patch validation
 	const outputResultsTemplate = `sh -c 'grep "^Package:\|^Version:" "%s" >> "%s"'`
@@ -231,7 +285,7 @@ func (dm *dpkgManager) installUpdates(ctx context.Context, updates types.UpdateP
 	resultsWritten := aptInstalled.Dir(resultsPath).Run(llb.Shlex(outputResultsCmd)).Root()
 	resultsDiff := llb.Diff(aptInstalled, resultsWritten)
 
-	if err := buildkit.SolveToLocal(ctx, dm.config.Client, &resultsDiff, dm.workingFolder); err != nil {
+	if err := dm.solveToLocal(ctx, utils.PhaseValidate, false, &resultsDiff); err != nil {
 		return nil, errors.Wrap(err, "failed to solve to local")
 	}
 
@@ -252,11 +306,7 @@ func (dm *dpkgManager) unpackAndMergeUpdates(ctx context.Context, updates types.
 	)
 
 	// Run apt update && apt download list of updates to target folder
-	updated := toolingBase.Run(
-		llb.Shlex("apt update"),
-		llb.WithProxy(utils.GetProxy()),
-		llb.IgnoreCache,
-	).Root()
+	updated := dm.aptUpdate(toolingBase)
 
 	// Download all requested update packages without specifying the version. This works around:
 	//  - Reports being slightly out of date, where a newer security revision has displaced the one specified leading to not found errors.
@@ -271,12 +321,20 @@ func (dm *dpkgManager) unpackAndMergeUpdates(ctx context.Context, updates types.
 	downloadCmd := fmt.Sprintf(aptDownloadTemplate, strings.Join(pkgStrings, " "))
 	downloaded := updated.Dir(downloadPath).Run(llb.Shlex(downloadCmd), llb.WithProxy(utils.GetProxy())).Root()
 
+	if err := dm.solve(ctx, utils.PhaseFetch, true, &downloaded); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch updates")
+	}
+
 	// Scripted enumeration and dpkg unpack of all downloaded packages [layer to merge with target]
 	const extractTemplate = `find %s -name '*.deb' -exec dpkg-deb -x '{}' %s \;`
 	extractCmd := fmt.Sprintf(extractTemplate, downloadPath, unpackPath)
 	unpacked := downloaded.Run(llb.Shlex(extractCmd)).Root()
 	unpackedToRoot := llb.Scratch().File(llb.Copy(unpacked, unpackPath, "/", &llb.CopyInfo{CopyDirContentsOnly: true}))
 
+	if err := dm.solve(ctx, utils.PhaseInstall, false, &unpackedToRoot); err != nil {
+		return nil, errors.Wrap(err, "failed to unpack updates")
+	}
+
 	// Scripted extraction of all debinfo for version checking to separate layer into local mount
 	// Note that target dirs of shell commands need to be created before use
 	mkFolders := downloaded.File(llb.Mkdir(resultsPath, fileMode, llb.WithParents(true))).File(llb.Mkdir(dpkgStatusFolder, fileMode, llb.WithParents(true)))
@@ -289,7 +347,7 @@ func (dm *dpkgManager) unpackAndMergeUpdates(ctx context.Context, updates types.
 	outputResultsCmd := fmt.Sprintf(outputResultsTemplate, resultManifest)
 	resultsWritten := fieldsWritten.Dir(resultsPath).Run(llb.Shlex(outputResultsCmd)).Root()
 	resultsDiff := llb.Diff(fieldsWritten, resultsWritten)
-	if err := buildkit.SolveToLocal(ctx, dm.config.Client, &resultsDiff, dm.workingFolder); err != nil {
+	if err := dm.solveToLocal(ctx, utils.PhaseValidate, false, &resultsDiff); err != nil {
 		return nil, errors.Wrap(err, "failed to solve to local")
 	}
 
@@ -328,10 +386,98 @@ func (dm *dpkgManager) unpackAndMergeUpdates(ctx context.Context, updates types.
 	return &merged, nil
 }
 
+// aptUpdate runs apt update on the state, which is cached within the patch only to get the latest package lists
+// once for all its solves.
+func (dm *dpkgManager) aptUpdate(st llb.State) llb.State {
+	if dm.cacheBust == "" {
+		dm.cacheBust = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+
+	return st.Run(
+		llb.Shlex("apt update"),
+		llb.WithProxy(utils.GetProxy()),
+		llb.AddEnv(cacheBustEnv, dm.cacheBust),
+	).Root()
+}
+
+// solve solves the state in the phase, whose result is reused by the next solves.
+func (dm *dpkgManager) solve(ctx context.Context, phase utils.Phase, retry bool, st *llb.State) error {
+	return dm.config.Phases.Run(ctx, phase, retry, func(ctx context.Context) error {
+		return buildkit.Solve(ctx, dm.config.Client, st)
+	})
+}
+
+// solveToLocal solves the state in the phase into the working folder.
+func (dm *dpkgManager) solveToLocal(ctx context.Context, phase utils.Phase, retry bool, st *llb.State) error {
+	return dm.config.Phases.Run(ctx, phase, retry, func(ctx context.Context) error {
+		return buildkit.SolveToLocal(ctx, dm.config.Client, st, dm.workingFolder)
+	})
+}
+
 func (dm *dpkgManager) GetPackageType() string {
 	return "deb"
 }
 
+// GetHistory returns the apt install layer for regular images, or the unpacked packages
+// and status.d layers for distroless images, as merged by InstallUpdates.
+func (dm *dpkgManager) GetHistory(created time.Time, updates types.UpdatePackages) []ispec.History {
+	if !dm.isPatched {
+		return nil
+	}
+
+	if !dm.isDistroless {
+		return []ispec.History{getHistory(created, dm.GetPackageType(), "apt install", updates)}
+	}
+
+	return []ispec.History{
+		getHistory(created, dm.GetPackageType(), "dpkg-deb -x", updates),
+		getHistory(created, dm.GetPackageType(), "update "+dpkgStatusFolder, updates),
+	}
+}
+
+// GetToolImages returns the apt image used to probe, download or install the updates.
+func (dm *dpkgManager) GetToolImages() []string {
+	if dm.toolImage == "" {
+		return nil
+	}
+
+	return []string{dm.toolImage}
+}
+
+func (dm *dpkgManager) GetInstalledVersions() map[string]string {
+	return dm.installed
+}
+
+// GetPackages returns the packages of the patched image, which are listed in the dpkg status of regular images.
+func (dm *dpkgManager) GetPackages() map[string]string {
+	return dm.packages
+}
+
+// getPatchedPackages returns the packages of the patched image given the versions of the results manifest,
+// which only lists the updated packages of distroless images on top of their probed status.d packages.
+func (dm *dpkgManager) getPatchedPackages(versions map[string]string) (map[string]string, error) {
+	if !dm.isDistroless {
+		return versions, nil
+	}
+
+	out, err := dpkgParseResultsManifest(filepath.Join(dm.workingFolder, resultsPath, statusdManifest))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse status.d manifest")
+	}
+
+	for k, v := range versions {
+		out[k] = v
+	}
+
+	return out, nil
+}
+
+// Both the regular and distroless paths diff and merge the patch layer into the target image,
+// and the distroless path additionally copies the unpacked packages and status files with file ops.
+func (dm *dpkgManager) GetRequiredCaps() []apicaps.CapID {
+	return []apicaps.CapID{pb.CapMergeOp, pb.CapDiffOp, pb.CapFileBase}
+}
+
 func dpkgParseResultsManifest(path string) (map[string]string, error) {
 	// Open result file
 	f, err := os.Open(path)
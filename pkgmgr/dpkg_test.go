@@ -8,7 +8,9 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/moby/buildkit/solver/pb"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/craftslab/copatcher/buildkit"
@@ -102,9 +104,10 @@ func TestIsLessThanDebianVersion(t *testing.T) {
 func TestGetAPTImageName(t *testing.T) {
 	// Define test cases with input and expected output
 	testCases := []struct {
-		name     string
-		manifest *types.UpdateManifest
-		want     string
+		name       string
+		manifest   *types.UpdateManifest
+		toolImages map[string]string
+		want       string
 	}{
 		{
 			name: "ubuntu 20.04",
@@ -142,12 +145,25 @@ func TestGetAPTImageName(t *testing.T) {
 			},
 			want: "debian:11-slim",
 		},
+		{
+			name: "ubuntu 22.04 with tooling image override",
+			manifest: &types.UpdateManifest{
+				Metadata: types.Metadata{
+					OS: types.OS{
+						Type:    "ubuntu",
+						Version: "22.04",
+					},
+				},
+			},
+			toolImages: map[string]string{"ubuntu": "registry.example.com/library/ubuntu"},
+			want:       "registry.example.com/library/ubuntu:22.04",
+		},
 	}
 
 	// Run test cases
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			got := getAPTImageName(tc.manifest)
+			got := getAPTImageName(tc.manifest, tc.toolImages)
 			if got != tc.want {
 				t.Errorf("getAPTImageName() = %v, want %v", got, tc.want)
 			}
@@ -436,3 +452,79 @@ func Test_dpkgManager_GetPackageType(t *testing.T) {
 		})
 	}
 }
+
+func TestGetRequiredCaps(t *testing.T) {
+	dm := &dpkgManager{}
+	caps := dm.GetRequiredCaps()
+
+	assert.Contains(t, caps, pb.CapMergeOp)
+	assert.Contains(t, caps, pb.CapDiffOp)
+	assert.Contains(t, caps, pb.CapFileBase)
+}
+
+func TestGetHistory(t *testing.T) {
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	updates := types.UpdatePackages{
+		{Name: "openssl", InstalledVersion: "3.0.2-0ubuntu1.10", UpdatedVersion: "3.0.2-0ubuntu1.12"},
+		{Name: "curl", InstalledVersion: "7.81.0-1ubuntu1.13", UpdatedVersion: "7.81.0-1ubuntu1.15"},
+	}
+
+	t.Run("not patched", func(t *testing.T) {
+		dm := &dpkgManager{}
+		assert.Nil(t, dm.GetHistory(created, updates))
+	})
+
+	t.Run("regular image", func(t *testing.T) {
+		dm := &dpkgManager{isPatched: true}
+		history := dm.GetHistory(created, updates)
+		assert.Len(t, history, 1)
+		assert.Equal(t, "apt install (deb): openssl=3.0.2-0ubuntu1.12 curl=7.81.0-1ubuntu1.15", history[0].CreatedBy)
+		assert.Equal(t, created, *history[0].Created)
+		assert.False(t, history[0].EmptyLayer)
+	})
+
+	t.Run("distroless image", func(t *testing.T) {
+		dm := &dpkgManager{isPatched: true, isDistroless: true}
+		history := dm.GetHistory(created, updates)
+		assert.Len(t, history, 2)
+		assert.Equal(t, "dpkg-deb -x (deb): openssl=3.0.2-0ubuntu1.12 curl=7.81.0-1ubuntu1.15", history[0].CreatedBy)
+		assert.Equal(t, "update /var/lib/dpkg/status.d (deb): openssl=3.0.2-0ubuntu1.12 curl=7.81.0-1ubuntu1.15", history[1].CreatedBy)
+	})
+}
+
+func TestGetToolImages(t *testing.T) {
+	dm := &dpkgManager{}
+	assert.Nil(t, dm.GetToolImages())
+
+	dm.toolImage = "debian:11-slim"
+	assert.Equal(t, []string{"debian:11-slim"}, dm.GetToolImages())
+}
+
+func TestGetPatchedPackages(t *testing.T) {
+	versions := map[string]string{"libssl1.1": "1.1.1n-0+deb11u5"}
+
+	t.Run("regular image", func(t *testing.T) {
+		dm := &dpkgManager{}
+		packages, err := dm.getPatchedPackages(versions)
+		assert.NoError(t, err)
+		assert.Equal(t, versions, packages)
+	})
+
+	t.Run("distroless image", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, resultsPath), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, resultsPath, statusdManifest),
+			[]byte("Package: base-files\nVersion: 11.1+deb11u7\nPackage: libssl1.1\nVersion: 1.1.1n-0+deb11u4\n"), 0o644))
+
+		dm := &dpkgManager{isDistroless: true, workingFolder: dir}
+		packages, err := dm.getPatchedPackages(versions)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"base-files": "11.1+deb11u7", "libssl1.1": "1.1.1n-0+deb11u5"}, packages)
+	})
+
+	t.Run("missing status.d manifest", func(t *testing.T) {
+		dm := &dpkgManager{isDistroless: true, workingFolder: t.TempDir()}
+		_, err := dm.getPatchedPackages(versions)
+		assert.Error(t, err)
+	})
+}
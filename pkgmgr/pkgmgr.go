@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/util/apicaps"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 
 	"github.com/craftslab/copatcher/buildkit"
@@ -20,11 +24,24 @@ const (
 	downloadPath   = "/" + copaPrefix + "downloads"
 	unpackPath     = "/" + copaPrefix + "unpacked"
 	resultManifest = "results.manifest"
+
+	historyComment = "copatcher"
 )
 
 type PackageManager interface {
+	// InstallUpdates returns the patched image state and the failed packages, which are also returned with the
+	// error if they fail the validation of the installed versions.
 	InstallUpdates(context.Context, *types.UpdateManifest, bool) (*llb.State, []string, error)
 	GetPackageType() string
+	GetRequiredCaps() []apicaps.CapID
+	// GetHistory returns the image history entries of the layers added by InstallUpdates, in order.
+	GetHistory(time.Time, types.UpdatePackages) []ispec.History
+	// GetToolImages returns the tooling images used by InstallUpdates.
+	GetToolImages() []string
+	// GetInstalledVersions returns the versions of the updated packages found in the patched image.
+	GetInstalledVersions() map[string]string
+	// GetPackages returns the name and version of every package in the patched image, nil if unknown.
+	GetPackages() map[string]string
 }
 
 func GetPackageManager(osType string, config *buildkit.Config, workingFolder string) (PackageManager, error) {
@@ -32,7 +49,17 @@ func GetPackageManager(osType string, config *buildkit.Config, workingFolder str
 	case "debian", "ubuntu":
 		return &dpkgManager{config: config, workingFolder: workingFolder}, nil
 	default:
-		return nil, errors.New("unsupported OS type")
+		return nil, types.NewError(types.ErrorKindBadReport, errors.Errorf("unsupported OS type %s", osType))
+	}
+}
+
+// GetVersionComparer returns the comparer of the package versions of the OS type.
+func GetVersionComparer(osType string) (VersionComparer, error) {
+	switch osType {
+	case "debian", "ubuntu":
+		return VersionComparer{isValidDebianVersion, isLessThanDebianVersion}, nil
+	default:
+		return VersionComparer{}, types.NewError(types.ErrorKindBadReport, errors.Errorf("unsupported OS type %s", osType))
 	}
 }
 
@@ -64,7 +91,7 @@ func GetUniqueLatestUpdates(updates types.UpdatePackages, cmp VersionComparer, i
 	}
 
 	if allErrors != nil && !ignoreErrors {
-		return types.UpdatePackages{}, allErrors.ErrorOrNil()
+		return types.UpdatePackages{}, types.NewError(types.ErrorKindBadReport, allErrors.ErrorOrNil())
 	}
 
 	out := types.UpdatePackages{}
@@ -141,3 +168,17 @@ func GetValidatedUpdatesMap(updates types.UpdatePackages, cmp VersionComparer, r
 
 	return m, nil
 }
+
+// getHistory returns the history entry of a patch layer created by cmd for the updates.
+func getHistory(created time.Time, pkgType, cmd string, updates types.UpdatePackages) ispec.History {
+	pkgs := make([]string, 0, len(updates))
+	for _, u := range updates {
+		pkgs = append(pkgs, fmt.Sprintf("%s=%s", u.Name, u.UpdatedVersion))
+	}
+
+	return ispec.History{
+		Created:   &created,
+		CreatedBy: fmt.Sprintf("%s (%s): %s", cmd, pkgType, strings.Join(pkgs, " ")),
+		Comment:   historyComment,
+	}
+}
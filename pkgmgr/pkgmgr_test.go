@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/craftslab/copatcher/buildkit"
+	"github.com/craftslab/copatcher/types"
 )
 
 // TestGetPackageManager tests the GetPackageManager function.
@@ -42,8 +43,9 @@ func TestGetPackageManager(t *testing.T) {
 		// Call the GetPackageManager function with "unsupported" as osType
 		manager, err := GetPackageManager("unsupported", config, workingFolder)
 
-		// Assert that there is an error and the manager is nil
+		// Assert that there is an error of a bad report and the manager is nil
 		assert.Error(t, err)
+		assert.Equal(t, types.ErrorKindBadReport, types.GetErrorKind(err))
 		assert.Nil(t, manager)
 	})
 }
@@ -0,0 +1,83 @@
+package report
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+
+	"github.com/craftslab/copatcher/types"
+)
+
+const (
+	grypeFixStateFixed = "fixed"
+)
+
+// grypeOSPackageTypes are the artifact types of os packages.
+var grypeOSPackageTypes = []string{"apk", "deb", "rpm"}
+
+type grypeReport struct {
+	Matches *[]struct {
+		Vulnerability struct {
+			ID  string `json:"id"`
+			Fix struct {
+				Versions []string `json:"versions"`
+				State    string   `json:"state"`
+			} `json:"fix"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Type    string `json:"type"`
+		} `json:"artifact"`
+	} `json:"matches"`
+	Source struct {
+		Target json.RawMessage `json:"target"`
+	} `json:"source"`
+	Distro struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"distro"`
+}
+
+// parseGrype parses the json report of grype, keeping the fixed vulnerabilities of os packages.
+func parseGrype(buf []byte) (*types.UpdateManifest, bool, error) {
+	var r grypeReport
+	if err := json.Unmarshal(buf, &r); err != nil || r.Matches == nil {
+		return nil, false, nil
+	}
+
+	if r.Distro.Name == "" {
+		return nil, true, errors.New("grype report has no distro")
+	}
+
+	// The target is a string for directory sources and an object for image sources
+	var target struct {
+		Architecture string `json:"architecture"`
+	}
+
+	_ = json.Unmarshal(r.Source.Target, &target)
+
+	manifest := &types.UpdateManifest{
+		Metadata: types.Metadata{
+			OS:     types.OS{Type: r.Distro.Name, Version: r.Distro.Version},
+			Config: types.Config{Arch: target.Architecture},
+		},
+		Updates: types.UpdatePackages{},
+	}
+
+	for _, m := range *r.Matches {
+		if !slices.Contains(grypeOSPackageTypes, m.Artifact.Type) ||
+			m.Vulnerability.Fix.State != grypeFixStateFixed || len(m.Vulnerability.Fix.Versions) == 0 {
+			continue
+		}
+		manifest.Updates = append(manifest.Updates, types.UpdatePackage{
+			Name:             m.Artifact.Name,
+			InstalledVersion: m.Artifact.Version,
+			UpdatedVersion:   m.Vulnerability.Fix.Versions[0],
+			VulnerabilityID:  m.Vulnerability.ID,
+		})
+	}
+
+	return manifest, true, nil
+}
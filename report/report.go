@@ -2,6 +2,10 @@ package report
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
 
 	"github.com/craftslab/copatcher/config"
 	"github.com/craftslab/copatcher/types"
@@ -21,6 +25,16 @@ type report struct {
 	cfg *Config
 }
 
+// parser converts a report of a scanner into the update manifest, returning false if the report is not of its format.
+type parser func([]byte) (*types.UpdateManifest, bool, error)
+
+// parsers are tried in order, so the more specific formats go first.
+var parsers = []parser{
+	parseTrivy,
+	parseGrype,
+	parseManifest,
+}
+
 func New(_ context.Context, cfg *Config) Report {
 	return &report{
 		cfg: cfg,
@@ -39,13 +53,44 @@ func (r *report) Deinit(_ context.Context) error {
 	return nil
 }
 
+// Run parses the report file, which is either an update manifest or a Trivy or Grype json report.
 func (r *report) Run(_ context.Context, name string) (types.UpdateManifest, error) {
-	buf := types.UpdateManifest{
-		Metadata: types.Metadata{},
-		Updates:  types.UpdatePackages{},
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return types.UpdateManifest{}, errors.Wrap(err, "failed to read report")
 	}
 
-	// TODO: FIXME
+	return Parse(buf)
+}
+
+// Parse parses the report, which is either an update manifest or a Trivy or Grype json report.
+func Parse(buf []byte) (types.UpdateManifest, error) {
+	for _, p := range parsers {
+		manifest, ok, err := p(buf)
+		if err != nil {
+			return types.UpdateManifest{}, errors.Wrap(err, "failed to parse report")
+		}
+		if ok {
+			if manifest.Updates == nil {
+				manifest.Updates = types.UpdatePackages{}
+			}
+			return *manifest, nil
+		}
+	}
+
+	return types.UpdateManifest{}, errors.New("unsupported report format")
+}
+
+// parseManifest parses the update manifest, which is identified by its os type.
+func parseManifest(buf []byte) (*types.UpdateManifest, bool, error) {
+	var manifest types.UpdateManifest
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return nil, false, nil
+	}
+
+	if manifest.Metadata.OS.Type == "" {
+		return nil, false, nil
+	}
 
-	return buf, nil
+	return &manifest, true, nil
 }
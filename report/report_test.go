@@ -1,12 +1,120 @@
 package report
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/types"
 )
 
 func TestReport(t *testing.T) {
-	// TODO: FIXME
-	assert.Equal(t, nil, nil)
+	ctx := context.Background()
+	r := New(ctx, DefaultConfig())
+
+	testCases := []struct {
+		name    string
+		file    string
+		want    types.UpdateManifest
+		wantErr bool
+	}{
+		{
+			name: "manifest",
+			file: "../test/data/manifest.json",
+			want: types.UpdateManifest{
+				Metadata: types.Metadata{
+					OS:     types.OS{Type: "ubuntu", Version: "22.04"},
+					Config: types.Config{Arch: "amd64"},
+				},
+				Updates: types.UpdatePackages{},
+			},
+		},
+		{
+			name: "trivy",
+			file: "../test/data/trivy.json",
+			want: types.UpdateManifest{
+				Metadata: types.Metadata{
+					OS:     types.OS{Type: "ubuntu", Version: "22.04"},
+					Config: types.Config{Arch: "amd64"},
+				},
+				Updates: types.UpdatePackages{
+					{
+						Name:             "libssl3",
+						InstalledVersion: "3.0.2-0ubuntu1.10",
+						UpdatedVersion:   "3.0.2-0ubuntu1.12",
+						VulnerabilityID:  "CVE-2023-5678",
+					},
+					{
+						Name:             "libssl3",
+						InstalledVersion: "3.0.2-0ubuntu1.10",
+						UpdatedVersion:   "3.0.2-0ubuntu1.14",
+						VulnerabilityID:  "CVE-2023-6129",
+					},
+				},
+			},
+		},
+		{
+			name: "grype",
+			file: "../test/data/grype.json",
+			want: types.UpdateManifest{
+				Metadata: types.Metadata{
+					OS:     types.OS{Type: "ubuntu", Version: "22.04"},
+					Config: types.Config{Arch: "amd64"},
+				},
+				Updates: types.UpdatePackages{
+					{
+						Name:             "libssl3",
+						InstalledVersion: "3.0.2-0ubuntu1.10",
+						UpdatedVersion:   "3.0.2-0ubuntu1.12",
+						VulnerabilityID:  "CVE-2023-5678",
+					},
+				},
+			},
+		},
+		{
+			name:    "unsupported",
+			file:    "../test/data/report.json",
+			wantErr: true,
+		},
+		{
+			name:    "invalid",
+			file:    "../test/data/invalid.txt",
+			wantErr: true,
+		},
+		{
+			name:    "missing",
+			file:    "../test/data/missing.json",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := r.Run(ctx, tc.file)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestReportWithoutOS(t *testing.T) {
+	ctx := context.Background()
+	r := New(ctx, DefaultConfig())
+
+	for name, content := range map[string]string{
+		"trivy.json": `{"SchemaVersion": 2, "Metadata": {}, "Results": []}`,
+		"grype.json": `{"matches": [], "distro": {}}`,
+	} {
+		file := filepath.Join(t.TempDir(), name)
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+		_, err := r.Run(ctx, file)
+		assert.Error(t, err, name)
+	}
 }
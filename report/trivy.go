@@ -0,0 +1,76 @@
+package report
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/copatcher/types"
+)
+
+const (
+	trivyClassOSPkgs = "os-pkgs"
+)
+
+type trivyReport struct {
+	SchemaVersion int `json:"SchemaVersion"`
+	Metadata      struct {
+		OS *struct {
+			Family string `json:"Family"`
+			Name   string `json:"Name"`
+		} `json:"OS"`
+		ImageConfig struct {
+			Architecture string `json:"architecture"`
+		} `json:"ImageConfig"`
+	} `json:"Metadata"`
+	Results []struct {
+		Class           string `json:"Class"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// parseTrivy parses the json report of trivy image, keeping the fixable vulnerabilities of os packages.
+func parseTrivy(buf []byte) (*types.UpdateManifest, bool, error) {
+	var r trivyReport
+	if err := json.Unmarshal(buf, &r); err != nil || r.SchemaVersion == 0 {
+		return nil, false, nil
+	}
+
+	if r.Metadata.OS == nil {
+		return nil, true, errors.New("trivy report has no os")
+	}
+
+	manifest := &types.UpdateManifest{
+		Metadata: types.Metadata{
+			OS:     types.OS{Type: r.Metadata.OS.Family, Version: r.Metadata.OS.Name},
+			Config: types.Config{Arch: r.Metadata.ImageConfig.Architecture},
+		},
+		Updates: types.UpdatePackages{},
+	}
+
+	for _, res := range r.Results {
+		if res.Class != trivyClassOSPkgs {
+			continue
+		}
+		for _, v := range res.Vulnerabilities {
+			if v.FixedVersion == "" {
+				continue
+			}
+			manifest.Updates = append(manifest.Updates, types.UpdatePackage{
+				Name:             v.PkgName,
+				InstalledVersion: v.InstalledVersion,
+				// Take the first of the fixed versions, e.g. 1.2.3-1ubuntu1.1, 1.2.3-1ubuntu2
+				UpdatedVersion:  strings.TrimSpace(strings.Split(v.FixedVersion, ",")[0]),
+				VulnerabilityID: v.VulnerabilityID,
+			})
+		}
+	}
+
+	return manifest, true, nil
+}
@@ -0,0 +1,80 @@
+package result
+
+import (
+	"encoding/xml"
+
+	"github.com/pkg/errors"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// generateJUnit maps each platform to a test suite and each package to a test case of it.
+func generateJUnit(doc *Document) ([]byte, error) {
+	out := junitTestSuites{
+		Name:     doc.PatchedImage,
+		Tests:    doc.Summary.Total(),
+		Failures: doc.Summary.Failed,
+		Skipped:  doc.Summary.Skipped,
+	}
+
+	for i := range doc.Platforms {
+		p := &doc.Platforms[i]
+		suite := junitTestSuite{
+			Name:      p.Platform,
+			Tests:     len(p.Packages),
+			Timestamp: doc.Created,
+		}
+		for _, pkg := range p.Packages {
+			c := junitTestCase{
+				Name:      pkg.Name,
+				ClassName: p.Platform,
+				SystemOut: "requested " + pkg.RequestedVersion + ", installed " + pkg.InstalledVersion + ": " + pkg.Status,
+			}
+			switch pkg.Status {
+			case StatusFailed:
+				c.Failure = &junitMessage{Message: pkg.Error}
+				suite.Failures++
+			case StatusSkipped:
+				c.Skipped = &junitMessage{Message: pkg.Error}
+				suite.Skipped++
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		out.Suites = append(out.Suites, suite)
+	}
+
+	buf, err := xml.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal junit")
+	}
+
+	return append([]byte(xml.Header), buf...), nil
+}
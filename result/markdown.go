@@ -0,0 +1,41 @@
+package result
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+func generateMarkdown(doc *Document) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "## Patch result of `%s`\n\n", doc.Image)
+	fmt.Fprintf(&buf, "Patched image: `%s`", doc.PatchedImage)
+	if doc.Digest != "" {
+		fmt.Fprintf(&buf, " (`%s`)", doc.Digest)
+	}
+	buf.WriteString("\n\n")
+	fmt.Fprintf(&buf, "%d patched, %d failed, %d skipped, %d already current\n",
+		doc.Summary.Patched, doc.Summary.Failed, doc.Summary.Skipped, doc.Summary.Current)
+
+	for i := range doc.Platforms {
+		p := &doc.Platforms[i]
+		fmt.Fprintf(&buf, "\n### %s\n\n", p.Platform)
+		if p.Error != "" {
+			fmt.Fprintf(&buf, "> %s\n\n", escapeMarkdown(p.Error))
+		}
+		buf.WriteString("| Package | Requested | Installed | Status | Error |\n")
+		buf.WriteString("|---------|-----------|-----------|--------|-------|\n")
+		for _, pkg := range p.Packages {
+			fmt.Fprintf(&buf, "| %s | %s | %s | %s | %s |\n", escapeMarkdown(pkg.Name), escapeMarkdown(pkg.RequestedVersion),
+				escapeMarkdown(pkg.InstalledVersion), pkg.Status, escapeMarkdown(pkg.Error))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// escapeMarkdown keeps the text on a single table cell.
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer("|", "\\|", "\r", " ", "\n", " ").Replace(s)
+}
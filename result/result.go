@@ -0,0 +1,105 @@
+package result
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Formats supported by Generate.
+const (
+	FormatJSON     = "json"
+	FormatJUnit    = "junit"
+	FormatMarkdown = "markdown"
+)
+
+// Statuses of the packages.
+const (
+	StatusPatched = "patched"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+	StatusCurrent = "current"
+)
+
+// Document is the result of patching an image.
+type Document struct {
+	Image        string     `json:"image"`
+	PatchedImage string     `json:"patchedImage"`
+	Digest       string     `json:"digest,omitempty"`
+	Created      string     `json:"created"`
+	Summary      Summary    `json:"summary"`
+	Platforms    []Platform `json:"platforms"`
+}
+
+// Summary counts the packages of the document by status.
+type Summary struct {
+	Patched int `json:"patched"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+	Current int `json:"current"`
+}
+
+// Platform is the result of patching the image of a platform.
+type Platform struct {
+	Platform    string    `json:"platform"`
+	OS          string    `json:"os,omitempty"`
+	PackageType string    `json:"packageType,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Packages    []Package `json:"packages"`
+	// ReportDigest is the digest of the report of the platform, which identifies the report after it is removed.
+	ReportDigest string `json:"reportDigest,omitempty"`
+}
+
+// Package is the result of updating a package of the report.
+type Package struct {
+	Name             string `json:"name"`
+	RequestedVersion string `json:"requestedVersion"`
+	InstalledVersion string `json:"installedVersion,omitempty"`
+	Status           string `json:"status"`
+	Error            string `json:"error,omitempty"`
+	// Vulnerabilities are the ids of the vulnerabilities of the package in the report.
+	Vulnerabilities []string `json:"vulnerabilities,omitempty"`
+}
+
+// Total returns the number of packages.
+func (s *Summary) Total() int {
+	return s.Patched + s.Failed + s.Skipped + s.Current
+}
+
+// Update counts the packages of the platforms into the summary.
+func (d *Document) Update() {
+	d.Summary = Summary{}
+
+	for i := range d.Platforms {
+		for _, p := range d.Platforms[i].Packages {
+			switch p.Status {
+			case StatusPatched:
+				d.Summary.Patched++
+			case StatusFailed:
+				d.Summary.Failed++
+			case StatusSkipped:
+				d.Summary.Skipped++
+			case StatusCurrent:
+				d.Summary.Current++
+			}
+		}
+	}
+}
+
+// Generate returns the document encoded in the format.
+func Generate(format string, doc *Document) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		buf, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal result")
+		}
+		return buf, nil
+	case FormatJUnit:
+		return generateJUnit(doc)
+	case FormatMarkdown:
+		return generateMarkdown(doc), nil
+	default:
+		return nil, errors.Errorf("unsupported result format %s", format)
+	}
+}
@@ -0,0 +1,96 @@
+package result
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDocument() *Document {
+	doc := &Document{
+		Image:        "ubuntu:22.04",
+		PatchedImage: "docker.io/library/ubuntu:22.04-patched",
+		Digest:       "sha256:0123",
+		Created:      "2024-03-01T08:00:00Z",
+		Platforms: []Platform{
+			{
+				Platform: "linux/amd64",
+				OS:       "ubuntu",
+				Packages: []Package{
+					{Name: "openssl", RequestedVersion: "3.0.2-0ubuntu1.12", InstalledVersion: "3.0.2-0ubuntu1.14", Status: StatusPatched},
+					{Name: "curl", RequestedVersion: "7.81.0-1ubuntu1.15", InstalledVersion: "7.81.0-1ubuntu1.13", Status: StatusFailed,
+						Error: "installed version 7.81.0-1ubuntu1.13 does not satisfy requested version 7.81.0-1ubuntu1.15"},
+				},
+			},
+			{
+				Platform: "linux/arm64",
+				OS:       "ubuntu",
+				Error:    "failed to install updates",
+				Packages: []Package{
+					{Name: "openssl", RequestedVersion: "3.0.2-0ubuntu1.12", Status: StatusSkipped, Error: "platform not patched"},
+					{Name: "zlib1g", RequestedVersion: "1:1.2.11.dfsg-2ubuntu9.2", InstalledVersion: "1:1.2.11.dfsg-2ubuntu9.2", Status: StatusCurrent},
+				},
+			},
+		},
+	}
+
+	doc.Update()
+
+	return doc
+}
+
+func TestUpdate(t *testing.T) {
+	doc := newTestDocument()
+
+	assert.Equal(t, Summary{Patched: 1, Failed: 1, Skipped: 1, Current: 1}, doc.Summary)
+	assert.Equal(t, 4, doc.Summary.Total())
+}
+
+func TestGenerate(t *testing.T) {
+	doc := newTestDocument()
+
+	t.Run("json", func(t *testing.T) {
+		buf, err := Generate(FormatJSON, doc)
+		assert.NoError(t, err)
+
+		var out Document
+		assert.NoError(t, json.Unmarshal(buf, &out))
+		assert.Equal(t, *doc, out)
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		buf, err := Generate(FormatMarkdown, doc)
+		assert.NoError(t, err)
+		assert.Contains(t, string(buf), "## Patch result of `ubuntu:22.04`")
+		assert.Contains(t, string(buf), "1 patched, 1 failed, 1 skipped, 1 already current")
+		assert.Contains(t, string(buf), "### linux/arm64\n\n> failed to install updates")
+		assert.Contains(t, string(buf), "| openssl | 3.0.2-0ubuntu1.12 | 3.0.2-0ubuntu1.14 | patched |  |")
+	})
+
+	t.Run("junit", func(t *testing.T) {
+		buf, err := Generate(FormatJUnit, doc)
+		assert.NoError(t, err)
+
+		var out junitTestSuites
+		assert.NoError(t, xml.Unmarshal(buf, &out))
+		assert.Equal(t, 4, out.Tests)
+		assert.Equal(t, 1, out.Failures)
+		assert.Equal(t, 1, out.Skipped)
+		assert.Len(t, out.Suites, 2)
+		assert.Equal(t, "linux/amd64", out.Suites[0].Name)
+		assert.Nil(t, out.Suites[0].Cases[0].Failure)
+		assert.Contains(t, out.Suites[0].Cases[1].Failure.Message, "does not satisfy")
+		assert.Equal(t, "platform not patched", out.Suites[1].Cases[0].Skipped.Message)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := Generate("html", doc)
+		assert.Error(t, err)
+	})
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	assert.Equal(t, "a \\| b c", escapeMarkdown("a | b\nc"))
+}
@@ -0,0 +1,77 @@
+package sbom
+
+import (
+	"time"
+)
+
+const (
+	cycloneDXFormat  = "CycloneDX"
+	cycloneDXVersion = "1.5"
+)
+
+type cycloneDX struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     []cycloneDXTool    `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type cycloneDXComponent struct {
+	BOMRef  string `json:"bom-ref,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	PURL    string `json:"purl,omitempty"`
+}
+
+func newCycloneDX(doc *Document) *cycloneDX {
+	id := getDocumentID(doc)
+
+	bom := &cycloneDX{
+		BOMFormat: cycloneDXFormat,
+		// The serial number has to be an uuid urn
+		SerialNumber: "urn:uuid:" + id[0:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:32],
+		SpecVersion:  cycloneDXVersion,
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: doc.Created.UTC().Format(time.RFC3339),
+			Tools: []cycloneDXTool{
+				{Vendor: toolVendor, Name: toolName, Version: doc.Version},
+			},
+			Component: cycloneDXComponent{
+				BOMRef: doc.Name,
+				Type:   "container",
+				Name:   doc.Name,
+			},
+		},
+		Components: []cycloneDXComponent{},
+	}
+
+	for i := range doc.Packages {
+		p := &doc.Packages[i]
+		purl := getPackageURL(doc, p)
+		bom.Components = append(bom.Components, cycloneDXComponent{
+			BOMRef:  purl,
+			Type:    "library",
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    purl,
+		})
+	}
+
+	return bom
+}
@@ -0,0 +1,132 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// Formats supported by Generate.
+const (
+	FormatCycloneDX = "cyclonedx"
+	FormatSPDX      = "spdx"
+)
+
+// Predicate types of the in-toto attestations of the formats.
+const (
+	PredicateCycloneDX = "https://cyclonedx.org/bom"
+	PredicateSPDX      = "https://spdx.dev/Document"
+)
+
+const (
+	toolName   = "copatcher"
+	toolVendor = "craftslab"
+)
+
+// Package is a package installed in the image.
+type Package struct {
+	Name    string
+	Version string
+	// Type is the purl type of the package, e.g. deb.
+	Type string
+}
+
+// Document describes the packages of the image.
+type Document struct {
+	// Name is the reference of the image.
+	Name      string
+	OSType    string
+	OSVersion string
+	Packages  []Package
+	Created   time.Time
+	Version   string
+}
+
+// NewPackages returns the packages of the type sorted by name.
+func NewPackages(pkgType string, versions map[string]string) []Package {
+	out := make([]Package, 0, len(versions))
+
+	for name, version := range versions {
+		out = append(out, Package{Name: name, Version: version, Type: pkgType})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// Generate returns the document encoded in the format.
+func Generate(format string, doc *Document) ([]byte, error) {
+	var v any
+
+	switch format {
+	case FormatCycloneDX:
+		v = newCycloneDX(doc)
+	case FormatSPDX:
+		v = newSPDX(doc)
+	default:
+		return nil, errors.Errorf("unsupported sbom format %s", format)
+	}
+
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal sbom")
+	}
+
+	return buf, nil
+}
+
+// GetPredicateType returns the predicate type of the in-toto attestation of the format.
+func GetPredicateType(format string) (string, error) {
+	switch format {
+	case FormatCycloneDX:
+		return PredicateCycloneDX, nil
+	case FormatSPDX:
+		return PredicateSPDX, nil
+	default:
+		return "", errors.Errorf("unsupported sbom format %s", format)
+	}
+}
+
+// PackageURL returns the package url of the package in the os,
+// e.g. pkg:deb/ubuntu/openssl@3.0.2-0ubuntu1.12?distro=ubuntu-22.04.
+func PackageURL(osType, osVersion string, p *Package) string {
+	out := fmt.Sprintf("pkg:%s/%s/%s@%s", p.Type, osType, url.PathEscape(p.Name), url.PathEscape(p.Version))
+
+	if osVersion != "" {
+		out += "?distro=" + url.QueryEscape(osType+"-"+osVersion)
+	}
+
+	return out
+}
+
+func getPackageURL(doc *Document, p *Package) string {
+	return PackageURL(doc.OSType, doc.OSVersion, p)
+}
+
+// getDocumentID returns a stable id of the document, which is unique for the image and creation time.
+func getDocumentID(doc *Document) string {
+	return digest.FromString(doc.Name + "@" + doc.Created.UTC().Format(time.RFC3339Nano)).Encoded()
+}
+
+// getSPDXID returns the spdx identifier of the package, which only allows letters, numbers, "." and "-".
+func getSPDXID(p *Package) string {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '-'
+		}
+	}, p.Name+"-"+p.Version)
+
+	return "SPDXRef-Package-" + p.Type + "-" + id
+}
@@ -0,0 +1,77 @@
+package sbom
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDocument() *Document {
+	return &Document{
+		Name:      "docker.io/library/ubuntu:22.04-patched",
+		OSType:    "ubuntu",
+		OSVersion: "22.04",
+		Packages: NewPackages("deb", map[string]string{
+			"zlib1g":  "1:1.2.11.dfsg-2ubuntu9.2",
+			"openssl": "3.0.2-0ubuntu1.12",
+		}),
+		Created: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC),
+		Version: "1.0.0",
+	}
+}
+
+func TestNewPackages(t *testing.T) {
+	pkgs := NewPackages("deb", map[string]string{"zlib1g": "1", "apt": "2"})
+	assert.Equal(t, []Package{{Name: "apt", Version: "2", Type: "deb"}, {Name: "zlib1g", Version: "1", Type: "deb"}}, pkgs)
+}
+
+func TestGetPackageURL(t *testing.T) {
+	doc := newTestDocument()
+
+	assert.Equal(t, "pkg:deb/ubuntu/openssl@3.0.2-0ubuntu1.12?distro=ubuntu-22.04", getPackageURL(doc, &doc.Packages[0]))
+	assert.Equal(t, "pkg:deb/ubuntu/zlib1g@1:1.2.11.dfsg-2ubuntu9.2?distro=ubuntu-22.04", getPackageURL(doc, &doc.Packages[1]))
+}
+
+func TestGenerateCycloneDX(t *testing.T) {
+	buf, err := Generate(FormatCycloneDX, newTestDocument())
+	assert.NoError(t, err)
+
+	var bom cycloneDX
+	assert.NoError(t, json.Unmarshal(buf, &bom))
+	assert.Equal(t, "CycloneDX", bom.BOMFormat)
+	assert.Regexp(t, `^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`, bom.SerialNumber)
+	assert.Equal(t, "2024-03-01T08:00:00Z", bom.Metadata.Timestamp)
+	assert.Equal(t, "container", bom.Metadata.Component.Type)
+	assert.Len(t, bom.Components, 2)
+	assert.Equal(t, "openssl", bom.Components[0].Name)
+	assert.Equal(t, "pkg:deb/ubuntu/openssl@3.0.2-0ubuntu1.12?distro=ubuntu-22.04", bom.Components[0].PURL)
+}
+
+func TestGenerateSPDX(t *testing.T) {
+	buf, err := Generate(FormatSPDX, newTestDocument())
+	assert.NoError(t, err)
+
+	var doc spdx
+	assert.NoError(t, json.Unmarshal(buf, &doc))
+	assert.Equal(t, "SPDX-2.3", doc.SPDXVersion)
+	assert.Equal(t, []string{"Tool: copatcher-1.0.0"}, doc.CreationInfo.Creators)
+	assert.Len(t, doc.Packages, 3)
+	assert.Equal(t, "SPDXRef-Package-deb-zlib1g-1-1.2.11.dfsg-2ubuntu9.2", doc.Packages[2].SPDXID)
+	assert.Len(t, doc.Relationships, 3)
+	assert.Equal(t, spdxRelDescribes, doc.Relationships[0].RelationshipType)
+	assert.Equal(t, doc.Packages[2].SPDXID, doc.Relationships[2].RelatedSPDXElement)
+}
+
+func TestGenerateUnsupported(t *testing.T) {
+	_, err := Generate("syft", newTestDocument())
+	assert.Error(t, err)
+
+	_, err = GetPredicateType("syft")
+	assert.Error(t, err)
+
+	predicateType, err := GetPredicateType(FormatSPDX)
+	assert.NoError(t, err)
+	assert.Equal(t, PredicateSPDX, predicateType)
+}
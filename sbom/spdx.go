@@ -0,0 +1,110 @@
+package sbom
+
+import (
+	"time"
+)
+
+const (
+	spdxDataLicense    = "CC0-1.0"
+	spdxDocumentID     = "SPDXRef-DOCUMENT"
+	spdxImageID        = "SPDXRef-Image"
+	spdxNamespace      = "https://github.com/craftslab/copatcher/sbom/"
+	spdxNoAssertion    = "NOASSERTION"
+	spdxVersion        = "SPDX-2.3"
+	spdxRefCategory    = "PACKAGE-MANAGER"
+	spdxRefType        = "purl"
+	spdxRelContains    = "CONTAINS"
+	spdxRelDescribes   = "DESCRIBES"
+	spdxToolCreator    = "Tool: "
+	spdxPrimaryImage   = "CONTAINER"
+	spdxPrimaryLibrary = "LIBRARY"
+)
+
+type spdx struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func newSPDX(doc *Document) *spdx {
+	creator := spdxToolCreator + toolName
+	if doc.Version != "" {
+		creator += "-" + doc.Version
+	}
+
+	out := &spdx{
+		SPDXVersion:       spdxVersion,
+		DataLicense:       spdxDataLicense,
+		SPDXID:            spdxDocumentID,
+		Name:              doc.Name,
+		DocumentNamespace: spdxNamespace + getDocumentID(doc),
+		CreationInfo: spdxCreationInfo{
+			Created:  doc.Created.UTC().Format(time.RFC3339),
+			Creators: []string{creator},
+		},
+		Packages: []spdxPackage{
+			{
+				SPDXID:                spdxImageID,
+				Name:                  doc.Name,
+				DownloadLocation:      spdxNoAssertion,
+				PrimaryPackagePurpose: spdxPrimaryImage,
+			},
+		},
+		Relationships: []spdxRelationship{
+			{SPDXElementID: spdxDocumentID, RelationshipType: spdxRelDescribes, RelatedSPDXElement: spdxImageID},
+		},
+	}
+
+	for i := range doc.Packages {
+		p := &doc.Packages[i]
+		id := getSPDXID(p)
+		out.Packages = append(out.Packages, spdxPackage{
+			SPDXID:                id,
+			Name:                  p.Name,
+			VersionInfo:           p.Version,
+			DownloadLocation:      spdxNoAssertion,
+			PrimaryPackagePurpose: spdxPrimaryLibrary,
+			ExternalRefs: []spdxExternalRef{
+				{ReferenceCategory: spdxRefCategory, ReferenceType: spdxRefType, ReferenceLocator: getPackageURL(doc, p)},
+			},
+		})
+		out.Relationships = append(out.Relationships, spdxRelationship{
+			SPDXElementID:      spdxImageID,
+			RelationshipType:   spdxRelContains,
+			RelatedSPDXElement: id,
+		})
+	}
+
+	return out
+}
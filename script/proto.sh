@@ -0,0 +1,7 @@
+#!/bin/bash
+
+# go install google.golang.org/protobuf/cmd/protoc-gen-go@v1.32.0
+# go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.3.0
+protoc --go_out=. --go_opt=paths=source_relative \
+  --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+  api/copatcher.proto
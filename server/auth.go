@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	authorizationHeader = "authorization"
+	bearerPrefix        = "Bearer "
+)
+
+var errUnauthorized = errors.New("invalid token")
+
+// isAuthorized reports whether the Authorization header sends the token, optionally as a Bearer token.
+func isAuthorized(header, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, bearerPrefix)), []byte(token)) == 1
+}
+
+// checkToken checks the Authorization header of the api request if the server has a token.
+func (s *server) checkToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.cfg.Config.Server.Token
+		if token != "" && !isAuthorized(r.Header.Get(authorizationHeader), token) {
+			writeError(w, http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// newGRPCServer returns the grpc server of the api, whose calls must send the token of the server in the
+// authorization metadata if set.
+func (s *server) newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			if err := s.checkGRPCToken(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) error {
+			if err := s.checkGRPCToken(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+
+	return srv
+}
+
+func (s *server) checkGRPCToken(ctx context.Context) error {
+	token := s.cfg.Config.Server.Token
+	if token == "" {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(authorizationHeader) {
+		if isAuthorized(v, token) {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, errUnauthorized.Error())
+}
+
+// isLoopback reports whether the address only listens on the loopback interface, e.g. localhost:8080.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
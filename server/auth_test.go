@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/craftslab/copatcher/api"
+)
+
+func TestCheckToken(t *testing.T) {
+	s := newTestServer(t, 1)
+	s.cfg.Config.Server.Token = "token"
+	h := s.handler()
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		code   int
+	}{
+		{"no token", "/api/v1/jobs", "", http.StatusUnauthorized},
+		{"invalid token", "/api/v1/jobs", "Bearer invalid", http.StatusUnauthorized},
+		{"token", "/api/v1/jobs", "token", http.StatusOK},
+		{"bearer token", "/api/v1/jobs", "Bearer token", http.StatusOK},
+		{"job without token", "/api/v1/jobs/missing", "", http.StatusUnauthorized},
+		{"health without token", "/healthz", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, http.NoBody)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestGRPCToken(t *testing.T) {
+	s := newTestServer(t, 1)
+	s.cfg.Config.Server.Token = "token"
+	clt := newTestClient(t, s)
+
+	_, err := clt.GetJob(context.Background(), &api.GetJobRequest{Id: "missing"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer invalid")
+	_, err = clt.GetJob(ctx, &api.GetJobRequest{Id: "missing"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stream, err := clt.StreamEvents(context.Background(), &api.StreamEventsRequest{JobId: "missing"})
+	assert.Equal(t, nil, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+	_, err = clt.GetJob(ctx, &api.GetJobRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestIsLoopback(t *testing.T) {
+	assert.Equal(t, true, isLoopback("127.0.0.1:8080"))
+	assert.Equal(t, true, isLoopback("localhost:8080"))
+	assert.Equal(t, true, isLoopback("[::1]:8080"))
+	assert.Equal(t, false, isLoopback(":8080"))
+	assert.Equal(t, false, isLoopback("0.0.0.0:8080"))
+	assert.Equal(t, false, isLoopback("invalid"))
+}
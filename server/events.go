@@ -0,0 +1,118 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/craftslab/copatcher/api"
+)
+
+const (
+	// maxEvents is the number of events kept for a job, beyond which its solve events are dropped.
+	maxEvents = 10000
+	// maxEventLogs is the size of the solve logs kept in the events of a job, beyond which the solve events are
+	// kept without their logs. The logs are kept in the log of the job anyway.
+	maxEventLogs = 4 << 20
+)
+
+// eventLog keeps the events of a job, which are streamed from the start to each subscriber until it is closed.
+// The solve logs are dropped from the events once it is closed, so the finished jobs keep their progress only.
+type eventLog struct {
+	mu     sync.Mutex
+	events []*api.Event
+	// logs is the size of the solve logs in the events.
+	logs   int
+	closed bool
+	// wait is closed and replaced when an event is added or the log is closed.
+	wait chan struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		wait: make(chan struct{}),
+	}
+}
+
+func (l *eventLog) add(e *api.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	if solve := e.GetSolve(); solve != nil {
+		if len(l.events) >= maxEvents {
+			return
+		}
+		if size := getLogsSize(solve); size != 0 {
+			if l.logs+size > maxEventLogs {
+				e = withoutLogs(e)
+			} else {
+				l.logs += size
+			}
+		}
+	}
+
+	l.events = append(l.events, e)
+	l.notify()
+}
+
+func (l *eventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	// The events are copied as the subscribers may still read the previous ones
+	events := make([]*api.Event, 0, len(l.events))
+	for _, e := range l.events {
+		if solve := e.GetSolve(); solve != nil && len(solve.GetLogs()) != 0 {
+			e = withoutLogs(e)
+		}
+		events = append(events, e)
+	}
+
+	l.events = events
+	l.logs = 0
+	l.closed = true
+	l.notify()
+}
+
+func (l *eventLog) notify() {
+	close(l.wait)
+	l.wait = make(chan struct{})
+}
+
+// next returns the events from the index, whether the log is closed, and the channel closed on a change.
+func (l *eventLog) next(i int) ([]*api.Event, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.events[i:len(l.events):len(l.events)], l.closed, l.wait
+}
+
+func getLogsSize(solve *api.SolveStatus) int {
+	size := 0
+
+	for _, l := range solve.GetLogs() {
+		size += len(l.GetData())
+	}
+
+	return size
+}
+
+// withoutLogs returns a copy of the solve event without its logs.
+func withoutLogs(e *api.Event) *api.Event {
+	solve := e.GetSolve()
+
+	return &api.Event{
+		JobId: e.GetJobId(),
+		Time:  e.GetTime(),
+		Event: &api.Event_Solve{Solve: &api.SolveStatus{
+			Vertexes: solve.GetVertexes(),
+			Statuses: solve.GetStatuses(),
+		}},
+	}
+}
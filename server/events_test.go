@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/api"
+	"github.com/craftslab/copatcher/utils"
+)
+
+func newTestSolveEvent(data []byte) *api.Event {
+	return &api.Event{
+		JobId: "job",
+		Event: &api.Event_Solve{Solve: &api.SolveStatus{
+			Vertexes: []*api.Vertex{{Digest: "sha256:vertex", Name: "apt update"}},
+			Logs:     []*api.VertexLog{{Vertex: "sha256:vertex", Data: data}},
+		}},
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	t.Run("logs dropped on close", func(t *testing.T) {
+		l := newEventLog()
+		l.add(newTestSolveEvent([]byte("Reading package lists...")))
+		l.add(newPhaseEvent("job", &utils.PhaseEvent{Phase: utils.PhaseFetch, Attempt: 1}))
+
+		running, closed, _ := l.next(0)
+		assert.False(t, closed)
+		assert.Len(t, running[0].GetSolve().GetLogs(), 1)
+
+		l.close()
+
+		events, closed, _ := l.next(0)
+		assert.True(t, closed)
+		assert.Len(t, events, 2)
+		assert.Empty(t, events[0].GetSolve().GetLogs())
+		assert.Equal(t, "apt update", events[0].GetSolve().GetVertexes()[0].GetName())
+		assert.Equal(t, "fetch", events[1].GetPhase().GetPhase())
+		// The events read before the close are not changed
+		assert.Len(t, running[0].GetSolve().GetLogs(), 1)
+	})
+
+	t.Run("logs capped", func(t *testing.T) {
+		l := newEventLog()
+		l.add(newTestSolveEvent(bytes.Repeat([]byte("a"), maxEventLogs)))
+		l.add(newTestSolveEvent([]byte("b")))
+
+		events, _, _ := l.next(0)
+		assert.Len(t, events, 2)
+		assert.Len(t, events[0].GetSolve().GetLogs(), 1)
+		assert.Empty(t, events[1].GetSolve().GetLogs())
+		assert.Len(t, events[1].GetSolve().GetVertexes(), 1)
+	})
+
+	t.Run("events capped", func(t *testing.T) {
+		l := newEventLog()
+		for i := 0; i < maxEvents+1; i++ {
+			l.add(newTestSolveEvent(nil))
+		}
+		l.add(newPhaseEvent("job", &utils.PhaseEvent{Phase: utils.PhaseExport, Attempt: 1}))
+
+		events, _, _ := l.next(0)
+		assert.Len(t, events, maxEvents+1)
+		assert.Equal(t, "export", events[maxEvents].GetPhase().GetPhase())
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		l := newEventLog()
+		l.close()
+		l.add(newTestSolveEvent(nil))
+
+		events, closed, _ := l.next(0)
+		assert.True(t, closed)
+		assert.Empty(t, events)
+	})
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/craftslab/copatcher/api"
+	"github.com/craftslab/copatcher/patcher"
+	"github.com/craftslab/copatcher/pkgmgr"
+	"github.com/craftslab/copatcher/report"
+	"github.com/craftslab/copatcher/utils"
+)
+
+// grpcServer serves the grpc api with the job queue of the server.
+type grpcServer struct {
+	api.UnimplementedPatcherServer
+	s *server
+}
+
+func (g *grpcServer) Patch(ctx context.Context, in *api.PatchRequest) (*api.Job, error) {
+	req := &Request{
+		Image:         in.GetImage(),
+		Tag:           in.GetTag(),
+		MultiPlatform: in.GetMultiPlatform(),
+		Push:          in.Push,
+		IgnoreErrors:  in.IgnoreErrors,
+		SBOM:          in.GetSbom(),
+		Exclude:       in.GetExclude(),
+		Report:        in.GetReport(),
+	}
+
+	v, err := g.s.submit(ctx, req)
+	switch {
+	case errors.Is(err, errQueueFull):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return newAPIJob(&v), nil
+}
+
+// Scan parses the report, drops the excluded packages, and resolves the latest version of each package to update.
+func (g *grpcServer) Scan(_ context.Context, in *api.ScanRequest) (*api.ScanResponse, error) {
+	manifest, err := report.Parse(in.GetReport())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, errors.Wrap(err, "invalid report").Error())
+	}
+
+	exclude := in.GetExclude()
+	if exclude == nil {
+		exclude = g.s.cfg.Config.Packages.Exclude
+	}
+
+	manifest.Updates = patcher.ExcludeUpdates(manifest.Updates, exclude)
+
+	cmp, err := pkgmgr.GetVersionComparer(manifest.Metadata.OS.Type)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	pkgs, err := pkgmgr.GetUniqueLatestUpdates(manifest.Updates, cmp, in.GetIgnoreErrors())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, errors.Wrap(err, "invalid updates").Error())
+	}
+
+	out := &api.ScanResponse{
+		OsType:    manifest.Metadata.OS.Type,
+		OsVersion: manifest.Metadata.OS.Version,
+		Arch:      manifest.Metadata.Config.Arch,
+	}
+
+	for _, u := range manifest.Updates {
+		out.Updates = append(out.Updates, &api.Update{
+			Name:             u.Name,
+			InstalledVersion: u.InstalledVersion,
+			UpdatedVersion:   u.UpdatedVersion,
+			VulnerabilityId:  u.VulnerabilityID,
+		})
+	}
+
+	for _, p := range pkgs {
+		out.Packages = append(out.Packages, &api.Package{Name: p.Name, Version: p.UpdatedVersion})
+	}
+
+	return out, nil
+}
+
+func (g *grpcServer) GetJob(_ context.Context, in *api.GetJobRequest) (*api.Job, error) {
+	_, v, ok := g.s.jobs.get(in.GetId())
+	if !ok {
+		return nil, status.Error(codes.NotFound, "job not found")
+	}
+
+	return newAPIJob(&v), nil
+}
+
+// StreamEvents sends the events of the job from its creation, and then the new ones until the job is finished.
+func (g *grpcServer) StreamEvents(in *api.StreamEventsRequest, stream api.Patcher_StreamEventsServer) error {
+	j, _, ok := g.s.jobs.get(in.GetJobId())
+	if !ok {
+		return status.Error(codes.NotFound, "job not found")
+	}
+
+	for i := 0; ; {
+		events, closed, wait := j.events.next(i)
+		for _, e := range events {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		i += len(events)
+		if closed {
+			return nil
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-wait:
+		}
+	}
+}
+
+func newAPIJob(v *Job) *api.Job {
+	return &api.Job{
+		Id:            v.ID,
+		Image:         v.Image,
+		Tag:           v.Tag,
+		MultiPlatform: v.MultiPlatform,
+		Status:        v.Status,
+		Error:         v.Error,
+		Created:       timestamppb.New(v.Created),
+		Started:       newTimestamp(v.Started),
+		Finished:      newTimestamp(v.Finished),
+	}
+}
+
+func newJobEvent(v *Job) *api.Event {
+	return &api.Event{
+		JobId: v.ID,
+		Time:  timestamppb.Now(),
+		Event: &api.Event_Job{Job: newAPIJob(v)},
+	}
+}
+
+func newPhaseEvent(id string, e *utils.PhaseEvent) *api.Event {
+	phase := &api.PhaseEvent{
+		Phase:   string(e.Phase),
+		Attempt: int32(e.Attempt),
+		Done:    e.Done,
+	}
+
+	if e.Done {
+		phase.Duration = durationpb.New(e.Duration)
+	}
+
+	if e.Err != nil {
+		phase.Error = e.Err.Error()
+	}
+
+	return &api.Event{
+		JobId: id,
+		Time:  timestamppb.Now(),
+		Event: &api.Event_Phase{Phase: phase},
+	}
+}
+
+func newSolveEvent(id string, st *client.SolveStatus) *api.Event {
+	solve := &api.SolveStatus{}
+
+	for _, v := range st.Vertexes {
+		vertex := &api.Vertex{
+			Digest:    v.Digest.String(),
+			Name:      v.Name,
+			Cached:    v.Cached,
+			Started:   newTimestamp(v.Started),
+			Completed: newTimestamp(v.Completed),
+			Error:     v.Error,
+		}
+		for _, in := range v.Inputs {
+			vertex.Inputs = append(vertex.Inputs, in.String())
+		}
+		solve.Vertexes = append(solve.Vertexes, vertex)
+	}
+
+	for _, s := range st.Statuses {
+		solve.Statuses = append(solve.Statuses, &api.VertexStatus{
+			Id:        s.ID,
+			Vertex:    s.Vertex.String(),
+			Name:      s.Name,
+			Current:   s.Current,
+			Total:     s.Total,
+			Timestamp: timestamppb.New(s.Timestamp),
+			Started:   newTimestamp(s.Started),
+			Completed: newTimestamp(s.Completed),
+		})
+	}
+
+	for _, l := range st.Logs {
+		solve.Logs = append(solve.Logs, &api.VertexLog{
+			Vertex:    l.Vertex.String(),
+			Timestamp: timestamppb.New(l.Timestamp),
+			Stream:    int32(l.Stream),
+			Data:      l.Data,
+		})
+	}
+
+	return &api.Event{
+		JobId: id,
+		Time:  timestamppb.Now(),
+		Event: &api.Event_Solve{Solve: solve},
+	}
+}
+
+func newTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+
+	return timestamppb.New(*t)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/craftslab/copatcher/api"
+	"github.com/craftslab/copatcher/batch"
+	"github.com/craftslab/copatcher/utils"
+)
+
+const testScanReport = `{
+  "metadata": {"os": {"type": "ubuntu", "version": "22.04"}, "config": {"arch": "amd64"}},
+  "updates": [
+    {"name": "libssl3", "installedVersion": "3.0.2-0ubuntu1.10", "updatedVersion": "3.0.2-0ubuntu1.12", "vulnerabilityID": "CVE-2023-0001"},
+    {"name": "libssl3", "installedVersion": "3.0.2-0ubuntu1.10", "updatedVersion": "3.0.2-0ubuntu1.15", "vulnerabilityID": "CVE-2023-0002"},
+    {"name": "linux-libc-dev", "installedVersion": "5.15.0-1", "updatedVersion": "5.15.0-2", "vulnerabilityID": "CVE-2023-0003"}
+  ]
+}`
+
+func newTestClient(t *testing.T, s *server) api.PatcherClient {
+	lis := bufconn.Listen(1 << 20)
+
+	srv := s.newGRPCServer()
+	api.RegisterPatcherServer(srv, &grpcServer{s: s})
+
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Equal(t, nil, err)
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+		srv.Stop()
+	})
+
+	return api.NewPatcherClient(conn)
+}
+
+func TestGRPCPatch(t *testing.T) {
+	s := newTestServer(t, 1)
+	clt := newTestClient(t, s)
+
+	_, err := clt.Patch(context.Background(), &api.PatchRequest{Report: readTestReport(t)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	push := true
+
+	job, err := clt.Patch(context.Background(), &api.PatchRequest{Image: testImage, Push: &push, Report: readTestReport(t)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, StatusQueued, job.GetStatus())
+
+	_, err = clt.Patch(context.Background(), &api.PatchRequest{Image: testImage, Report: readTestReport(t)})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	got, err := clt.GetJob(context.Background(), &api.GetJobRequest{Id: job.GetId()})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, job.GetId(), got.GetId())
+	assert.Equal(t, testImage, got.GetImage())
+
+	_, err = clt.GetJob(context.Background(), &api.GetJobRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCScan(t *testing.T) {
+	s := newTestServer(t, 1)
+	s.cfg.Config.Packages.Exclude = []string{"linux-*"}
+	clt := newTestClient(t, s)
+
+	res, err := clt.Scan(context.Background(), &api.ScanRequest{Report: []byte(testScanReport)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ubuntu", res.GetOsType())
+	assert.Equal(t, "22.04", res.GetOsVersion())
+	assert.Equal(t, "amd64", res.GetArch())
+	assert.Equal(t, 2, len(res.GetUpdates()))
+	assert.Equal(t, "CVE-2023-0002", res.GetUpdates()[1].GetVulnerabilityId())
+	assert.Equal(t, 1, len(res.GetPackages()))
+	assert.Equal(t, "3.0.2-0ubuntu1.15", res.GetPackages()[0].GetVersion())
+
+	res, err = clt.Scan(context.Background(), &api.ScanRequest{Report: []byte(testScanReport), Exclude: []string{"libssl3"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(res.GetPackages()))
+	assert.Equal(t, "linux-libc-dev", res.GetPackages()[0].GetName())
+
+	_, err = clt.Scan(context.Background(), &api.ScanRequest{Report: []byte(`{"invalid":true}`)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCStreamEvents(t *testing.T) {
+	s := newTestServer(t, 1)
+	clt := newTestClient(t, s)
+
+	release := make(chan struct{})
+
+	s.run = func(_ context.Context, j *job) error {
+		<-release
+		j.events.add(newPhaseEvent(j.ID, &utils.PhaseEvent{Phase: utils.PhaseFetch, Attempt: 1, Done: true, Duration: time.Second}))
+		return nil
+	}
+
+	job, err := clt.Patch(context.Background(), &api.PatchRequest{Image: testImage, Report: readTestReport(t)})
+	assert.Equal(t, nil, err)
+
+	stream, err := clt.StreamEvents(context.Background(), &api.StreamEventsRequest{JobId: job.GetId()})
+	assert.Equal(t, nil, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go s.work(ctx)
+
+	var events []*api.Event
+
+	e, err := stream.Recv()
+	assert.Equal(t, nil, err)
+	events = append(events, e)
+	close(release)
+
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.Equal(t, nil, err)
+		events = append(events, e)
+	}
+
+	assert.Equal(t, 4, len(events))
+	assert.Equal(t, StatusQueued, events[0].GetJob().GetStatus())
+	assert.Equal(t, StatusRunning, events[1].GetJob().GetStatus())
+	assert.Equal(t, "fetch", events[2].GetPhase().GetPhase())
+	assert.Equal(t, time.Second, events[2].GetPhase().GetDuration().AsDuration())
+	assert.Equal(t, batch.StatusPatched, events[3].GetJob().GetStatus())
+
+	missing, err := clt.StreamEvents(context.Background(), &api.StreamEventsRequest{JobId: "missing"})
+	assert.Equal(t, nil, err)
+	_, err = missing.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
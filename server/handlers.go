@@ -0,0 +1,366 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/copatcher/config"
+	"github.com/craftslab/copatcher/patcher"
+	"github.com/craftslab/copatcher/report"
+	"github.com/craftslab/copatcher/result"
+)
+
+const (
+	// maxRequestSize limits the size of a job request with its report.
+	maxRequestSize = 32 << 20
+)
+
+// Request is a patch job, which is posted as json or as a multipart form with the report as the report file.
+type Request struct {
+	Image         string `json:"image"`
+	Tag           string `json:"tag,omitempty"`
+	MultiPlatform bool   `json:"multiPlatform,omitempty"`
+	// Push, IgnoreErrors, SBOM and Exclude override the settings of the config profile if set.
+	Push         *bool    `json:"push,omitempty"`
+	IgnoreErrors *bool    `json:"ignoreErrors,omitempty"`
+	SBOM         string   `json:"sbom,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+	// Report is the update manifest, or a Trivy or Grype json report.
+	Report json.RawMessage `json:"report"`
+}
+
+var errQueueFull = errors.New("job queue is full")
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("POST /api/v1/jobs", s.checkToken(s.handleCreateJob))
+	mux.HandleFunc("GET /api/v1/jobs", s.checkToken(s.handleListJobs))
+	mux.HandleFunc("GET /api/v1/jobs/{id}", s.checkToken(s.handleGetJob))
+	mux.HandleFunc("GET /api/v1/jobs/{id}/logs", s.checkToken(s.handleGetLogs))
+	mux.HandleFunc("GET /api/v1/jobs/{id}/result", s.checkToken(s.handleGetResult))
+	mux.HandleFunc("POST /api/v1/webhooks/distribution", s.checkSecret(s.handleDistribution))
+	mux.HandleFunc("POST /api/v1/webhooks/harbor", s.checkSecret(s.handleHarbor))
+
+	if s.cfg.Metrics != nil {
+		mux.Handle("GET /metrics", s.cfg.Metrics.Handler())
+	}
+
+	return mux
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	req, err := parseRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid request"))
+		return
+	}
+
+	v, err := s.submit(r.Context(), req)
+	switch {
+	case errors.Is(err, errQueueFull):
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/jobs/"+v.ID)
+	writeJSON(w, http.StatusAccepted, v)
+}
+
+func (s *server) handleListJobs(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.list())
+}
+
+func (s *server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	_, v, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (s *server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
+	j, _, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(j.logs.Bytes())
+}
+
+// handleGetResult returns the result of the finished job in the format of the format query, which is json if empty.
+func (s *server) handleGetResult(w http.ResponseWriter, r *http.Request) {
+	j, _, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = result.FormatJSON
+	}
+
+	buf, err := os.ReadFile(filepath.Join(j.folder, resultFile))
+	if err != nil {
+		writeError(w, http.StatusNotFound, errors.New("result not found"))
+		return
+	}
+
+	var doc result.Document
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrap(err, "failed to unmarshal result"))
+		return
+	}
+
+	out, err := result.Generate(format, &doc)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", getContentType(format))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+// submit creates the job of the request and queues it, which fails with errQueueFull if the queue is full.
+func (s *server) submit(ctx context.Context, req *Request) (Job, error) {
+	j, err := s.newJob(ctx, req)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return s.enqueue(j)
+}
+
+// enqueue adds the job and queues it, which fails with errQueueFull if the queue is full.
+func (s *server) enqueue(j *job) (Job, error) {
+	// The job is not shared until it is queued
+	v := j.Job
+	j.events.add(newJobEvent(&v))
+
+	s.jobs.add(j)
+
+	select {
+	case s.queue <- j:
+		s.cfg.Metrics.SetQueueDepth(len(s.queue))
+	default:
+		s.jobs.remove(j.ID)
+		_ = os.RemoveAll(j.folder)
+		return Job{}, errQueueFull
+	}
+
+	return v, nil
+}
+
+// newJob checks the request and its report, and writes the report into the working folder of the job.
+func (s *server) newJob(ctx context.Context, req *Request) (*job, error) {
+	if req.Image == "" {
+		return nil, errors.New("image is required")
+	}
+
+	if _, err := reference.ParseNormalizedNamed(req.Image); err != nil {
+		return nil, errors.Wrap(err, "invalid image")
+	}
+
+	if len(req.Report) == 0 {
+		return nil, errors.New("report is required")
+	}
+
+	cfg := getJobConfig(&s.cfg.Config, req)
+	if err := patcher.ValidateConfig(cfg); err != nil {
+		return nil, errors.Wrap(err, "invalid options")
+	}
+
+	folder, err := s.newJobFolder()
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Join(folder, reportFile)
+	if err := os.WriteFile(name, req.Report, patcher.DefaultFilePerm); err != nil {
+		_ = os.RemoveAll(folder)
+		return nil, errors.Wrap(err, "failed to write report")
+	}
+
+	rc := report.DefaultConfig()
+	rc.Config = *cfg
+
+	if _, err := report.New(ctx, rc).Run(ctx, name); err != nil {
+		_ = os.RemoveAll(folder)
+		return nil, errors.Wrap(err, "invalid report")
+	}
+
+	return &job{
+		Job: Job{
+			ID:            uuid.NewString(),
+			Image:         req.Image,
+			Tag:           cfg.Output.Tag,
+			MultiPlatform: req.MultiPlatform,
+			Status:        StatusQueued,
+			Created:       time.Now(),
+		},
+		cfg:    cfg,
+		folder: folder,
+		logs:   &logBuffer{},
+		events: newEventLog(),
+	}, nil
+}
+
+func (s *server) newJobFolder() (string, error) {
+	folder, err := os.MkdirTemp(s.folder, jobFolderPattern)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create job folder")
+	}
+
+	return folder, nil
+}
+
+// parseRequest parses the json or multipart form request.
+func parseRequest(r *http.Request) (*Request, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "multipart/form-data" {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, errors.Wrap(err, "failed to decode request")
+		}
+		return &req, nil
+	}
+
+	if err := r.ParseMultipartForm(maxRequestSize); err != nil {
+		return nil, errors.Wrap(err, "failed to parse form")
+	}
+
+	req := Request{
+		Image:   r.FormValue("image"),
+		Tag:     r.FormValue("tag"),
+		SBOM:    r.FormValue("sbom"),
+		Exclude: r.MultipartForm.Value["exclude"],
+	}
+
+	var err error
+
+	if req.MultiPlatform, err = parseFormBool(r, "multiPlatform"); err != nil {
+		return nil, err
+	}
+
+	for name, v := range map[string]**bool{"push": &req.Push, "ignoreErrors": &req.IgnoreErrors} {
+		if r.FormValue(name) == "" {
+			continue
+		}
+		b, e := parseFormBool(r, name)
+		if e != nil {
+			return nil, e
+		}
+		*v = &b
+	}
+
+	f, _, err := r.FormFile("report")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get report file")
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := json.NewDecoder(f).Decode(&req.Report); err != nil {
+		return nil, errors.Wrap(err, "failed to decode report")
+	}
+
+	return &req, nil
+}
+
+func parseFormBool(r *http.Request, name string) (bool, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid %s", name)
+	}
+
+	return b, nil
+}
+
+// getJobConfig returns the config overridden by the options of the request.
+func getJobConfig(cfg *config.Config, req *Request) *config.Config {
+	out := cfg.Clone()
+
+	if req.Tag != "" {
+		out.Output.Tag = req.Tag
+	}
+
+	if req.Push != nil {
+		out.Output.Push = *req.Push
+	}
+
+	if req.IgnoreErrors != nil {
+		out.Packages.IgnoreErrors = *req.IgnoreErrors
+	}
+
+	if req.SBOM != "" {
+		out.Output.SBOM = req.SBOM
+	}
+
+	if req.Exclude != nil {
+		out.Packages.Exclude = req.Exclude
+	}
+
+	return out
+}
+
+func getContentType(format string) string {
+	switch format {
+	case result.FormatJUnit:
+		return "application/xml"
+	case result.FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
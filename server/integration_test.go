@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/distribution/reference"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/batch"
+)
+
+const (
+	// envTestBuildkitAddress enables the integration test with the address of buildkitd, e.g. tcp://127.0.0.1:1234.
+	envTestBuildkitAddress = "COPATCHER_TEST_BUILDKIT_ADDRESS"
+	// envTestImage is the image pushed to a plain HTTP registry, e.g. registry:2 on localhost:5000, to patch.
+	envTestImage = "COPATCHER_TEST_IMAGE"
+
+	defaultTestImage = "localhost:5000/ubuntu:22.04"
+
+	testPatchReport = `{
+  "metadata": {"os": {"type": "ubuntu", "version": "22.04"}, "config": {"arch": "amd64"}},
+  "updates": [{"name": "libssl3", "installedVersion": "3.0.2-0ubuntu1", "updatedVersion": "3.0.2-0ubuntu1.1"}]
+}`
+)
+
+// TestIntegration patches an image of a registry with a real patcher through the REST api, and checks the patched
+// image is pushed to the registry. It is skipped unless COPATCHER_TEST_BUILDKIT_ADDRESS is set, e.g.:
+//
+//	docker run -d --name buildkitd --privileged --network=host moby/buildkit:latest --addr tcp://127.0.0.1:1234
+//	docker run -d --name registry -p 5000:5000 registry:2
+//	docker pull ubuntu:22.04 && docker tag ubuntu:22.04 localhost:5000/ubuntu:22.04 && docker push localhost:5000/ubuntu:22.04
+//	COPATCHER_TEST_BUILDKIT_ADDRESS=tcp://127.0.0.1:1234 go test ./server -run TestIntegration
+func TestIntegration(t *testing.T) {
+	address := os.Getenv(envTestBuildkitAddress)
+	if address == "" {
+		t.Skipf("%s is not set", envTestBuildkitAddress)
+	}
+
+	image := os.Getenv(envTestImage)
+	if image == "" {
+		image = defaultTestImage
+	}
+
+	s := newTestServer(t, 1)
+	s.cfg.Config.Buildkit.Address = address
+	s.cfg.Config.Registry.PlainHTTP = true
+	s.cfg.Config.Packages.IgnoreErrors = true
+	s.cfg.Config.Timeouts.Total = 10 * time.Minute
+
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go s.work(ctx)
+
+	push := true
+	v, err := s.submit(ctx, &Request{Image: image, Tag: "integration-patched", Push: &push, Report: json.RawMessage(testPatchReport)})
+	assert.Equal(t, nil, err)
+
+	assert.Eventually(t, func() bool {
+		_, v, _ = s.jobs.get(v.ID)
+		return v.Finished != nil
+	}, 10*time.Minute, time.Second)
+
+	j, _, _ := s.jobs.get(v.ID)
+	assert.NotEqual(t, batch.StatusFailed, v.Status, string(j.logs.Bytes()))
+
+	if v.Status != batch.StatusPatched {
+		return
+	}
+
+	rsp, err := http.Get(srv.URL + "/api/v1/jobs/" + v.ID + "/result")
+	assert.Equal(t, nil, err)
+	_ = rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	named, err := reference.ParseNormalizedNamed(image)
+	assert.Equal(t, nil, err)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fmt.Sprintf("http://%s/v2/%s/manifests/integration-patched",
+		reference.Domain(named), reference.Path(named)), http.NoBody)
+	assert.Equal(t, nil, err)
+	req.Header.Set("Accept", ispec.MediaTypeImageIndex+","+ispec.MediaTypeImageManifest)
+
+	rsp, err = http.DefaultClient.Do(req)
+	assert.Equal(t, nil, err)
+	_ = rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+}
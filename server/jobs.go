@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/craftslab/copatcher/config"
+)
+
+// Statuses of the jobs not finished yet, which are followed by the statuses of the batch jobs.
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+)
+
+// Job is the status of a patch job.
+type Job struct {
+	ID            string     `json:"id"`
+	Image         string     `json:"image"`
+	Tag           string     `json:"tag,omitempty"`
+	MultiPlatform bool       `json:"multiPlatform"`
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	Created       time.Time  `json:"created"`
+	Started       *time.Time `json:"started,omitempty"`
+	Finished      *time.Time `json:"finished,omitempty"`
+}
+
+// job is a queued job with its config, working folder, log and events.
+type job struct {
+	Job
+	cfg    *config.Config
+	folder string
+	logs   *logBuffer
+	events *eventLog
+	// push is the push of the image which queued the job, whose report is scanned by the job.
+	push *pushEvent
+}
+
+// store keeps the jobs in the order of creation.
+type store struct {
+	mu    sync.RWMutex
+	items map[string]*job
+	ids   []string
+}
+
+func newStore() *store {
+	return &store{
+		items: map[string]*job{},
+	}
+}
+
+func (s *store) add(j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[j.ID] = j
+	s.ids = append(s.ids, j.ID)
+}
+
+func (s *store) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, id)
+
+	for i := range s.ids {
+		if s.ids[i] == id {
+			s.ids = append(s.ids[:i], s.ids[i+1:]...)
+			break
+		}
+	}
+}
+
+// get returns the job and a copy of its status.
+func (s *store) get(id string) (*job, Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	j, ok := s.items[id]
+	if !ok {
+		return nil, Job{}, false
+	}
+
+	return j, j.Job, true
+}
+
+// list returns a copy of the status of each job.
+func (s *store) list() []Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]Job, 0, len(s.ids))
+	for _, id := range s.ids {
+		out = append(out, s.items[id].Job)
+	}
+
+	return out
+}
+
+// evict removes the jobs finished before the retention, and the oldest finished jobs beyond the max jobs,
+// which are unlimited if 0, and returns the removed jobs.
+func (s *store) evict(now time.Time, retention time.Duration, maxJobs int) []*job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	finished := 0
+	for _, id := range s.ids {
+		if s.items[id].Finished != nil {
+			finished++
+		}
+	}
+
+	var out []*job
+
+	ids := s.ids[:0]
+
+	for _, id := range s.ids {
+		j := s.items[id]
+		if j.Finished != nil && ((retention > 0 && now.Sub(*j.Finished) > retention) || (maxJobs > 0 && finished > maxJobs)) {
+			delete(s.items, id)
+			out = append(out, j)
+			finished--
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	s.ids = ids
+
+	return out
+}
+
+// update changes the status of the job, and returns a copy of it.
+func (s *store) update(j *job, fn func(*Job)) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(&j.Job)
+
+	return j.Job
+}
+
+// logBuffer is the log of a job, which is written by its patch and read by the api concurrently.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]byte(nil), b.buf.Bytes()...)
+}
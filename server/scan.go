@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/copatcher/buildkit"
+	"github.com/craftslab/copatcher/config"
+	"github.com/craftslab/copatcher/patcher"
+)
+
+const (
+	ScannerGrype = "grype"
+	ScannerTrivy = "trivy"
+
+	DefaultScanner = ScannerTrivy
+)
+
+// scanners return the command of the scanners which scans the image into a json report.
+var scanners = map[string]func(ctx context.Context, image string, insecure, plainHTTP bool) *exec.Cmd{
+	ScannerGrype: func(ctx context.Context, image string, insecure, plainHTTP bool) *exec.Cmd {
+		// #nosec G204
+		cmd := exec.CommandContext(ctx, "grype", image, "--output", "json", "--quiet")
+		var env []string
+		if insecure {
+			env = append(env, "GRYPE_REGISTRY_INSECURE_SKIP_TLS_VERIFY=true")
+		}
+		if plainHTTP {
+			env = append(env, "GRYPE_REGISTRY_INSECURE_USE_HTTP=true")
+		}
+		if len(env) != 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		return cmd
+	},
+	ScannerTrivy: func(ctx context.Context, image string, insecure, plainHTTP bool) *exec.Cmd {
+		args := []string{"image", "--format", "json", "--quiet"}
+		// trivy falls back to plain HTTP with --insecure
+		if insecure || plainHTTP {
+			args = append(args, "--insecure")
+		}
+		// #nosec G204
+		return exec.CommandContext(ctx, "trivy", append(args, image)...)
+	},
+}
+
+// validateWebhook checks the scanner and the report url of the webhook config.
+func validateWebhook(scanner, reportURL string) error {
+	if reportURL != "" {
+		if _, err := template.New("reportUrl").Parse(reportURL); err != nil {
+			return errors.Wrap(err, "invalid report url")
+		}
+		return nil
+	}
+
+	if _, ok := scanners[scanner]; !ok {
+		return errors.Errorf("unsupported scanner %s", scanner)
+	}
+
+	return nil
+}
+
+// scan writes the report of the pushed image of the job into its working folder, which is fetched from the report
+// url if configured, or scanned by the scanner otherwise.
+func (s *server) scan(ctx context.Context, j *job) error {
+	var buf []byte
+	var err error
+
+	if s.cfg.Config.Webhook.ReportURL != "" {
+		buf, err = fetchReport(ctx, s.cfg.Config.Webhook.ReportURL, j.push)
+	} else {
+		buf, err = scanImage(ctx, s.getScanner(), j.push.Image(), &j.cfg.Registry, j.logs)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(j.folder, reportFile), buf, patcher.DefaultFilePerm); err != nil {
+		return errors.Wrap(err, "failed to write report")
+	}
+
+	return nil
+}
+
+// resolvePush resolves the media type of the pushed image of the job if the event has none, e.g. for Harbor,
+// so an image index is patched for each platform.
+func (s *server) resolvePush(ctx context.Context, j *job) error {
+	if j.push.MediaType != "" {
+		return nil
+	}
+
+	mediaType, err := s.resolve(ctx, j.push.Image(), &buildkit.Registry{
+		Insecure:  j.cfg.Registry.Insecure,
+		Mirrors:   j.cfg.Registry.Mirrors,
+		PlainHTTP: j.cfg.Registry.PlainHTTP,
+	})
+	if err != nil {
+		return err
+	}
+
+	j.push.MediaType = mediaType
+	j.push.Index = isIndex(mediaType)
+
+	s.jobs.update(j, func(v *Job) {
+		v.MultiPlatform = j.push.Index
+	})
+
+	return nil
+}
+
+func (s *server) getScanner() string {
+	if s.cfg.Config.Webhook.Scanner == "" {
+		return DefaultScanner
+	}
+
+	return s.cfg.Config.Webhook.Scanner
+}
+
+// scanImage runs the scanner on the image, and writes its log to the writer.
+func scanImage(ctx context.Context, scanner, image string, reg *config.Registry, w io.Writer) ([]byte, error) {
+	fn, ok := scanners[scanner]
+	if !ok {
+		return nil, errors.Errorf("unsupported scanner %s", scanner)
+	}
+
+	var out bytes.Buffer
+
+	cmd := fn(ctx, image, reg.Insecure, reg.PlainHTTP)
+	cmd.Stdout = &out
+	cmd.Stderr = w
+
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "failed to run %s", scanner)
+	}
+
+	return out.Bytes(), nil
+}
+
+// fetchReport gets the report of the pushed image from the url of the template.
+func fetchReport(ctx context.Context, reportURL string, e *pushEvent) ([]byte, error) {
+	tmpl, err := template.New("reportUrl").Parse(reportURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid report url")
+	}
+
+	var url bytes.Buffer
+	if err := tmpl.Execute(&url, e); err != nil {
+		return nil, errors.Wrap(err, "failed to render report url")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), http.NoBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch report")
+	}
+
+	defer func() {
+		_ = rsp.Body.Close()
+	}()
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to fetch report: %s", rsp.Status)
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(rsp.Body, maxRequestSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read report")
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/util/progress/progressui"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+
+	"github.com/craftslab/copatcher/api"
+	"github.com/craftslab/copatcher/batch"
+	"github.com/craftslab/copatcher/buildkit"
+	"github.com/craftslab/copatcher/config"
+	"github.com/craftslab/copatcher/history"
+	"github.com/craftslab/copatcher/metrics"
+	"github.com/craftslab/copatcher/patcher"
+	"github.com/craftslab/copatcher/report"
+	"github.com/craftslab/copatcher/result"
+	"github.com/craftslab/copatcher/utils"
+)
+
+const (
+	DefaultAddr      = ":8080"
+	DefaultMaxJobs   = 1000
+	DefaultQueueSize = 100
+	DefaultRetention = "24h"
+	DefaultWorkers   = 1
+)
+
+const (
+	// jobFolderPattern is the pattern of the working folder of a job with its report and result.
+	jobFolderPattern = "job-*"
+	// serverFolderPattern is the pattern of the working folder of the server in the work dir.
+	serverFolderPattern = "server-*"
+	// shutdownTimeout is the time to wait for the requests in flight to finish after the server is stopped.
+	shutdownTimeout = 10 * time.Second
+	// evictInterval is the interval to evict the finished jobs beyond the retention.
+	evictInterval = time.Minute
+
+	reportFile = "report.json"
+	resultFile = "result.json"
+)
+
+type Server interface {
+	Init(context.Context) error
+	Deinit(context.Context) error
+	Run(context.Context) error
+}
+
+type Config struct {
+	Config config.Config
+	// History records the patch of each job if set.
+	History history.History
+	// Metrics are served on /metrics if set.
+	Metrics *metrics.Metrics
+	// Addr is the address to listen on, e.g. :8080.
+	Addr string
+	// GRPCAddr is the address to listen on for the grpc api, which is disabled if empty.
+	GRPCAddr string
+	// QueueSize is the number of jobs queued before new jobs are rejected.
+	QueueSize int
+	// Workers is the number of jobs patched concurrently.
+	Workers int
+}
+
+type server struct {
+	cfg        *Config
+	folder     string
+	jobs       *store
+	deliveries *deliveries
+	queue      chan *job
+	// run patches the image of the job, which is replaced in tests.
+	run func(context.Context, *job) error
+	// resolve returns the media type of a pushed image, which is replaced in tests.
+	resolve func(context.Context, string, *buildkit.Registry) (string, error)
+}
+
+func New(_ context.Context, cfg *Config) Server {
+	s := &server{
+		cfg:        cfg,
+		jobs:       newStore(),
+		deliveries: newDeliveries(),
+	}
+
+	s.run = s.patch
+	s.resolve = buildkit.ResolveMediaType
+
+	return s
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:      DefaultAddr,
+		QueueSize: DefaultQueueSize,
+		Workers:   DefaultWorkers,
+	}
+}
+
+// Init creates the working folder of the server in the work dir, which holds the folders of the jobs.
+func (s *server) Init(_ context.Context) error {
+	if s.cfg.Workers < 1 || s.cfg.QueueSize < 1 {
+		return errors.New("workers and queue size must be positive")
+	}
+
+	if s.cfg.Config.Server.Retention < 0 || s.cfg.Config.Server.MaxJobs < 0 {
+		return errors.New("retention and max jobs must not be negative")
+	}
+
+	if len(s.cfg.Config.Webhook.Images) != 0 {
+		if err := validateWebhook(s.getScanner(), s.cfg.Config.Webhook.ReportURL); err != nil {
+			return errors.Wrap(err, "invalid webhook")
+		}
+	}
+
+	workDir := s.cfg.Config.Output.WorkDir
+	if workDir == "" {
+		workDir = patcher.DefaultFolder
+	}
+
+	if err := os.MkdirAll(workDir, patcher.DefaultPerm); err != nil {
+		return errors.Wrap(err, "failed to create work dir")
+	}
+
+	folder, err := os.MkdirTemp(workDir, serverFolderPattern)
+	if err != nil {
+		return errors.Wrap(err, "failed to create working folder")
+	}
+
+	s.folder = folder
+	s.queue = make(chan *job, s.cfg.QueueSize)
+
+	return nil
+}
+
+func (s *server) Deinit(_ context.Context) error {
+	if s.folder == "" || s.cfg.Config.Output.KeepArtifacts {
+		return nil
+	}
+
+	return os.RemoveAll(s.folder)
+}
+
+// Run serves the api and patches the queued jobs until the context is canceled, which cancels the running jobs.
+func (s *server) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.cfg.Addr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if s.cfg.Config.Server.Token == "" && (!isLoopback(s.cfg.Addr) || (s.cfg.GRPCAddr != "" && !isLoopback(s.cfg.GRPCAddr))) {
+		log.Print("serve apis without authentication as server token is not set")
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.evictJobs(ctx)
+	}()
+
+	ch := make(chan error, 2)
+	go func() {
+		log.Printf("serve on %s", s.cfg.Addr)
+		ch <- srv.ListenAndServe()
+	}()
+
+	var grpcSrv *grpc.Server
+
+	if s.cfg.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", s.cfg.GRPCAddr)
+		if err != nil {
+			_ = srv.Close()
+			wg.Wait()
+			return errors.Wrap(err, "failed to listen")
+		}
+		grpcSrv = s.newGRPCServer()
+		api.RegisterPatcherServer(grpcSrv, &grpcServer{s: s})
+		go func() {
+			log.Printf("serve grpc on %s", s.cfg.GRPCAddr)
+			ch <- grpcSrv.Serve(lis)
+		}()
+	}
+
+	var err error
+
+	select {
+	case err = <-ch:
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if e := srv.Shutdown(shutdownCtx); e != nil && err == nil {
+		err = e
+	}
+
+	if grpcSrv != nil {
+		stopGRPC(shutdownCtx, grpcSrv)
+	}
+
+	wg.Wait()
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "failed to serve")
+	}
+
+	return nil
+}
+
+// stopGRPC stops the grpc server gracefully, or forcibly if the streams are not done before the context.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+// work runs the queued jobs one by one until the context is canceled.
+func (s *server) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-s.queue:
+			s.cfg.Metrics.SetQueueDepth(len(s.queue))
+			s.runJob(ctx, j)
+		}
+	}
+}
+
+// evictJobs evicts the finished jobs beyond the retention with their folders until the context is canceled.
+func (s *server) evictJobs(ctx context.Context) {
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.evict(now)
+		}
+	}
+}
+
+// evict removes the jobs finished before the retention, and the oldest finished jobs beyond the max jobs,
+// whose pushes are patched again if delivered again.
+func (s *server) evict(now time.Time) {
+	for _, j := range s.jobs.evict(now, s.cfg.Config.Server.Retention, s.cfg.Config.Server.MaxJobs) {
+		s.deliveries.releaseJob(j.ID)
+		if !s.cfg.Config.Output.KeepArtifacts {
+			_ = os.RemoveAll(j.folder)
+		}
+		log.Printf("evict job %s", j.ID)
+	}
+}
+
+func (s *server) runJob(ctx context.Context, j *job) {
+	logger := log.New(j.logs, "", log.LstdFlags)
+
+	defer j.events.close()
+
+	started := time.Now()
+	v := s.jobs.update(j, func(v *Job) {
+		v.Status = StatusRunning
+		v.Started = &started
+	})
+	j.events.add(newJobEvent(&v))
+
+	logger.Printf("start patch of %s", j.Image)
+
+	ctx = s.cfg.Metrics.WithContext(ctx)
+
+	ctx, rec := history.WithRecorder(ctx, &history.Record{
+		ID:            j.ID,
+		Mode:          history.ModeServe,
+		Image:         j.Image,
+		Tag:           j.Tag,
+		MultiPlatform: j.MultiPlatform,
+	})
+
+	err := s.run(ctx, j)
+
+	s.cfg.Metrics.ObserveJob(history.ModeServe, batch.GetStatus(err), err)
+
+	if s.cfg.History != nil {
+		r := rec.Finish(batch.GetStatus(err), err)
+		// The media type of a push may be resolved by the patch
+		r.MultiPlatform = j.MultiPlatform
+		if e := s.cfg.History.Add(ctx, r); e != nil {
+			logger.Printf("failed to add history: %v", e)
+		}
+	}
+
+	finished := time.Now()
+	v = s.jobs.update(j, func(v *Job) {
+		v.Status = batch.GetStatus(err)
+		v.Finished = &finished
+		if err != nil {
+			v.Error = err.Error()
+		}
+	})
+	j.events.add(newJobEvent(&v))
+
+	if err != nil {
+		logger.Printf("finish patch: %s", err)
+	} else {
+		logger.Print("finish patch")
+	}
+
+	log.Printf("finish job %s: %s", j.ID, batch.GetStatus(err))
+}
+
+// patch patches the image of the job, which resolves and scans the pushed image first if queued by a push, writes its logs and the progress of its solves to the log of the job, and adds its phases
+// and solve statuses to the events of the job.
+func (s *server) patch(ctx context.Context, j *job) error {
+	if j.push != nil {
+		if err := s.resolvePush(ctx, j); err != nil {
+			return errors.Wrap(err, "failed to resolve push")
+		}
+		if err := s.scan(ctx, j); err != nil {
+			return errors.Wrap(err, "failed to scan")
+		}
+	}
+
+	c, err := patcher.NewConfig(j.cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to new config")
+	}
+
+	rc := report.DefaultConfig()
+	rc.Config = *j.cfg
+
+	// The files of the profile would be overwritten by each job, so the jobs are pushed or loaded
+	c.Output = ""
+	c.ProvenanceOutput = ""
+	c.SBOMOutput = ""
+	c.VEXOutput = ""
+
+	c.Image = j.Image
+	c.Logger = log.New(j.logs, "", log.LstdFlags)
+	c.MultiPlatform = j.MultiPlatform
+	c.Report = report.New(ctx, rc)
+	c.ResultFormat = result.FormatJSON
+	c.ResultOutput = filepath.Join(j.folder, resultFile)
+
+	ctx = buildkit.WithProgressMode(ctx, progressui.PlainMode)
+	ctx = buildkit.WithProgressOutput(ctx, j.logs)
+	ctx = buildkit.WithSolveStatusHandler(ctx, func(st *client.SolveStatus) {
+		j.events.add(newSolveEvent(j.ID, st))
+	})
+	ctx = utils.WithPhaseHandler(ctx, func(e utils.PhaseEvent) {
+		j.events.add(newPhaseEvent(j.ID, &e))
+	})
+
+	pt := patcher.New(ctx, c)
+
+	if err := pt.Init(ctx); err != nil {
+		return errors.Wrap(err, "failed to init")
+	}
+
+	defer func(pt patcher.Patcher, ctx context.Context) {
+		_ = pt.Deinit(ctx)
+	}(pt, ctx)
+
+	return pt.Run(ctx, filepath.Join(j.folder, reportFile))
+}
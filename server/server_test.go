@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/config"
+	"github.com/craftslab/copatcher/history"
+	"github.com/craftslab/copatcher/metrics"
+	"github.com/craftslab/copatcher/result"
+	"github.com/craftslab/copatcher/types"
+)
+
+const (
+	testImage  = "ubuntu:22.04"
+	testReport = "../test/data/manifest.json"
+)
+
+func newTestServer(t *testing.T, queueSize int) *server {
+	c := DefaultConfig()
+	c.Config = *config.New()
+	c.Config.Output.ResultFormat = result.FormatJSON
+	c.Config.Output.WorkDir = t.TempDir()
+	c.QueueSize = queueSize
+
+	s := New(context.Background(), c).(*server)
+	assert.Equal(t, nil, s.Init(context.Background()))
+
+	t.Cleanup(func() {
+		_ = s.Deinit(context.Background())
+	})
+
+	return s
+}
+
+func newTestRequest(t *testing.T, req *Request) *http.Request {
+	buf, err := json.Marshal(req)
+	assert.Equal(t, nil, err)
+
+	return httptest.NewRequest(http.MethodPost, "/api/v1/jobs", bytes.NewReader(buf))
+}
+
+func readTestReport(t *testing.T) []byte {
+	buf, err := os.ReadFile(testReport)
+	assert.Equal(t, nil, err)
+
+	return buf
+}
+
+func TestCreateJob(t *testing.T) {
+	s := newTestServer(t, 1)
+	h := s.handler()
+
+	push := true
+
+	tests := []struct {
+		name string
+		req  *Request
+		code int
+	}{
+		{"no image", &Request{Report: readTestReport(t)}, http.StatusBadRequest},
+		{"invalid image", &Request{Image: "INVALID", Report: readTestReport(t)}, http.StatusBadRequest},
+		{"no report", &Request{Image: testImage}, http.StatusBadRequest},
+		{"invalid report", &Request{Image: testImage, Report: json.RawMessage(`{"invalid":true}`)}, http.StatusBadRequest},
+		{"invalid sbom", &Request{Image: testImage, SBOM: "invalid", Report: readTestReport(t)}, http.StatusBadRequest},
+		{"queued", &Request{Image: testImage, Tag: "patched", Push: &push, Report: readTestReport(t)}, http.StatusAccepted},
+		{"queue full", &Request{Image: testImage, Report: readTestReport(t)}, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, newTestRequest(t, tt.req))
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+		})
+	}
+
+	jobs := s.jobs.list()
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, StatusQueued, jobs[0].Status)
+	assert.Equal(t, "patched", jobs[0].Tag)
+
+	j, _, _ := s.jobs.get(jobs[0].ID)
+	assert.Equal(t, true, j.cfg.Output.Push)
+	assert.Equal(t, false, s.cfg.Config.Output.Push)
+}
+
+func TestCreateJobMultipart(t *testing.T) {
+	s := newTestServer(t, 1)
+
+	var body bytes.Buffer
+
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("image", testImage)
+	_ = mw.WriteField("ignoreErrors", "true")
+	_ = mw.WriteField("exclude", "linux-*")
+	fw, err := mw.CreateFormFile("report", "report.json")
+	assert.Equal(t, nil, err)
+	_, _ = fw.Write(readTestReport(t))
+	assert.Equal(t, nil, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/jobs", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var v Job
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &v))
+	assert.Equal(t, "/api/v1/jobs/"+v.ID, w.Header().Get("Location"))
+
+	j, _, ok := s.jobs.get(v.ID)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, true, j.cfg.Packages.IgnoreErrors)
+	assert.Equal(t, []string{"linux-*"}, j.cfg.Packages.Exclude)
+}
+
+func TestRunJob(t *testing.T) {
+	s := newTestServer(t, 2)
+	s.cfg.History = history.New(context.Background(), &history.Config{Path: filepath.Join(t.TempDir(), "history.db")})
+	assert.Equal(t, nil, s.cfg.History.Init(context.Background()))
+	s.cfg.Metrics = metrics.New()
+	h := s.handler()
+
+	s.run = func(_ context.Context, j *job) error {
+		if j.Tag == "fail" {
+			return errors.Wrap(types.NewError(types.ErrorKindPartialPatch, errors.New("failed")), "failed to patch")
+		}
+		doc := &result.Document{Image: j.Image, PatchedImage: j.Image + "-patched"}
+		buf, err := result.Generate(result.FormatJSON, doc)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(j.folder, resultFile), buf, 0o600)
+	}
+
+	var ids []string
+
+	for _, tag := range []string{"", "fail"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newTestRequest(t, &Request{Image: testImage, Tag: tag, Report: readTestReport(t)}))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		var v Job
+		assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &v))
+		ids = append(ids, v.ID)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		s.work(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, v, _ := s.jobs.get(ids[1])
+		return v.Finished != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+
+	tests := []struct {
+		name string
+		path string
+		code int
+		body string
+	}{
+		{"job", "/api/v1/jobs/" + ids[0], http.StatusOK, `"status":"patched"`},
+		{"partial job", "/api/v1/jobs/" + ids[1], http.StatusOK, `"status":"partial"`},
+		{"missing job", "/api/v1/jobs/missing", http.StatusNotFound, "job not found"},
+		{"logs", "/api/v1/jobs/" + ids[0] + "/logs", http.StatusOK, "finish patch"},
+		{"result", "/api/v1/jobs/" + ids[0] + "/result", http.StatusOK, `"patchedImage": "ubuntu:22.04-patched"`},
+		{"markdown result", "/api/v1/jobs/" + ids[0] + "/result?format=markdown", http.StatusOK, "ubuntu:22.04-patched"},
+		{"invalid format", "/api/v1/jobs/" + ids[0] + "/result?format=invalid", http.StatusBadRequest, "error"},
+		{"missing result", "/api/v1/jobs/" + ids[1] + "/result", http.StatusNotFound, "result not found"},
+		{"list", "/api/v1/jobs", http.StatusOK, ids[1]},
+		{"health", "/healthz", http.StatusOK, "ok"},
+		{"metrics", "/metrics", http.StatusOK, `copatcher_jobs_total{mode="serve",status="partial"} 1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, http.NoBody))
+			assert.Equal(t, tt.code, w.Code)
+			assert.Contains(t, w.Body.String(), tt.body)
+		})
+	}
+
+	records, err := s.cfg.History.Query(context.Background(), &history.Query{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, ids[1], records[0].ID)
+	assert.Equal(t, history.ModeServe, records[0].Mode)
+	assert.Equal(t, "partial", records[0].Status)
+	assert.Equal(t, "failed to patch: failed", records[0].Error)
+}
+
+func TestEvict(t *testing.T) {
+	s := newTestServer(t, 10)
+	s.cfg.Config.Server.Retention = time.Hour
+	s.cfg.Config.Server.MaxJobs = 2
+
+	now := time.Now()
+	finished := []*time.Time{nil, timePtr(now.Add(-2 * time.Hour)), timePtr(now.Add(-3 * time.Minute)),
+		timePtr(now.Add(-2 * time.Minute)), timePtr(now.Add(-time.Minute))}
+
+	var jobs []*job
+
+	for i := range finished {
+		j, err := s.newJob(context.Background(), &Request{Image: testImage, Report: readTestReport(t)})
+		assert.Equal(t, nil, err)
+		j.Finished = finished[i]
+		s.jobs.add(j)
+		jobs = append(jobs, j)
+	}
+
+	_, ok := s.deliveries.claim("localhost:5000/nginx@sha256:1234", jobs[1].ID)
+	assert.Equal(t, true, ok)
+
+	s.evict(now)
+
+	var ids []string
+	for _, v := range s.jobs.list() {
+		ids = append(ids, v.ID)
+	}
+
+	// The expired job and the oldest finished job beyond the max jobs are evicted, and the running job is kept
+	assert.Equal(t, []string{jobs[0].ID, jobs[3].ID, jobs[4].ID}, ids)
+	assert.NoDirExists(t, jobs[1].folder)
+	assert.NoDirExists(t, jobs[2].folder)
+	assert.DirExists(t, jobs[3].folder)
+
+	_, ok = s.deliveries.claim("localhost:5000/nginx@sha256:1234", jobs[3].ID)
+	assert.Equal(t, true, ok)
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/copatcher/patcher"
+)
+
+const (
+	distributionActionPush = "push"
+	harborTypePush         = "PUSH_ARTIFACT"
+
+	dockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// accessoryTag matches the tags of the signatures and attestations of an image, e.g. sha256-<hex>.sig.
+var accessoryTag = regexp.MustCompile(`^sha256-[0-9a-f]{64}\.(att|sig)$`)
+
+// pushEvent is the push of an image to a registry, which is the data of the report url template.
+type pushEvent struct {
+	// Host is the registry host, e.g. registry.example.com:5000.
+	Host string
+	// Repository is the repository in the registry, e.g. apps/nginx.
+	Repository string
+	Tag        string
+	Digest     digest.Digest
+	// MediaType is the media type of the pushed manifest, which is resolved by the job if unknown, e.g. for Harbor.
+	MediaType string
+	// Index is true if the image is an image index of platforms.
+	Index bool
+	// ArtifactType is the type of an artifact pushed as a manifest, e.g. an attestation or a signature.
+	ArtifactType string
+}
+
+// Name returns the name of the image, e.g. registry.example.com:5000/apps/nginx.
+func (e *pushEvent) Name() string {
+	return e.Host + "/" + e.Repository
+}
+
+// Image returns the reference of the pushed image by digest, e.g. registry.example.com:5000/apps/nginx@sha256:<hex>,
+// so the image scanned and patched is the one pushed even if the tag is pushed again.
+func (e *pushEvent) Image() string {
+	return e.Name() + "@" + e.Digest.String()
+}
+
+// distributionEnvelope is the notification of Docker Distribution.
+type distributionEnvelope struct {
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			MediaType    string        `json:"mediaType"`
+			ArtifactType string        `json:"artifactType"`
+			Digest       digest.Digest `json:"digest"`
+			Repository   string        `json:"repository"`
+			Tag          string        `json:"tag"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+// harborEvent is the webhook payload of Harbor.
+type harborEvent struct {
+	Type      string `json:"type"`
+	EventData struct {
+		Resources []struct {
+			Digest      digest.Digest `json:"digest"`
+			Tag         string        `json:"tag"`
+			ResourceURL string        `json:"resource_url"`
+		} `json:"resources"`
+	} `json:"event_data"`
+}
+
+// deliveries keeps the digests of the pushed images with jobs, so the redeliveries of a push are not patched again.
+type deliveries struct {
+	mu    sync.Mutex
+	items map[string]string
+}
+
+func newDeliveries() *deliveries {
+	return &deliveries{
+		items: map[string]string{},
+	}
+}
+
+// claim records the job of the pushed image, and returns the job of the digest instead if it is recorded already.
+func (d *deliveries) claim(key, id string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if v, ok := d.items[key]; ok {
+		return v, false
+	}
+
+	d.items[key] = id
+
+	return id, true
+}
+
+func (d *deliveries) release(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.items, key)
+}
+
+// releaseJob releases the pushes of the job, e.g. after it is evicted.
+func (d *deliveries) releaseJob(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for k, v := range d.items {
+		if v == id {
+			delete(d.items, k)
+		}
+	}
+}
+
+func (s *server) handleDistribution(w http.ResponseWriter, r *http.Request) {
+	var env distributionEnvelope
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&env); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "failed to decode notification"))
+		return
+	}
+
+	var events []*pushEvent
+
+	for _, e := range env.Events {
+		if e.Action != distributionActionPush || !isManifest(e.Target.MediaType) {
+			continue
+		}
+		events = append(events, &pushEvent{
+			Host:         e.Request.Host,
+			Repository:   e.Target.Repository,
+			Tag:          e.Target.Tag,
+			Digest:       e.Target.Digest,
+			MediaType:    e.Target.MediaType,
+			Index:        isIndex(e.Target.MediaType),
+			ArtifactType: e.Target.ArtifactType,
+		})
+	}
+
+	s.handlePush(w, events)
+}
+
+func (s *server) handleHarbor(w http.ResponseWriter, r *http.Request) {
+	var e harborEvent
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&e); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "failed to decode event"))
+		return
+	}
+
+	var events []*pushEvent
+
+	if e.Type == harborTypePush {
+		for _, res := range e.EventData.Resources {
+			named, err := reference.ParseNormalizedNamed(res.ResourceURL)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid resource url"))
+				return
+			}
+			events = append(events, &pushEvent{
+				Host:       reference.Domain(named),
+				Repository: reference.Path(named),
+				Tag:        res.Tag,
+				Digest:     res.Digest,
+			})
+		}
+	}
+
+	s.handlePush(w, events)
+}
+
+// handlePush queues a job for each pushed image matching the patterns, which returns 202 with the jobs, or 200 if
+// no job is queued. The deliveries of the pushes with jobs already return the jobs with 200.
+func (s *server) handlePush(w http.ResponseWriter, events []*pushEvent) {
+	code := http.StatusOK
+	out := []Job{}
+
+	for _, e := range events {
+		if !s.isWatched(e) {
+			continue
+		}
+		key := e.Name() + "@" + e.Digest.String()
+		id, ok := s.deliveries.claim(key, uuid.NewString())
+		if !ok {
+			if _, v, found := s.jobs.get(id); found {
+				out = append(out, v)
+			}
+			continue
+		}
+		v, err := s.submitPush(id, e)
+		switch {
+		case errors.Is(err, errQueueFull):
+			// The registry redelivers the push later
+			s.deliveries.release(key)
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		case err != nil:
+			s.deliveries.release(key)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Printf("queue job %s for push of %s:%s", v.ID, e.Name(), e.Tag)
+		code = http.StatusAccepted
+		out = append(out, v)
+	}
+
+	writeJSON(w, code, out)
+}
+
+// isWatched returns true if the pushed image is tagged and matches the patterns, and is not a patched image.
+// The pushes without a tag are skipped, e.g. the manifests of the platforms and attestations of a pushed index,
+// and so are the signatures and attestations of the images.
+func (s *server) isWatched(e *pushEvent) bool {
+	if e.Host == "" || e.Repository == "" || e.Tag == "" || e.Digest == "" || e.ArtifactType != "" {
+		return false
+	}
+
+	if tag := e.Tag; tag == s.cfg.Config.Output.Tag || strings.HasSuffix(tag, "-"+patcher.DefaultTag) || accessoryTag.MatchString(tag) {
+		return false
+	}
+
+	for _, pattern := range s.cfg.Config.Webhook.Images {
+		if ok, _ := path.Match(pattern, e.Name()); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// submitPush queues the job of the pushed image, whose report is scanned by the job.
+func (s *server) submitPush(id string, e *pushEvent) (Job, error) {
+	cfg := s.cfg.Config.Clone()
+	cfg.Output.Push = true
+
+	// The image is patched by digest, so the tag of the patched image is derived from the pushed tag
+	if cfg.Output.Tag == "" {
+		cfg.Output.Tag = e.Tag + "-" + patcher.DefaultTag
+	}
+
+	folder, err := s.newJobFolder()
+	if err != nil {
+		return Job{}, err
+	}
+
+	j := &job{
+		Job: Job{
+			ID:            id,
+			Image:         e.Image(),
+			Tag:           cfg.Output.Tag,
+			MultiPlatform: e.Index,
+			Status:        StatusQueued,
+			Created:       time.Now(),
+		},
+		cfg:    cfg,
+		folder: folder,
+		logs:   &logBuffer{},
+		events: newEventLog(),
+		push:   e,
+	}
+
+	return s.enqueue(j)
+}
+
+func isIndex(mediaType string) bool {
+	return mediaType == ispec.MediaTypeImageIndex || mediaType == dockerManifestList
+}
+
+func isManifest(mediaType string) bool {
+	switch mediaType {
+	case ispec.MediaTypeImageManifest, ispec.MediaTypeImageIndex, dockerManifest, dockerManifestList:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkSecret checks the Authorization header of the delivery if the webhook has a secret.
+func (s *server) checkSecret(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := s.cfg.Config.Webhook.Secret
+		if secret != "" && !isAuthorized(r.Header.Get(authorizationHeader), secret) {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid secret"))
+			return
+		}
+		next(w, r)
+	}
+}
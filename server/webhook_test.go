@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/copatcher/buildkit"
+)
+
+const (
+	testDigest = "sha256:fea8895f450959fa676bcc1df0611ea93823a735a01205fd8622846041d0c7cf"
+
+	testDistributionEvents = `{
+  "events": [
+    {
+      "action": "push",
+      "target": {"mediaType": "application/octet-stream", "digest": "sha256:1111", "repository": "apps/nginx"},
+      "request": {"host": "registry.example.com:5000"}
+    },
+    {
+      "action": "push",
+      "target": {"mediaType": "application/vnd.oci.image.index.v1+json", "digest": "` + testDigest + `", "repository": "apps/nginx", "tag": "1.25"},
+      "request": {"host": "registry.example.com:5000"}
+    },
+    {
+      "action": "push",
+      "target": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:2222", "repository": "apps/nginx", "tag": "1.25-patched"},
+      "request": {"host": "registry.example.com:5000"}
+    },
+    {
+      "action": "push",
+      "target": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:3333", "repository": "tools/curl", "tag": "8"},
+      "request": {"host": "registry.example.com:5000"}
+    },
+    {
+      "action": "push",
+      "target": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:5555", "repository": "apps/nginx"},
+      "request": {"host": "registry.example.com:5000"}
+    },
+    {
+      "action": "push",
+      "target": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:6666", "repository": "apps/nginx", "tag": "sha256-fea8895f450959fa676bcc1df0611ea93823a735a01205fd8622846041d0c7cf.sig"},
+      "request": {"host": "registry.example.com:5000"}
+    },
+    {
+      "action": "push",
+      "target": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "artifactType": "application/vnd.in-toto+json", "digest": "sha256:7777", "repository": "apps/nginx", "tag": "1.25-attestation"},
+      "request": {"host": "registry.example.com:5000"}
+    },
+    {
+      "action": "pull",
+      "target": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:4444", "repository": "apps/redis", "tag": "7"},
+      "request": {"host": "registry.example.com:5000"}
+    }
+  ]
+}`
+
+	testHarborEvent = `{
+  "type": "PUSH_ARTIFACT",
+  "occur_at": 1700000000,
+  "operator": "admin",
+  "event_data": {
+    "resources": [
+      {"digest": "` + testDigest + `", "tag": "1.25", "resource_url": "harbor.example.com/apps/nginx:1.25"}
+    ],
+    "repository": {"name": "nginx", "namespace": "apps", "repo_full_name": "apps/nginx", "repo_type": "private"}
+  }
+}`
+)
+
+func newTestWebhookServer(t *testing.T, queueSize int) *server {
+	s := newTestServer(t, queueSize)
+	s.cfg.Config.Webhook.Images = []string{"registry.example.com:5000/apps/*", "harbor.example.com/apps/*"}
+	s.cfg.Config.Webhook.Secret = "secret"
+
+	return s
+}
+
+func postWebhook(t *testing.T, h http.Handler, path, body, secret string) ([]Job, int) {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Authorization", secret)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var jobs []Job
+	if w.Code == http.StatusOK || w.Code == http.StatusAccepted {
+		assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &jobs))
+	}
+
+	return jobs, w.Code
+}
+
+func TestHandleDistribution(t *testing.T) {
+	s := newTestWebhookServer(t, 2)
+	h := s.handler()
+
+	_, code := postWebhook(t, h, "/api/v1/webhooks/distribution", testDistributionEvents, "invalid")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	jobs, code := postWebhook(t, h, "/api/v1/webhooks/distribution", testDistributionEvents, "Bearer secret")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "registry.example.com:5000/apps/nginx@"+testDigest, jobs[0].Image)
+	assert.Equal(t, "1.25-patched", jobs[0].Tag)
+	assert.Equal(t, true, jobs[0].MultiPlatform)
+
+	j, _, ok := s.jobs.get(jobs[0].ID)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, true, j.cfg.Output.Push)
+	assert.Equal(t, "apps/nginx", j.push.Repository)
+
+	// The redelivery returns the job of the digest
+	redelivered, code := postWebhook(t, h, "/api/v1/webhooks/distribution", testDistributionEvents, "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, jobs, redelivered)
+	assert.Equal(t, 1, len(s.jobs.list()))
+
+	_, code = postWebhook(t, h, "/api/v1/webhooks/distribution", "invalid", "secret")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestHandleHarbor(t *testing.T) {
+	s := newTestWebhookServer(t, 1)
+	h := s.handler()
+
+	jobs, code := postWebhook(t, h, "/api/v1/webhooks/harbor", testHarborEvent, "secret")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "harbor.example.com/apps/nginx@"+testDigest, jobs[0].Image)
+	// The media type of the push is resolved by the job
+	assert.Equal(t, false, jobs[0].MultiPlatform)
+
+	// The push of another digest is rejected if the queue is full, and queued on its redelivery
+	event := strings.ReplaceAll(testHarborEvent, testDigest, "sha256:5555")
+
+	_, code = postWebhook(t, h, "/api/v1/webhooks/harbor", event, "secret")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	<-s.queue
+
+	jobs, code = postWebhook(t, h, "/api/v1/webhooks/harbor", event, "secret")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, 1, len(jobs))
+
+	jobs, code = postWebhook(t, h, "/api/v1/webhooks/harbor", `{"type": "DELETE_ARTIFACT"}`, "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0, len(jobs))
+}
+
+func TestIsWatched(t *testing.T) {
+	s := newTestWebhookServer(t, 1)
+	s.cfg.Config.Output.Tag = "stable"
+
+	tests := []struct {
+		name  string
+		event *pushEvent
+		want  bool
+	}{
+		{"matched", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "1.25", Digest: testDigest}, true},
+		{"by digest", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Digest: testDigest}, false},
+		{"nested", &pushEvent{Host: "harbor.example.com", Repository: "apps/web/nginx", Tag: "1.25", Digest: testDigest}, false},
+		{"other host", &pushEvent{Host: "docker.io", Repository: "apps/nginx", Tag: "1.25", Digest: testDigest}, false},
+		{"default tag", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "1.25-patched", Digest: testDigest}, false},
+		{"output tag", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "stable", Digest: testDigest}, false},
+		{"no digest", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "1.25"}, false},
+		{"signature", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "sha256-fea8895f450959fa676bcc1df0611ea93823a735a01205fd8622846041d0c7cf.sig", Digest: testDigest}, false},
+		{"attestation", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "sha256-fea8895f450959fa676bcc1df0611ea93823a735a01205fd8622846041d0c7cf.att", Digest: testDigest}, false},
+		{"artifact", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "1.25", Digest: testDigest, ArtifactType: "application/vnd.in-toto+json"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, s.isWatched(tt.event))
+		})
+	}
+}
+
+func TestScanners(t *testing.T) {
+	cmd := scanners[ScannerTrivy](context.Background(), testImage, true, false)
+	assert.Equal(t, []string{"trivy", "image", "--format", "json", "--quiet", "--insecure", testImage}, cmd.Args)
+
+	cmd = scanners[ScannerTrivy](context.Background(), testImage, false, true)
+	assert.Equal(t, []string{"trivy", "image", "--format", "json", "--quiet", "--insecure", testImage}, cmd.Args)
+
+	cmd = scanners[ScannerGrype](context.Background(), testImage, false, false)
+	assert.Equal(t, []string{"grype", testImage, "--output", "json", "--quiet"}, cmd.Args)
+	assert.Equal(t, 0, len(cmd.Env))
+
+	cmd = scanners[ScannerGrype](context.Background(), testImage, false, true)
+	assert.Contains(t, cmd.Env, "GRYPE_REGISTRY_INSECURE_USE_HTTP=true")
+	assert.NotContains(t, cmd.Env, "GRYPE_REGISTRY_INSECURE_SKIP_TLS_VERIFY=true")
+
+	assert.Equal(t, nil, validateWebhook(ScannerGrype, ""))
+	assert.NotEqual(t, nil, validateWebhook("invalid", ""))
+	assert.Equal(t, nil, validateWebhook("invalid", "https://reports.example.com/{{.Digest}}.json"))
+	assert.NotEqual(t, nil, validateWebhook("", "https://reports.example.com/{{.Digest"))
+}
+
+func TestScan(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apps/nginx/"+testDigest+".json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(readTestReport(t))
+	}))
+	defer srv.Close()
+
+	s := newTestWebhookServer(t, 1)
+	s.cfg.Config.Webhook.ReportURL = srv.URL + "/{{.Repository}}/{{.Digest}}.json"
+
+	j := &job{
+		folder: t.TempDir(),
+		push:   &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "1.25", Digest: testDigest},
+	}
+
+	assert.Equal(t, nil, s.scan(context.Background(), j))
+
+	buf, err := os.ReadFile(filepath.Join(j.folder, reportFile))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, readTestReport(t), buf)
+
+	j.push.Digest = "sha256:5555"
+	assert.NotEqual(t, nil, s.scan(context.Background(), j))
+}
+
+func TestResolvePush(t *testing.T) {
+	s := newTestWebhookServer(t, 1)
+
+	var refs []string
+
+	s.resolve = func(_ context.Context, ref string, _ *buildkit.Registry) (string, error) {
+		refs = append(refs, ref)
+		if ref == "harbor.example.com/apps/nginx@sha256:5555" {
+			return "", errors.New("not found")
+		}
+		return ispec.MediaTypeImageIndex, nil
+	}
+
+	tests := []struct {
+		name  string
+		event *pushEvent
+		index bool
+		err   bool
+	}{
+		{"index", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "1.25", Digest: testDigest}, true, false},
+		{"known", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "1.25", Digest: testDigest, MediaType: ispec.MediaTypeImageManifest}, false, false},
+		{"unresolved", &pushEvent{Host: "harbor.example.com", Repository: "apps/nginx", Tag: "1.25", Digest: "sha256:5555"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{cfg: s.cfg.Config.Clone(), push: tt.event}
+			err := s.resolvePush(context.Background(), j)
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, tt.index, j.MultiPlatform)
+			assert.Equal(t, tt.index, j.push.Index)
+		})
+	}
+
+	assert.Equal(t, []string{"harbor.example.com/apps/nginx@" + testDigest, "harbor.example.com/apps/nginx@sha256:5555"}, refs)
+}
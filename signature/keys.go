@@ -0,0 +1,146 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	EnvPassword = "COSIGN_PASSWORD"
+)
+
+// PEM block types of the supported key files.
+const (
+	pemCosignEncrypted   = "ENCRYPTED COSIGN PRIVATE KEY"
+	pemSigstoreEncrypted = "ENCRYPTED SIGSTORE PRIVATE KEY"
+	pemECPrivateKey      = "EC PRIVATE KEY"
+	pemPrivateKey        = "PRIVATE KEY"
+	pemPublicKey         = "PUBLIC KEY"
+)
+
+const (
+	kdfScrypt       = "scrypt"
+	cipherSecretbox = "nacl/secretbox"
+	keyLen          = 32
+	nonceLen        = 24
+)
+
+// encryptedKey is the encrypted private key generated by cosign generate-key-pair.
+type encryptedKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// LoadPrivateKey loads the ecdsa or ed25519 private key of the PEM file, which is either a PKCS #8 or SEC 1 key,
+// or a cosign key encrypted with the password.
+func LoadPrivateKey(name string, password []byte) (crypto.Signer, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key")
+	}
+
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, errors.New("failed to decode pem")
+	}
+
+	var key any
+
+	switch block.Type {
+	case pemCosignEncrypted, pemSigstoreEncrypted:
+		der, e := decryptKey(block.Bytes, password)
+		if e != nil {
+			return nil, errors.Wrap(e, "failed to decrypt key")
+		}
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	case pemECPrivateKey:
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case pemPrivateKey:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.Errorf("unsupported pem type %s", block.Type)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse private key")
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported key type %T", key)
+	}
+
+	return signer, nil
+}
+
+// LoadPublicKey loads the ecdsa or ed25519 public key of the PEM file, e.g. cosign.pub.
+func LoadPublicKey(name string) (crypto.PublicKey, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key")
+	}
+
+	block, _ := pem.Decode(buf)
+	if block == nil || block.Type != pemPublicKey {
+		return nil, errors.New("failed to decode public key pem")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse public key")
+	}
+
+	return key, nil
+}
+
+func decryptKey(buf, password []byte) ([]byte, error) {
+	var k encryptedKey
+	if err := json.Unmarshal(buf, &k); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal encrypted key")
+	}
+
+	if k.KDF.Name != kdfScrypt || k.Cipher.Name != cipherSecretbox {
+		return nil, errors.Errorf("unsupported encryption %s with %s", k.Cipher.Name, k.KDF.Name)
+	}
+
+	if len(k.Cipher.Nonce) != nonceLen {
+		return nil, errors.New("invalid nonce")
+	}
+
+	secret, err := scrypt.Key(password, k.KDF.Salt, k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P, keyLen)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive key")
+	}
+
+	var key [keyLen]byte
+	var nonce [nonceLen]byte
+
+	copy(key[:], secret)
+	copy(nonce[:], k.Cipher.Nonce)
+
+	out, ok := secretbox.Open(nil, k.Ciphertext, &nonce, &key)
+	if !ok {
+		return nil, errors.New("invalid password")
+	}
+
+	return out, nil
+}
@@ -0,0 +1,111 @@
+package signature
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+func writePEM(t *testing.T, name, typ string, der []byte) string {
+	name = filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+
+	return name
+}
+
+func encryptKey(t *testing.T, der, password []byte) []byte {
+	var k encryptedKey
+
+	k.KDF.Name = kdfScrypt
+	k.KDF.Params.N = 1024
+	k.KDF.Params.R = 8
+	k.KDF.Params.P = 1
+	k.KDF.Salt = []byte("0123456789abcdef0123456789abcdef")
+	k.Cipher.Name = cipherSecretbox
+	k.Cipher.Nonce = []byte("0123456789abcdef01234567")
+
+	secret, err := scrypt.Key(password, k.KDF.Salt, k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P, keyLen)
+	assert.NoError(t, err)
+
+	var key [keyLen]byte
+	var nonce [nonceLen]byte
+
+	copy(key[:], secret)
+	copy(nonce[:], k.Cipher.Nonce)
+
+	k.Ciphertext = secretbox.Seal(nil, der, &nonce, &key)
+
+	buf, err := json.Marshal(k)
+	assert.NoError(t, err)
+
+	return buf
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	signers := newTestSigners(t)
+	ecKey := signers["ecdsa"].(*ecdsa.PrivateKey)
+	edKey := signers["ed25519"].(ed25519.PrivateKey)
+
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	assert.NoError(t, err)
+
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NoError(t, err)
+
+	t.Run("ec private key", func(t *testing.T) {
+		signer, err := LoadPrivateKey(writePEM(t, "ec.key", pemECPrivateKey, ecDER), nil)
+		assert.NoError(t, err)
+		assert.True(t, ecKey.Equal(signer))
+	})
+
+	t.Run("pkcs8 private key", func(t *testing.T) {
+		signer, err := LoadPrivateKey(writePEM(t, "ed.key", pemPrivateKey, edDER), nil)
+		assert.NoError(t, err)
+		assert.True(t, edKey.Equal(signer))
+	})
+
+	t.Run("encrypted cosign key", func(t *testing.T) {
+		name := writePEM(t, "cosign.key", pemSigstoreEncrypted, encryptKey(t, edDER, []byte("secret")))
+		signer, err := LoadPrivateKey(name, []byte("secret"))
+		assert.NoError(t, err)
+		assert.True(t, edKey.Equal(signer))
+
+		_, err = LoadPrivateKey(name, []byte("wrong"))
+		assert.Error(t, err)
+	})
+
+	t.Run("unsupported pem", func(t *testing.T) {
+		_, err := LoadPrivateKey(writePEM(t, "cert.pem", "CERTIFICATE", []byte("invalid")), nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadPrivateKey(filepath.Join(t.TempDir(), "missing.key"), nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(edKey.Public())
+	assert.NoError(t, err)
+
+	pub, err := LoadPublicKey(writePEM(t, "cosign.pub", pemPublicKey, der))
+	assert.NoError(t, err)
+	assert.True(t, edKey.Public().(ed25519.PublicKey).Equal(pub))
+
+	_, err = LoadPublicKey(writePEM(t, "ec.key", pemECPrivateKey, der))
+	assert.Error(t, err)
+}
@@ -0,0 +1,178 @@
+package signature
+
+import (
+	"crypto"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/moby/buildkit/client/ociindex"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	blobsDir = "blobs"
+	blobPerm = 0o644
+	dirPerm  = 0o755
+)
+
+// WriteLayout signs the image manifest of the repository in the OCI layout, storing the signature manifest
+// in the layout with the ref name <repo>:sha256-<hex>.sig.
+func WriteLayout(dir, repo string, dgst digest.Digest, signer crypto.Signer) error {
+	payload, err := NewPayload(repo, dgst)
+	if err != nil {
+		return errors.Wrap(err, "failed to create payload")
+	}
+
+	sig, err := Sign(signer, payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign payload")
+	}
+
+	idx := ociindex.NewStoreIndex(dir)
+	refName := repo + ":" + Tag(dgst)
+
+	existing, err := readLayoutManifest(dir, refName)
+	if err != nil {
+		return errors.Wrap(err, "failed to read signature manifest")
+	}
+
+	mfst, config, layer, err := AppendSignature(existing, payload, sig)
+	if err != nil {
+		return errors.Wrap(err, "failed to append signature")
+	}
+
+	for _, b := range []Blob{layer, config, mfst} {
+		if err := writeBlob(dir, b); err != nil {
+			return errors.Wrap(err, "failed to write blob")
+		}
+	}
+
+	if err := idx.Put(refName, mfst.Descriptor); err != nil {
+		return errors.Wrap(err, "failed to put index")
+	}
+
+	return nil
+}
+
+// VerifyLayout checks the signature of the image in the OCI layout with the public key and returns its digest.
+// The image is the ref name of the image in the layout, which may be empty if the layout has a single image.
+func VerifyLayout(dir, image string, pub crypto.PublicKey) (string, digest.Digest, error) {
+	desc, err := findLayoutImage(dir, image)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to find image")
+	}
+
+	refName := desc.Annotations[ispec.AnnotationRefName]
+	repo := refName
+	if i := strings.LastIndex(refName, ":"); i > strings.LastIndex(refName, "/") {
+		repo = refName[:i]
+	}
+
+	manifest, err := readLayoutManifest(dir, repo+":"+Tag(desc.Digest))
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to read signature manifest")
+	}
+
+	if manifest == nil {
+		return "", "", errors.Errorf("no signature found for %s", desc.Digest)
+	}
+
+	err = VerifyManifest(manifest, pub, desc.Digest, func(d ispec.Descriptor) ([]byte, error) {
+		return readBlob(dir, d)
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to verify manifest")
+	}
+
+	return refName, desc.Digest, nil
+}
+
+func findLayoutImage(dir, image string) (*ispec.Descriptor, error) {
+	index, err := ociindex.NewStoreIndex(dir).Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read index")
+	}
+
+	var found []ispec.Descriptor
+
+	for _, m := range index.Manifests {
+		refName := m.Annotations[ispec.AnnotationRefName]
+		if strings.HasSuffix(refName, TagSuffix) {
+			continue
+		}
+		if image == "" || refName == image {
+			found = append(found, m)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, errors.Errorf("image %q not found", image)
+	case 1:
+		return &found[0], nil
+	default:
+		return nil, errors.New("more than one image found, specify the image")
+	}
+}
+
+func readLayoutManifest(dir, refName string) (*ispec.Manifest, error) {
+	if _, err := os.Stat(filepath.Join(dir, ispec.ImageIndexFile)); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	desc, err := ociindex.NewStoreIndex(dir).Get(refName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get index")
+	}
+
+	if desc == nil {
+		return nil, nil
+	}
+
+	buf, err := readBlob(dir, *desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read blob")
+	}
+
+	var m ispec.Manifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal manifest")
+	}
+
+	return &m, nil
+}
+
+func getBlobPath(dir string, dgst digest.Digest) string {
+	return filepath.Join(dir, blobsDir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+func writeBlob(dir string, b Blob) error {
+	name := getBlobPath(dir, b.Descriptor.Digest)
+
+	if err := os.MkdirAll(filepath.Dir(name), dirPerm); err != nil {
+		return errors.Wrap(err, "failed to create blobs folder")
+	}
+
+	return os.WriteFile(name, b.Data, blobPerm)
+}
+
+func readBlob(dir string, desc ispec.Descriptor) ([]byte, error) {
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid digest")
+	}
+
+	buf, err := os.ReadFile(getBlobPath(dir, desc.Digest))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read file")
+	}
+
+	if digest.FromBytes(buf) != desc.Digest {
+		return nil, errors.Errorf("digest mismatch %s", desc.Digest)
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,65 @@
+package signature
+
+import (
+	"os"
+	"testing"
+
+	"github.com/moby/buildkit/client/ociindex"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteVerifyLayout(t *testing.T) {
+	dir := t.TempDir()
+	signer := newTestSigners(t)["ecdsa"]
+
+	image := NewBlob(ispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2}`))
+	assert.NoError(t, writeBlob(dir, image))
+	assert.NoError(t, ociindex.NewStoreIndex(dir).Put("docker.io/library/ubuntu:22.04-patched", image.Descriptor))
+
+	t.Run("unsigned", func(t *testing.T) {
+		_, _, err := VerifyLayout(dir, "", signer.Public())
+		assert.Error(t, err)
+	})
+
+	assert.NoError(t, WriteLayout(dir, "docker.io/library/ubuntu", image.Descriptor.Digest, signer))
+
+	t.Run("signed", func(t *testing.T) {
+		name, dgst, err := VerifyLayout(dir, "", signer.Public())
+		assert.NoError(t, err)
+		assert.Equal(t, "docker.io/library/ubuntu:22.04-patched", name)
+		assert.Equal(t, image.Descriptor.Digest, dgst)
+
+		_, _, err = VerifyLayout(dir, "docker.io/library/ubuntu:22.04-patched", signer.Public())
+		assert.NoError(t, err)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		_, _, err := VerifyLayout(dir, "", newTestSigners(t)["ecdsa"].Public())
+		assert.Error(t, err)
+	})
+
+	t.Run("missing image", func(t *testing.T) {
+		_, _, err := VerifyLayout(dir, "docker.io/library/ubuntu:22.04", signer.Public())
+		assert.Error(t, err)
+	})
+
+	t.Run("append signature", func(t *testing.T) {
+		other := newTestSigners(t)["ed25519"]
+		assert.NoError(t, WriteLayout(dir, "docker.io/library/ubuntu", image.Descriptor.Digest, other))
+		_, _, err := VerifyLayout(dir, "", signer.Public())
+		assert.NoError(t, err)
+		_, _, err = VerifyLayout(dir, "", other.Public())
+		assert.NoError(t, err)
+	})
+
+	t.Run("tampered payload", func(t *testing.T) {
+		m, err := readLayoutManifest(dir, "docker.io/library/ubuntu:"+Tag(image.Descriptor.Digest))
+		assert.NoError(t, err)
+		for _, l := range m.Layers {
+			assert.NoError(t, os.WriteFile(getBlobPath(dir, l.Digest), []byte("tampered"), blobPerm))
+		}
+		_, _, err = VerifyLayout(dir, "", signer.Public())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,157 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"io"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxBlobSize limits the size of the signature blobs read from the registry.
+	maxBlobSize = 4 << 20
+)
+
+// Push signs the image manifest of the repository and pushes the signature manifest
+// with the tag sha256-<hex>.sig, appending to the signatures already pushed.
+func Push(ctx context.Context, resolver remotes.Resolver, repo string, dgst digest.Digest, signer crypto.Signer) error {
+	payload, err := NewPayload(repo, dgst)
+	if err != nil {
+		return errors.Wrap(err, "failed to create payload")
+	}
+
+	sig, err := Sign(signer, payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign payload")
+	}
+
+	ref := repo + ":" + Tag(dgst)
+
+	existing, _, err := fetchSignatures(ctx, resolver, ref)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return errors.Wrap(err, "failed to fetch signature manifest")
+	}
+
+	mfst, config, layer, err := AppendSignature(existing, payload, sig)
+	if err != nil {
+		return errors.Wrap(err, "failed to append signature")
+	}
+
+	pusher, err := resolver.Pusher(ctx, ref)
+	if err != nil {
+		return errors.Wrap(err, "failed to get pusher")
+	}
+
+	// Push the manifest last so that its blobs are present
+	for _, b := range []Blob{layer, config, mfst} {
+		if err := pushBlob(ctx, pusher, b); err != nil {
+			return errors.Wrapf(err, "failed to push %s", b.Descriptor.Digest)
+		}
+	}
+
+	return nil
+}
+
+// VerifyRegistry checks the signature of the image in the registry with the public key and returns its digest.
+func VerifyRegistry(ctx context.Context, resolver remotes.Resolver, image string, pub crypto.PublicKey) (digest.Digest, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse normalized named")
+	}
+
+	_, desc, err := resolver.Resolve(ctx, reference.TagNameOnly(named).String())
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve image")
+	}
+
+	manifest, fetcher, err := fetchSignatures(ctx, resolver, named.Name()+":"+Tag(desc.Digest))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to fetch signature manifest")
+	}
+
+	err = VerifyManifest(manifest, pub, desc.Digest, func(d ispec.Descriptor) ([]byte, error) {
+		return fetchBlob(ctx, fetcher, d)
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to verify manifest")
+	}
+
+	return desc.Digest, nil
+}
+
+func fetchSignatures(ctx context.Context, resolver remotes.Resolver, ref string) (*ispec.Manifest, remotes.Fetcher, error) {
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get fetcher")
+	}
+
+	buf, err := fetchBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to fetch manifest")
+	}
+
+	var m ispec.Manifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to unmarshal manifest")
+	}
+
+	return &m, fetcher, nil
+}
+
+func fetchBlob(ctx context.Context, fetcher remotes.Fetcher, desc ispec.Descriptor) ([]byte, error) {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch")
+	}
+
+	defer func(rc io.ReadCloser) {
+		_ = rc.Close()
+	}(rc)
+
+	buf, err := io.ReadAll(io.LimitReader(rc, maxBlobSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read")
+	}
+
+	if digest.FromBytes(buf) != desc.Digest {
+		return nil, errors.Errorf("digest mismatch %s", desc.Digest)
+	}
+
+	return buf, nil
+}
+
+func pushBlob(ctx context.Context, pusher remotes.Pusher, b Blob) error {
+	w, err := pusher.Push(ctx, b.Descriptor)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to push")
+	}
+
+	defer func(w io.Closer) {
+		_ = w.Close()
+	}(w)
+
+	if _, err := w.Write(b.Data); err != nil {
+		return errors.Wrap(err, "failed to write")
+	}
+
+	if err := w.Commit(ctx, b.Descriptor.Size, b.Descriptor.Digest); err != nil && !errdefs.IsAlreadyExists(err) {
+		return errors.Wrap(err, "failed to commit")
+	}
+
+	return nil
+}
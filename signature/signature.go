@@ -0,0 +1,226 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Media types and annotations of the cosign signature format.
+const (
+	AnnotationSignature = "dev.cosignproject.cosign/signature"
+	MediaTypePayload    = "application/vnd.dev.cosign.simplesigning.v1+json"
+	PayloadType         = "cosign container image signature"
+	TagSuffix           = ".sig"
+)
+
+// Payload is the simple signing payload of a cosign signature.
+type Payload struct {
+	Critical Critical          `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+type Critical struct {
+	Identity Identity `json:"identity"`
+	Image    Image    `json:"image"`
+	Type     string   `json:"type"`
+}
+
+type Identity struct {
+	DockerReference string `json:"docker-reference"`
+}
+
+type Image struct {
+	DockerManifestDigest string `json:"docker-manifest-digest"`
+}
+
+// Tag returns the tag of the signatures of the image manifest, e.g. sha256-<hex>.sig.
+func Tag(dgst digest.Digest) string {
+	return fmt.Sprintf("%s-%s%s", dgst.Algorithm(), dgst.Encoded(), TagSuffix)
+}
+
+// NewPayload returns the payload signing the image manifest of the repository.
+func NewPayload(repo string, dgst digest.Digest) ([]byte, error) {
+	buf, err := json.Marshal(Payload{
+		Critical: Critical{
+			Identity: Identity{DockerReference: repo},
+			Image:    Image{DockerManifestDigest: dgst.String()},
+			Type:     PayloadType,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal payload")
+	}
+
+	return buf, nil
+}
+
+// Sign signs the payload with the key, hashing it with sha256 for ecdsa keys.
+func Sign(signer crypto.Signer, payload []byte) ([]byte, error) {
+	switch signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(payload)
+		return signer.Sign(rand.Reader, h[:], crypto.SHA256)
+	case ed25519.PublicKey:
+		return signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	default:
+		return nil, errors.Errorf("unsupported key type %T", signer.Public())
+	}
+}
+
+// Verify checks the signature of the payload with the public key.
+func Verify(pub crypto.PublicKey, payload, sig []byte) error {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(k, h[:], sig) {
+			return errors.New("invalid ecdsa signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return errors.New("invalid ed25519 signature")
+		}
+	default:
+		return errors.Errorf("unsupported key type %T", pub)
+	}
+
+	return nil
+}
+
+// Blob is the content of a descriptor in the signature manifest.
+type Blob struct {
+	Descriptor ispec.Descriptor
+	Data       []byte
+}
+
+// NewBlob returns the blob of the data with the media type.
+func NewBlob(mediaType string, data []byte) Blob {
+	return Blob{
+		Descriptor: ispec.Descriptor{
+			MediaType: mediaType,
+			Digest:    digest.FromBytes(data),
+			Size:      int64(len(data)),
+		},
+		Data: data,
+	}
+}
+
+// signatureConfig is the image config of the signature manifest listing the payloads as its layers.
+type signatureConfig struct {
+	Architecture string          `json:"architecture"`
+	Created      time.Time       `json:"created"`
+	History      []ispec.History `json:"history"`
+	OS           string          `json:"os"`
+	RootFS       ispec.RootFS    `json:"rootfs"`
+	Config       struct{}        `json:"config"`
+}
+
+// AppendSignature appends the signed payload to the signature manifest, which is created if nil.
+// It returns the new manifest and config blobs together with the payload blob.
+func AppendSignature(manifest *ispec.Manifest, payload, sig []byte) (mfst, config, layer Blob, err error) {
+	layer = NewBlob(MediaTypePayload, payload)
+	layer.Descriptor.Annotations = map[string]string{
+		AnnotationSignature: base64.StdEncoding.EncodeToString(sig),
+	}
+
+	m := ispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ispec.MediaTypeImageManifest,
+	}
+
+	if manifest != nil {
+		m.Layers = append(m.Layers, manifest.Layers...)
+	}
+
+	m.Layers = append(m.Layers, layer.Descriptor)
+
+	cfg := signatureConfig{
+		RootFS: ispec.RootFS{Type: "layers"},
+	}
+
+	for _, l := range m.Layers {
+		cfg.History = append(cfg.History, ispec.History{})
+		cfg.RootFS.DiffIDs = append(cfg.RootFS.DiffIDs, l.Digest)
+	}
+
+	buf, err := json.Marshal(cfg)
+	if err != nil {
+		return mfst, config, layer, errors.Wrap(err, "failed to marshal config")
+	}
+
+	config = NewBlob(ispec.MediaTypeImageConfig, buf)
+	m.Config = config.Descriptor
+
+	if buf, err = json.Marshal(m); err != nil {
+		return mfst, config, layer, errors.Wrap(err, "failed to marshal manifest")
+	}
+
+	return NewBlob(ispec.MediaTypeImageManifest, buf), config, layer, nil
+}
+
+// VerifyManifest checks that a layer of the signature manifest is a payload of the image manifest
+// signed by the public key. The payload of each layer is read with fetch.
+func VerifyManifest(manifest *ispec.Manifest, pub crypto.PublicKey, dgst digest.Digest,
+	fetch func(ispec.Descriptor) ([]byte, error)) error {
+	var errs []error
+
+	for _, l := range manifest.Layers {
+		if l.MediaType != MediaTypePayload {
+			continue
+		}
+		if err := verifyLayer(l, pub, dgst, fetch); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return nil
+	}
+
+	if len(errs) == 0 {
+		return errors.New("no signature found")
+	}
+
+	return errors.Wrap(errs[len(errs)-1], "no valid signature found")
+}
+
+func verifyLayer(l ispec.Descriptor, pub crypto.PublicKey, dgst digest.Digest, fetch func(ispec.Descriptor) ([]byte, error)) error {
+	sig, err := base64.StdEncoding.DecodeString(l.Annotations[AnnotationSignature])
+	if err != nil {
+		return errors.Wrap(err, "failed to decode signature")
+	}
+
+	payload, err := fetch(l)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch payload")
+	}
+
+	if digest.FromBytes(payload) != l.Digest {
+		return errors.Errorf("payload digest mismatch %s", l.Digest)
+	}
+
+	if err := Verify(pub, payload, sig); err != nil {
+		return errors.Wrap(err, "failed to verify signature")
+	}
+
+	var p Payload
+	if err := json.NewDecoder(bytes.NewReader(payload)).Decode(&p); err != nil {
+		return errors.Wrap(err, "failed to decode payload")
+	}
+
+	if p.Critical.Image.DockerManifestDigest != dgst.String() {
+		return errors.Errorf("payload signs %s instead of %s", p.Critical.Image.DockerManifestDigest, dgst)
+	}
+
+	return nil
+}
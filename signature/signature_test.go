@@ -0,0 +1,87 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSigners(t *testing.T) map[string]crypto.Signer {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	return map[string]crypto.Signer{"ecdsa": ecKey, "ed25519": edKey}
+}
+
+func TestTag(t *testing.T) {
+	dgst := digest.FromString("image")
+	assert.Equal(t, "sha256-"+dgst.Encoded()+".sig", Tag(dgst))
+}
+
+func TestNewPayload(t *testing.T) {
+	dgst := digest.FromString("image")
+
+	buf, err := NewPayload("docker.io/library/ubuntu", dgst)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"critical":{"identity":{"docker-reference":"docker.io/library/ubuntu"},`+
+		`"image":{"docker-manifest-digest":"`+dgst.String()+`"},"type":"cosign container image signature"},"optional":null}`, string(buf))
+}
+
+func TestSignVerify(t *testing.T) {
+	payload := []byte("payload")
+
+	for name, signer := range newTestSigners(t) {
+		t.Run(name, func(t *testing.T) {
+			sig, err := Sign(signer, payload)
+			assert.NoError(t, err)
+			assert.NoError(t, Verify(signer.Public(), payload, sig))
+			assert.Error(t, Verify(signer.Public(), []byte("tampered"), sig))
+		})
+	}
+}
+
+func TestAppendSignature(t *testing.T) {
+	signer := newTestSigners(t)["ecdsa"]
+	dgst := digest.FromString("image")
+	blobs := map[digest.Digest][]byte{}
+
+	fetch := func(d ispec.Descriptor) ([]byte, error) {
+		return blobs[d.Digest], nil
+	}
+
+	var manifest *ispec.Manifest
+
+	for _, repo := range []string{"docker.io/library/ubuntu", "ghcr.io/craftslab/ubuntu"} {
+		payload, err := NewPayload(repo, dgst)
+		assert.NoError(t, err)
+		sig, err := Sign(signer, payload)
+		assert.NoError(t, err)
+		mfst, config, layer, err := AppendSignature(manifest, payload, sig)
+		assert.NoError(t, err)
+		assert.Equal(t, ispec.MediaTypeImageManifest, mfst.Descriptor.MediaType)
+		assert.Equal(t, config.Descriptor, func() ispec.Descriptor {
+			var m ispec.Manifest
+			assert.NoError(t, json.Unmarshal(mfst.Data, &m))
+			manifest = &m
+			return m.Config
+		}())
+		blobs[layer.Descriptor.Digest] = layer.Data
+	}
+
+	assert.Len(t, manifest.Layers, 2)
+	assert.NoError(t, VerifyManifest(manifest, signer.Public(), dgst, fetch))
+	assert.Error(t, VerifyManifest(manifest, signer.Public(), digest.FromString("other"), fetch))
+	assert.Error(t, VerifyManifest(manifest, newTestSigners(t)["ecdsa"].Public(), dgst, fetch))
+	assert.Error(t, VerifyManifest(&ispec.Manifest{}, signer.Public(), dgst, fetch))
+}
@@ -0,0 +1,52 @@
+package types
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrorKind is the cause of an error, which is mapped to an exit code.
+type ErrorKind int
+
+const (
+	ErrorKindUnknown ErrorKind = iota
+	// ErrorKindBadReport is an invalid or unsupported report.
+	ErrorKindBadReport
+	// ErrorKindNoUpdates is a report without updates applicable to the image.
+	ErrorKindNoUpdates
+	// ErrorKindPartialPatch is a patch with packages or platforms failed but ignored.
+	ErrorKindPartialPatch
+	// ErrorKindBuildkitUnreachable is a buildkit endpoint failed to be connected.
+	ErrorKindBuildkitUnreachable
+	// ErrorKindExport is a patched image failed to be exported.
+	ErrorKindExport
+)
+
+// Error is an error with the kind of its cause.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+// NewError returns the error with the kind of its cause.
+func NewError(kind ErrorKind, err error) error {
+	return &Error{Kind: kind, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// GetErrorKind returns the kind of the outermost typed error in the chain of the error.
+func GetErrorKind(err error) ErrorKind {
+	var e *Error
+
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	return ErrorKindUnknown
+}
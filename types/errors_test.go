@@ -0,0 +1,36 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetErrorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind ErrorKind
+	}{
+		{"untyped", errors.New("failed"), ErrorKindUnknown},
+		{"typed", NewError(ErrorKindExport, errors.New("failed")), ErrorKindExport},
+		{"wrapped", errors.Wrap(NewError(ErrorKindBadReport, errors.New("failed")), "failed to run"), ErrorKindBadReport},
+		{"outermost", NewError(ErrorKindPartialPatch, errors.Wrap(NewError(ErrorKindExport, errors.New("failed")), "failed")),
+			ErrorKindPartialPatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.kind, GetErrorKind(tt.err))
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := NewError(ErrorKindBuildkitUnreachable, cause)
+
+	assert.Equal(t, "connection refused", err.Error())
+	assert.True(t, errors.Is(err, cause))
+}
@@ -11,6 +11,7 @@ type UpdatePackage struct {
 	Name             string `json:"name"`
 	InstalledVersion string `json:"installedVersion"`
 	UpdatedVersion   string `json:"updatedVersion"`
+	VulnerabilityID  string `json:"vulnerabilityID,omitempty"`
 }
 
 type Metadata struct {
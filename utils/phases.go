@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Phase is a step of a patch with its own timeout.
+type Phase string
+
+const (
+	PhaseResolve  Phase = "resolve"
+	PhaseProbe    Phase = "probe"
+	PhaseFetch    Phase = "fetch"
+	PhaseInstall  Phase = "install"
+	PhaseValidate Phase = "validate"
+	PhaseExport   Phase = "export"
+)
+
+const (
+	DefaultRetries = 2
+	DefaultBackoff = "2s"
+)
+
+// permanentMessages and transientMessages classify the errors received as text, e.g. from buildkitd.
+var (
+	permanentMessages = []string{"not found", "manifest unknown", "unauthorized", "forbidden", "denied"}
+	transientMessages = []string{
+		"too many requests", "internal server error", "bad gateway", "service unavailable", "gateway timeout",
+		"connection reset", "connection refused", "i/o timeout", "tls handshake timeout", "unexpected eof",
+		"temporary failure in name resolution", "network is unreachable", "no route to host",
+	}
+)
+
+// Phases are the timeouts of the phases, and the retries of the network bound ones with exponential backoff.
+type Phases struct {
+	// Timeouts of the phases, which are bound by the timeout of the patch only if missing.
+	Timeouts map[Phase]time.Duration
+	Retries  int
+	// Backoff is the delay of the first retry, which is doubled for each next one.
+	Backoff time.Duration
+	// Logger logs the retries, which is the standard logger if nil, e.g. the log of a job.
+	Logger *log.Logger
+}
+
+// PhaseEvent is the start or the end of an attempt of a phase.
+type PhaseEvent struct {
+	Phase   Phase
+	Attempt int
+	// Done is false when the attempt starts, and true with its duration and error when it ends.
+	Done     bool
+	Duration time.Duration
+	Err      error
+}
+
+type phaseHandlerKey struct{}
+
+// WithPhaseHandler returns the context whose phases report their events to the handler after the handlers of the
+// parent context. The handler is called concurrently by the phases of the platforms.
+func WithPhaseHandler(ctx context.Context, fn func(PhaseEvent)) context.Context {
+	if parent := getPhaseHandler(ctx); parent != nil {
+		return context.WithValue(ctx, phaseHandlerKey{}, func(e PhaseEvent) {
+			parent(e)
+			fn(e)
+		})
+	}
+
+	return context.WithValue(ctx, phaseHandlerKey{}, fn)
+}
+
+func getPhaseHandler(ctx context.Context) func(PhaseEvent) {
+	fn, _ := ctx.Value(phaseHandlerKey{}).(func(PhaseEvent))
+	return fn
+}
+
+// ParsePhaseTimeouts parses the timeouts of the phases, e.g. fetch=10m.
+func ParsePhaseTimeouts(timeouts map[string]string) (map[Phase]time.Duration, error) {
+	out := map[Phase]time.Duration{}
+
+	for k, v := range timeouts {
+		phase, err := ParsePhase(k)
+		if err != nil {
+			return nil, err
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid timeout of phase %s", k)
+		}
+		out[phase] = d
+	}
+
+	return out, nil
+}
+
+// ParsePhase returns the phase of the name, e.g. fetch.
+func ParsePhase(name string) (Phase, error) {
+	phase := Phase(strings.ToLower(name))
+
+	switch phase {
+	case PhaseResolve, PhaseProbe, PhaseFetch, PhaseInstall, PhaseValidate, PhaseExport:
+		return phase, nil
+	default:
+		return "", errors.Errorf("unknown phase %s", name)
+	}
+}
+
+// Run runs the function in the phase with its timeout for each attempt, and retries it with exponential backoff
+// on transient errors if it is network bound. The phases may be nil to run the function once without a timeout
+// of the phase.
+func (p *Phases) Run(ctx context.Context, phase Phase, retry bool, fn func(context.Context) error) error {
+	retries := 0
+	if p != nil && retry {
+		retries = p.Retries
+	}
+
+	var err error
+
+	handler := getPhaseHandler(ctx)
+
+	var logger *log.Logger
+	if p != nil {
+		logger = p.Logger
+	}
+
+	for i := 0; ; i++ {
+		started := time.Now()
+		if handler != nil {
+			handler(PhaseEvent{Phase: phase, Attempt: i + 1})
+		}
+		err = p.run(ctx, phase, fn)
+		if handler != nil {
+			handler(PhaseEvent{Phase: phase, Attempt: i + 1, Done: true, Duration: time.Since(started), Err: err})
+		}
+		if err == nil {
+			return nil
+		}
+		if i >= retries || ctx.Err() != nil || !IsTransient(err) {
+			break
+		}
+		delay := p.Backoff << i
+		GetLogger(logger).Printf("%s failed, retrying in %s (%d/%d): %v", phase, delay, i+1, retries, err)
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(err, "phase %s canceled", phase)
+		case <-time.After(delay):
+		}
+	}
+
+	return err
+}
+
+// IsTransient returns whether the error may not recur, i.e. a network error, a timeout, or a 5xx or 429 status
+// of a registry. The missing images and the denied requests are not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// The attempt exceeded the timeout of the phase
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	if errdefs.IsNotFound(err) || errdefs.IsInvalidArgument(err) {
+		return false
+	}
+
+	var unexpected remoteserrors.ErrUnexpectedStatus
+	if errors.As(err, &unexpected) {
+		return unexpected.StatusCode == http.StatusTooManyRequests || unexpected.StatusCode >= http.StatusInternalServerError
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted:
+			return true
+		case codes.NotFound, codes.PermissionDenied, codes.Unauthenticated, codes.InvalidArgument:
+			return false
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+
+	for _, m := range permanentMessages {
+		if strings.Contains(msg, m) {
+			return false
+		}
+	}
+
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Phases) run(ctx context.Context, phase Phase, fn func(context.Context) error) error {
+	if p == nil || p.Timeouts[phase] == 0 {
+		return fn(ctx)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, p.Timeouts[phase])
+	defer cancel()
+
+	if err := fn(timeoutCtx); err != nil {
+		if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return errors.Wrapf(err, "phase %s exceeded timeout %s", phase, p.Timeouts[phase])
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestParsePhaseTimeouts(t *testing.T) {
+	got, err := ParsePhaseTimeouts(map[string]string{"fetch": "10m", "Export": "30s"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[Phase]time.Duration{PhaseFetch: 10 * time.Minute, PhaseExport: 30 * time.Second}, got)
+
+	_, err = ParsePhaseTimeouts(map[string]string{"download": "10m"})
+	assert.Error(t, err)
+
+	_, err = ParsePhaseTimeouts(map[string]string{"fetch": "ten minutes"})
+	assert.Error(t, err)
+}
+
+func TestPhasesRun(t *testing.T) {
+	phases := &Phases{Retries: 2, Backoff: time.Millisecond}
+
+	t.Run("retry", func(t *testing.T) {
+		attempts := 0
+		err := phases.Run(context.Background(), PhaseFetch, true, func(context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("connection reset")
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("logger", func(t *testing.T) {
+		var buf bytes.Buffer
+		p := &Phases{Retries: 1, Backoff: time.Millisecond, Logger: log.New(&buf, "", 0)}
+		attempts := 0
+		err := p.Run(context.Background(), PhaseFetch, true, func(context.Context) error {
+			attempts++
+			if attempts < 2 {
+				return errors.New("connection reset")
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "fetch failed, retrying in 1ms (1/1): connection reset\n", buf.String())
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		attempts := 0
+		err := phases.Run(context.Background(), PhaseFetch, true, func(context.Context) error {
+			attempts++
+			return errors.New("connection reset")
+		})
+		assert.EqualError(t, err, "connection reset")
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("not transient", func(t *testing.T) {
+		attempts := 0
+		err := phases.Run(context.Background(), PhaseResolve, true, func(context.Context) error {
+			attempts++
+			return errors.Wrap(errdefs.ErrNotFound, "docker.io/library/missing:latest")
+		})
+		assert.ErrorIs(t, err, errdefs.ErrNotFound)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("no retry", func(t *testing.T) {
+		attempts := 0
+		err := phases.Run(context.Background(), PhaseInstall, false, func(context.Context) error {
+			attempts++
+			return errors.New("failed")
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		p := &Phases{Timeouts: map[Phase]time.Duration{PhaseFetch: time.Millisecond}}
+		err := p.Run(context.Background(), PhaseFetch, true, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		assert.ErrorContains(t, err, "phase fetch exceeded timeout 1ms")
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		attempts := 0
+		err := phases.Run(ctx, PhaseFetch, true, func(ctx context.Context) error {
+			attempts++
+			return ctx.Err()
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var p *Phases
+		attempts := 0
+		err := p.Run(context.Background(), PhaseFetch, true, func(context.Context) error {
+			attempts++
+			return errors.New("failed")
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestWithPhaseHandler(t *testing.T) {
+	phases := &Phases{Retries: 1, Backoff: time.Millisecond}
+
+	var first, second []PhaseEvent
+
+	ctx := WithPhaseHandler(context.Background(), func(e PhaseEvent) {
+		first = append(first, e)
+	})
+	ctx = WithPhaseHandler(ctx, func(e PhaseEvent) {
+		second = append(second, e)
+	})
+
+	attempts := 0
+	err := phases.Run(ctx, PhaseProbe, true, func(context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("503 Service Unavailable")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 4, len(first))
+	assert.Equal(t, first, second)
+	assert.Equal(t, PhaseEvent{Phase: PhaseProbe, Attempt: 1}, first[0])
+	assert.Equal(t, true, first[1].Done)
+	assert.Error(t, first[1].Err)
+	assert.Equal(t, 2, first[3].Attempt)
+	assert.Equal(t, true, first[3].Done)
+	assert.NoError(t, first[3].Err)
+}
+
+func TestIsTransient(t *testing.T) {
+	status := func(code int) error {
+		return remoteserrors.ErrUnexpectedStatus{Status: http.StatusText(code), StatusCode: code}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "deadline exceeded", err: errors.Wrap(context.DeadlineExceeded, "phase resolve exceeded timeout 1m"), want: true},
+		{name: "not found", err: errors.Wrap(errdefs.ErrNotFound, "failed to resolve"), want: false},
+		{name: "too many requests", err: status(http.StatusTooManyRequests), want: true},
+		{name: "server error", err: status(http.StatusBadGateway), want: true},
+		{name: "unauthorized status", err: status(http.StatusUnauthorized), want: false},
+		{name: "forbidden status", err: status(http.StatusForbidden), want: false},
+		{name: "grpc unavailable", err: grpcstatus.Error(codes.Unavailable, "connection error"), want: true},
+		{name: "grpc permission denied", err: grpcstatus.Error(codes.PermissionDenied, "denied"), want: false},
+		{name: "connection reset", err: errors.Wrap(syscall.ECONNRESET, "read"), want: true},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, want: true},
+		{name: "manifest unknown", err: errors.New("docker.io/library/ubuntu:missing: manifest unknown"), want: false},
+		{name: "unauthorized", err: errors.New("failed to authorize: 401 Unauthorized"), want: false},
+		{name: "service unavailable", err: errors.New("unexpected status: 503 Service Unavailable"), want: true},
+		{name: "i/o timeout", err: errors.New("dial tcp 10.0.0.1:443: i/o timeout"), want: true},
+		{name: "unknown", err: errors.New("exit code: 100"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsTransient(tt.err))
+		})
+	}
+}
@@ -2,10 +2,12 @@ package utils
 
 import (
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 
 	"github.com/moby/buildkit/client/llb"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 )
 
@@ -60,3 +62,25 @@ func getEnvAny(names ...string) string {
 
 	return ""
 }
+
+func GetFileDigest(name string) (digest.Digest, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open file")
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	return digest.FromReader(f)
+}
+
+// GetLogger returns the logger, or the standard logger if nil.
+func GetLogger(logger *log.Logger) *log.Logger {
+	if logger == nil {
+		return log.Default()
+	}
+
+	return logger
+}
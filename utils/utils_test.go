@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/moby/buildkit/client/llb"
+	"github.com/opencontainers/go-digest"
 )
 
 const (
@@ -187,3 +188,29 @@ func TestGetProxy(t *testing.T) {
 func TestGetEnvAny(t *testing.T) {
 	// TODO: FIXME
 }
+
+func TestGetFileDigest(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    digest.Digest
+		wantErr bool
+	}{
+		{"NonEmptyFile", nonemptyFile, digest.FromString("This is a non-empty test file"), false},
+		{"EmptyFile", emptyFile, digest.FromString(""), false},
+		{"MissingFile", "does_not_exist", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetFileDigest(path.Join(testRootDir, tt.file))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetFileDigest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GetFileDigest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package vex
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Context is the json-ld context of the OpenVEX specification version.
+	Context = "https://openvex.dev/ns/v0.2.0"
+	// idPrefix is the prefix of the document id, which is followed by a hash of its content.
+	idPrefix = "https://openvex.dev/docs/public/vex-"
+)
+
+// Statuses of the statements used by copatcher.
+const (
+	StatusFixed              = "fixed"
+	StatusUnderInvestigation = "under_investigation"
+)
+
+// Document is an OpenVEX document.
+type Document struct {
+	Context    string      `json:"@context"`
+	ID         string      `json:"@id"`
+	Author     string      `json:"author"`
+	Timestamp  string      `json:"timestamp"`
+	Version    int         `json:"version"`
+	Tooling    string      `json:"tooling,omitempty"`
+	Statements []Statement `json:"statements"`
+}
+
+// Statement is the status of a vulnerability in the products.
+type Statement struct {
+	Vulnerability Vulnerability `json:"vulnerability"`
+	Products      []Product     `json:"products"`
+	Status        string        `json:"status"`
+}
+
+// Vulnerability is the vulnerability of a statement, e.g. CVE-2023-5678.
+type Vulnerability struct {
+	Name string `json:"name"`
+}
+
+// Product is the product and its subcomponents a statement applies to.
+type Product struct {
+	ID            string      `json:"@id"`
+	Subcomponents []Component `json:"subcomponents,omitempty"`
+}
+
+// Component is a subcomponent of a product.
+type Component struct {
+	ID string `json:"@id"`
+}
+
+// New returns an empty document authored with the tooling.
+func New(author, tooling string, created time.Time) *Document {
+	return &Document{
+		Context:    Context,
+		Author:     author,
+		Timestamp:  created.UTC().Format(time.RFC3339),
+		Version:    1,
+		Tooling:    tooling,
+		Statements: []Statement{},
+	}
+}
+
+// Add records the status of the vulnerability in the subcomponent of the product.
+// A vulnerability which is under investigation in any subcomponent of the product is not fixed.
+func (d *Document) Add(vuln, status, product, subcomponent string) {
+	for i := range d.Statements {
+		s := &d.Statements[i]
+		if s.Vulnerability.Name != vuln || s.Products[0].ID != product {
+			continue
+		}
+		if status == StatusUnderInvestigation {
+			s.Status = status
+		}
+		c := Component{ID: subcomponent}
+		if !slices.Contains(s.Products[0].Subcomponents, c) {
+			s.Products[0].Subcomponents = append(s.Products[0].Subcomponents, c)
+		}
+		return
+	}
+
+	d.Statements = append(d.Statements, Statement{
+		Vulnerability: Vulnerability{Name: vuln},
+		Products: []Product{
+			{ID: product, Subcomponents: []Component{{ID: subcomponent}}},
+		},
+		Status: status,
+	})
+}
+
+// Marshal returns the document with its statements sorted by vulnerability, identified by the hash of its content.
+func (d *Document) Marshal() ([]byte, error) {
+	sort.SliceStable(d.Statements, func(i, j int) bool {
+		return d.Statements[i].Vulnerability.Name < d.Statements[j].Vulnerability.Name
+	})
+
+	d.ID = ""
+
+	buf, err := json.Marshal(d)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal document")
+	}
+
+	d.ID = idPrefix + digest.FromBytes(buf).Encoded()
+
+	if buf, err = json.MarshalIndent(d, "", "  "); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal document")
+	}
+
+	return buf, nil
+}
+
+// ImageURL returns the package url of the image manifest in the repository,
+// e.g. pkg:oci/ubuntu@sha256%3A...?repository_url=docker.io%2Flibrary%2Fubuntu.
+func ImageURL(repo string, dgst digest.Digest) string {
+	return "pkg:oci/" + path.Base(repo) + "@" + url.QueryEscape(dgst.String()) + "?repository_url=" + url.QueryEscape(repo)
+}
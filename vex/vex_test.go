@@ -0,0 +1,54 @@
+package vex
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdd(t *testing.T) {
+	d := New("copatcher", "copatcher 1.0.0", time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC))
+
+	d.Add("CVE-2023-5678", StatusFixed, "pkg:oci/ubuntu", "pkg:deb/ubuntu/openssl@3.0.2")
+	d.Add("CVE-2023-5678", StatusFixed, "pkg:oci/ubuntu", "pkg:deb/ubuntu/libssl3@3.0.2")
+	d.Add("CVE-2023-5678", StatusFixed, "pkg:oci/ubuntu", "pkg:deb/ubuntu/openssl@3.0.2")
+	d.Add("CVE-2023-38545", StatusFixed, "pkg:oci/ubuntu", "pkg:deb/ubuntu/curl@7.81.0")
+	d.Add("CVE-2023-38545", StatusUnderInvestigation, "pkg:oci/ubuntu", "pkg:deb/ubuntu/libcurl4@7.81.0")
+	d.Add("CVE-2023-38545", StatusFixed, "pkg:oci/ubuntu", "pkg:deb/ubuntu/curl@7.81.0")
+
+	assert.Len(t, d.Statements, 2)
+	assert.Equal(t, StatusFixed, d.Statements[0].Status)
+	assert.Len(t, d.Statements[0].Products[0].Subcomponents, 2)
+	assert.Equal(t, StatusUnderInvestigation, d.Statements[1].Status)
+	assert.Len(t, d.Statements[1].Products[0].Subcomponents, 2)
+}
+
+func TestMarshal(t *testing.T) {
+	d := New("copatcher", "copatcher 1.0.0", time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC))
+	d.Add("CVE-2023-5678", StatusFixed, "pkg:oci/ubuntu", "pkg:deb/ubuntu/openssl@3.0.2")
+	d.Add("CVE-2023-38545", StatusFixed, "pkg:oci/ubuntu", "pkg:deb/ubuntu/curl@7.81.0")
+
+	buf, err := d.Marshal()
+	assert.NoError(t, err)
+
+	var out Document
+	assert.NoError(t, json.Unmarshal(buf, &out))
+	assert.Equal(t, Context, out.Context)
+	assert.Regexp(t, "^"+idPrefix+"[0-9a-f]{64}$", out.ID)
+	assert.Equal(t, "2024-03-01T08:00:00Z", out.Timestamp)
+	assert.Equal(t, "CVE-2023-38545", out.Statements[0].Vulnerability.Name)
+	assert.Equal(t, "CVE-2023-5678", out.Statements[1].Vulnerability.Name)
+
+	again, err := d.Marshal()
+	assert.NoError(t, err)
+	assert.Equal(t, buf, again)
+}
+
+func TestImageURL(t *testing.T) {
+	dgst := digest.FromString("patched")
+	assert.Equal(t, "pkg:oci/ubuntu@sha256%3A"+dgst.Encoded()+"?repository_url=docker.io%2Flibrary%2Fubuntu",
+		ImageURL("docker.io/library/ubuntu", dgst))
+}